@@ -0,0 +1,488 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	mathbits "math/bits"
+	"slices"
+
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/prysmaticlabs/go-bitfield"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrUnknownCommittee is matched by an UnknownCommitteeError.
+var ErrUnknownCommittee = errors.New("unknown committee")
+
+// UnknownCommitteeError is a committee bit set for a committee that does not
+// exist at the attestation's slot.
+type UnknownCommitteeError struct {
+	Slot  phase0.Slot
+	Index phase0.CommitteeIndex
+}
+
+func (e *UnknownCommitteeError) Error() string {
+	return fmt.Sprintf("unknown committee %d at slot %d", e.Index, e.Slot)
+}
+
+func (e *UnknownCommitteeError) Is(target error) bool {
+	return target == ErrUnknownCommittee
+}
+
+// ErrNonZeroDataIndex is matched by a NonZeroDataIndexError.
+var ErrNonZeroDataIndex = errors.New("non-zero attestation data index")
+
+// NonZeroDataIndexError is an Electra attestation whose data carries a
+// committee index. From Electra the committees are only given by the committee
+// bits and the index must be zero, so such an attestation was likely built
+// with pre-Electra rules.
+type NonZeroDataIndexError struct {
+	Slot  phase0.Slot
+	Index phase0.CommitteeIndex
+}
+
+func (e *NonZeroDataIndexError) Error() string {
+	return fmt.Sprintf("electra attestation at slot %d has data index %d, want 0", e.Slot, e.Index)
+}
+
+func (e *NonZeroDataIndexError) Is(target error) bool {
+	return target == ErrNonZeroDataIndex
+}
+
+// ErrTooManyCommittees is matched by a TooManyCommitteesError.
+var ErrTooManyCommittees = errors.New("committee bit set beyond the committees per slot")
+
+// TooManyCommitteesError is an Electra attestation with a committee bit set at
+// Index, beyond the Max committees its slot can have: MAX_COMMITTEES_PER_SLOT,
+// or fewer when the slot's committees are known, as on a minimal preset
+// network whose slots have a committee or two. No committee answers to such a
+// bit, so the attestation cannot be well formed.
+type TooManyCommitteesError struct {
+	Slot  phase0.Slot
+	Index phase0.CommitteeIndex
+	Max   uint64
+}
+
+func (e *TooManyCommitteesError) Error() string {
+	return fmt.Sprintf("electra attestation at slot %d sets committee bit %d, beyond the %d committees the slot can have", e.Slot, e.Index, e.Max)
+}
+
+func (e *TooManyCommitteesError) Is(target error) bool {
+	return target == ErrTooManyCommittees
+}
+
+// ErrEmptyCommitteeBits is an Electra attestation with no committee bits set,
+// which covers no committee at all.
+var ErrEmptyCommitteeBits = errors.New("no committee bits set")
+
+// Mismatch is an attestation whose aggregation bits length disagrees with the
+// summed length of the committees it claims to cover, or which is malformed or
+// could not be checked at all, in which case Err says why and Computed only
+// covers the committees that were known.
+type Mismatch struct {
+	BlockSlot        phase0.Slot
+	DutySlot         phase0.Slot
+	CommitteeIndices []phase0.CommitteeIndex
+	Computed         uint64
+	Actual           uint64
+	Err              error
+	AggregationBits  bitfield.Bitlist
+	// CommitteeBits is nil before Electra, where an attestation covers the
+	// single committee in its data.
+	CommitteeBits bitfield.Bitvector64
+	// Pool is set for attestations from the beacon node's attestation pool,
+	// which are in no block yet and so have no BlockSlot.
+	Pool bool
+}
+
+// Delta is how far the actual aggregation bits length is from the computed
+// one: positive for an overshoot, negative for an undershoot.
+func (m Mismatch) Delta() int64 {
+	return lengthDelta(m.Computed, m.Actual)
+}
+
+// Direction classifies the length discrepancy as "overshoot" (more bits than
+// the committees have members) or "undershoot", or is empty if the lengths
+// agree, as they can for a malformed attestation.
+func (m Mismatch) Direction() string {
+	return lengthDirection(m.Computed, m.Actual)
+}
+
+func lengthDelta(computed uint64, actual uint64) int64 {
+	return int64(actual) - int64(computed)
+}
+
+func lengthDirection(computed uint64, actual uint64) string {
+	switch {
+	case actual > computed:
+		return "overshoot"
+	case actual < computed:
+		return "undershoot"
+	}
+	return ""
+}
+
+// AttestationReport is the aggregation bits check for a single attestation.
+type AttestationReport struct {
+	CommitteeIndices []phase0.CommitteeIndex `json:"committee_indices"`
+	ExpectedLength   uint64                  `json:"expected_length"`
+	ActualLength     uint64                  `json:"actual_length"`
+	Mismatch         bool                    `json:"mismatch"`
+	// Delta and Direction are Mismatch.Delta and Mismatch.Direction, set
+	// only for a mismatch.
+	Delta         int64  `json:"delta,omitempty"`
+	Direction     string `json:"direction,omitempty"`
+	AttesterCount uint64 `json:"attester_count"`
+	// Error is set when the attestation is malformed in a way that makes the
+	// length check meaningless, such as referencing an unknown committee.
+	Error string `json:"error,omitempty"`
+	// Committee is only filled in by FilterReportsByCommittee.
+	Committee *CommitteeSegment `json:"committee,omitempty"`
+	err       error
+
+	slot            phase0.Slot
+	aggregationBits bitfield.Bitlist
+	committeeBits   bitfield.Bitvector64
+}
+
+// ExpectedAggregationBitsLen returns the aggregation bits length attestation
+// should have: the summed size of exactly the committees set in its committee
+// bits, at its slot. It returns an *UnknownCommitteeError for the first
+// committee that does not exist, along with the sum over those that do, and
+// ErrEmptyCommitteeBits if no committee bit is set. It is the computation the
+// mismatch check makes for every Electra attestation, there from sizes worked
+// out once per batch of blocks.
+func ExpectedAggregationBitsLen(attestation *electra.Attestation, committees Committees) (uint64, error) {
+	slot := attestation.Data.Slot
+	return expectedAggregationBitsLen(attestation, newCommitteeSizes(Committees{slot: committees[slot]}))
+}
+
+// expectedAggregationBitsLen is ExpectedAggregationBitsLen with the committee
+// sizes already worked out.
+func expectedAggregationBitsLen(attestation *electra.Attestation, sizes committeeSizes) (uint64, error) {
+	slot := attestation.Data.Slot
+	bits := attestation.CommitteeBits.BitIndices()
+	if len(bits) == 0 {
+		return 0, fmt.Errorf("attestation at slot %d: %w", slot, ErrEmptyCommitteeBits)
+	}
+	indices := make([]phase0.CommitteeIndex, 0, len(bits))
+	for _, bit := range bits {
+		indices = append(indices, phase0.CommitteeIndex(bit))
+	}
+	return sizes.length(slot, indices)
+}
+
+// maxCommitteesPerSlot is mainnet's MAX_COMMITTEES_PER_SLOT, which is also the
+// size of an Electra attestation's committee bits and so bounds every
+// network's.
+const maxCommitteesPerSlot = 64
+
+// slotCommitteeSizes is the size of each committee at a slot, with known
+// recording which committees exist.
+type slotCommitteeSizes struct {
+	total uint64
+	known uint64
+	sizes [maxCommitteesPerSlot]uint32
+}
+
+// committeeSizes holds the committee sizes for each slot. It is computed once
+// per batch of blocks so that checking an attestation costs one lookup for its
+// slot and one array access per committee it covers.
+type committeeSizes map[phase0.Slot]*slotCommitteeSizes
+
+func newCommitteeSizes(committees Committees) committeeSizes {
+	sizes := make(committeeSizes, len(committees))
+	backing := make([]slotCommitteeSizes, len(committees))
+	i := 0
+	for slot, slotCommittees := range committees {
+		slotSizes := &backing[i]
+		i++
+		for index, validators := range slotCommittees {
+			// Beyond the spec's limit; such a committee cannot be attested to.
+			if index >= maxCommitteesPerSlot {
+				continue
+			}
+			slotSizes.sizes[index] = uint32(len(validators))
+			slotSizes.known |= 1 << index
+			slotSizes.total += uint64(len(validators))
+		}
+		sizes[slot] = slotSizes
+	}
+	return sizes
+}
+
+// total is the summed size of every committee at slot.
+func (c committeeSizes) total(slot phase0.Slot) uint64 {
+	if slotSizes, ok := c[slot]; ok {
+		return slotSizes.total
+	}
+	return 0
+}
+
+// count is how many committees slot has: MAX_COMMITTEES_PER_SLOT, or one more
+// than the highest index known if its committees are.
+func (c committeeSizes) count(slot phase0.Slot) uint64 {
+	if slotSizes, ok := c[slot]; ok && slotSizes.known != 0 {
+		return min(specMaxCommitteesPerSlot, uint64(mathbits.Len64(slotSizes.known)))
+	}
+	return specMaxCommitteesPerSlot
+}
+
+// length sums the sizes of the given committees at slot. It returns an
+// *UnknownCommitteeError for the first committee that does not exist, along
+// with the sum over those that do.
+func (c committeeSizes) length(slot phase0.Slot, committeeIndices []phase0.CommitteeIndex) (uint64, error) {
+	var err error
+	length := uint64(0)
+	slotSizes := c[slot]
+	for _, index := range committeeIndices {
+		if slotSizes == nil || index >= maxCommitteesPerSlot || slotSizes.known&(1<<index) == 0 {
+			if err == nil {
+				err = &UnknownCommitteeError{Slot: slot, Index: index}
+			}
+			continue
+		}
+		length += uint64(slotSizes.sizes[index])
+	}
+	return length, err
+}
+
+// checkBlockAttestations checks the attestations in block for its duty slot
+// (the slot before it) against the committees for that slot. Attestations for
+// other slots are ignored.
+func checkBlockAttestations(block *spec.VersionedSignedBeaconBlock, sizes committeeSizes) (phase0.Slot, []AttestationReport, error) {
+	return checkAttestations(block, sizes, true)
+}
+
+// checkAttestations checks the attestations in block against the committees
+// for the slots they attest to, only looking at the block's duty slot if
+// dutySlotOnly is set.
+func checkAttestations(block *spec.VersionedSignedBeaconBlock, sizes committeeSizes, dutySlotOnly bool) (phase0.Slot, []AttestationReport, error) {
+	blockSlot, err := block.Slot()
+	if err != nil {
+		return 0, nil, err
+	}
+	// Attestations for a slot duty appear on the following blocks.
+	dutySlot := blockSlot - 1
+
+	attestations, err := block.Attestations()
+	if err != nil {
+		return blockSlot, nil, err
+	}
+
+	reports := make([]AttestationReport, 0, len(attestations))
+	for _, attestation := range attestations {
+		data, err := attestation.Data()
+		if err != nil {
+			log.Error().Err(err).Uint64("slot", uint64(blockSlot)).Msg("failed reading attestation data")
+			continue
+		}
+		// Only include attestations that match the duty slot.
+		if dutySlotOnly && data.Slot != dutySlot {
+			continue
+		}
+
+		report, err := checkAttestation(attestation, data, sizes)
+		if err != nil {
+			log.Error().Err(err).Uint64("slot", uint64(blockSlot)).Msg("failed reading attestation")
+			continue
+		}
+		reports = append(reports, report)
+	}
+	return blockSlot, reports, nil
+}
+
+// checkAttestation checks the aggregation bits length of attestation, whose
+// data has already been read, against the committees for its slot. It only
+// returns an error if the attestation cannot be read; problems with its
+// contents are recorded in the report.
+func checkAttestation(attestation *spec.VersionedAttestation, data *phase0.AttestationData, sizes committeeSizes) (AttestationReport, error) {
+	committeeIndices, err := AttestationCommitteeIndices(attestation)
+	if err != nil {
+		return AttestationReport{}, fmt.Errorf("reading committees: %w", err)
+	}
+	aggregationBits, err := attestation.AggregationBits()
+	if err != nil {
+		return AttestationReport{}, fmt.Errorf("reading aggregation bits: %w", err)
+	}
+
+	var committeesLen uint64
+	if attestation.Version >= spec.DataVersionElectra {
+		committeesLen, err = expectedAggregationBitsLen(attestation.Electra, sizes)
+	} else {
+		// Before Electra an attestation covers the one committee in its data.
+		committeesLen, err = sizes.length(data.Slot, committeeIndices)
+	}
+	if attestation.Version >= spec.DataVersionElectra && data.Index != 0 {
+		err = errors.Join(&NonZeroDataIndexError{Slot: data.Slot, Index: data.Index}, err)
+	}
+	if attestation.Version >= spec.DataVersionElectra && len(committeeIndices) > 0 {
+		// Committee bits are in index order, so the last is the highest.
+		if highest, limit := committeeIndices[len(committeeIndices)-1], sizes.count(data.Slot); uint64(highest) >= limit {
+			err = errors.Join(&TooManyCommitteesError{Slot: data.Slot, Index: highest, Max: limit}, err)
+		}
+	}
+	if bitlistErr := CheckBitlist(aggregationBits); bitlistErr != nil {
+		err = errors.Join(fmt.Errorf("aggregation bits of attestation at slot %d: %w", data.Slot, bitlistErr), err)
+	}
+	report := AttestationReport{
+		CommitteeIndices: committeeIndices,
+		ExpectedLength:   committeesLen,
+		ActualLength:     aggregationBits.Len(),
+		Mismatch:         aggregationBits.Len() != committeesLen,
+		Delta:            lengthDelta(committeesLen, aggregationBits.Len()),
+		Direction:        lengthDirection(committeesLen, aggregationBits.Len()),
+		AttesterCount:    aggregationBits.Count(),
+		err:              err,
+		slot:             data.Slot,
+		aggregationBits:  aggregationBits,
+	}
+	if attestation.Version >= spec.DataVersionElectra {
+		report.committeeBits, _ = attestation.CommitteeBits()
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	return report, nil
+}
+
+// FindAggregationMismatches checks the attestations for each block's duty slot
+// (the slot before it) against the committees for that slot, returning the
+// mismatches in block slot order. Attestations for other slots are ignored.
+func FindAggregationMismatches(blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, committees Committees) []Mismatch {
+	var mismatches []Mismatch
+	sizes := newCommitteeSizes(committees)
+	for _, slot := range slices.Sorted(maps.Keys(blocks)) {
+		block := blocks[slot]
+		blockSlot, reports, err := checkBlockAttestations(block, sizes)
+		if err != nil {
+			log.Error().Err(err).Uint64("slot", uint64(slot)).Msg("failed reading block")
+			continue
+		}
+		mismatches = append(mismatches, reportMismatches(blockSlot, reports)...)
+	}
+	return mismatches
+}
+
+// FindEpochMismatches checks the attestations for every duty slot of epoch.
+// Each is checked in the block right after it, so the block at the epoch's
+// first slot, whose duty slot belongs to the previous epoch, is left out and
+// the first block of the next epoch is taken from blocks if it is there, as
+// WithNextEpochBlocks puts it, and fetched otherwise. Only epoch's committees
+// are needed.
+func FindEpochMismatches(ctx context.Context, service BeaconClient, epoch phase0.Epoch, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, committees Committees) ([]Mismatch, error) {
+	dutyBlocks := make(map[phase0.Slot]*spec.VersionedSignedBeaconBlock, len(blocks))
+	for slot, block := range blocks {
+		if slot > EpochLowestSlot(epoch) && slot <= EpochHighestSlot(epoch)+1 {
+			dutyBlocks[slot] = block
+		}
+	}
+
+	next := EpochHighestSlot(epoch) + 1
+	if _, ok := dutyBlocks[next]; ok {
+		return FindAggregationMismatches(dutyBlocks, committees), nil
+	}
+	block, err := GetBlockWithRetry(ctx, service, next, retryAttempts, retryBaseDelay)
+	switch {
+	case errors.Is(err, ErrMissedSlot):
+		// The next slot was missed, so nothing attests to the last one.
+	case err != nil:
+		return nil, fmt.Errorf("epoch %d: fetching block at slot %d: %w", epoch, next, err)
+	default:
+		dutyBlocks[next] = block
+	}
+	return FindAggregationMismatches(dutyBlocks, committees), nil
+}
+
+// CheckBlock checks every attestation in block, whatever slot it attests to,
+// against the committees for that slot.
+func CheckBlock(block *spec.VersionedSignedBeaconBlock, committees Committees) ([]Mismatch, error) {
+	blockSlot, reports, err := checkAttestations(block, newCommitteeSizes(committees), false)
+	if err != nil {
+		return nil, err
+	}
+	return reportMismatches(blockSlot, reports), nil
+}
+
+func reportMismatches(blockSlot phase0.Slot, reports []AttestationReport) []Mismatch {
+	var mismatches []Mismatch
+	for _, report := range reports {
+		if report.Mismatch || report.err != nil {
+			mismatches = append(mismatches, Mismatch{
+				BlockSlot:        blockSlot,
+				DutySlot:         report.slot,
+				CommitteeIndices: report.CommitteeIndices,
+				Computed:         report.ExpectedLength,
+				Actual:           report.ActualLength,
+				Err:              report.err,
+				AggregationBits:  report.aggregationBits,
+				CommitteeBits:    report.committeeBits,
+			})
+		}
+	}
+	return mismatches
+}
+
+// IncludedAttestation is an attestation together with the slot of the block
+// it was included in.
+type IncludedAttestation struct {
+	Attestation   *electra.Attestation
+	InclusionSlot phase0.Slot
+}
+
+// GatherAttestationsByDutySlot groups the attestations in every Electra block
+// by the slot they attest to. An attestation for slot S can be included in
+// any block from S+1 until the end of the following epoch, so this picks up
+// late inclusions that the per-block duty slot check misses. Within a duty
+// slot attestations are ordered by the slot of the block they appeared in.
+func GatherAttestationsByDutySlot(blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock) map[phase0.Slot][]IncludedAttestation {
+	result := make(map[phase0.Slot][]IncludedAttestation)
+	for _, slot := range slices.Sorted(maps.Keys(blocks)) {
+		block := blocks[slot]
+		if block.Version < spec.DataVersionElectra || block.Electra == nil {
+			continue
+		}
+		for _, attestation := range block.Electra.Message.Body.Attestations {
+			result[attestation.Data.Slot] = append(result[attestation.Data.Slot], IncludedAttestation{
+				Attestation:   attestation,
+				InclusionSlot: slot,
+			})
+		}
+	}
+	return result
+}
+
+// attestationsOf strips the inclusion slots from included.
+func attestationsOf(included []IncludedAttestation) []*electra.Attestation {
+	attestations := make([]*electra.Attestation, 0, len(included))
+	for _, attestation := range included {
+		attestations = append(attestations, attestation.Attestation)
+	}
+	return attestations
+}
+
+// LogMismatches writes each mismatch to the error log.
+func LogMismatches(mismatches []Mismatch) {
+	for _, mismatch := range mismatches {
+		event := log.Error().Str("aggregation_bits", FormatBitlist(mismatch.AggregationBits))
+		if mismatch.CommitteeBits != nil {
+			event = event.Str("committee_bits", FormatCommitteeBits(mismatch.CommitteeBits))
+		}
+		blockSlot := fmt.Sprint(mismatch.BlockSlot)
+		if mismatch.Pool {
+			blockSlot = "pool"
+		}
+		if direction := mismatch.Direction(); direction != "" {
+			event = event.Int64("delta", mismatch.Delta()).Str("direction", direction)
+		}
+		if mismatch.Err != nil {
+			event.Err(mismatch.Err).Msgf("invalid attestation (attestation.slot=%v block.slot=%v): computed=%v actual=%v", mismatch.DutySlot, blockSlot, mismatch.Computed, mismatch.Actual)
+			continue
+		}
+		event.Msgf("length mismatch (attestation.slot=%v block.slot=%v): computed=%v actual=%v", mismatch.DutySlot, blockSlot, mismatch.Computed, mismatch.Actual)
+	}
+}
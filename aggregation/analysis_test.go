@@ -0,0 +1,300 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/prysmaticlabs/go-bitfield"
+
+	"repro/internal/testutil"
+)
+
+func attestation(slot phase0.Slot, committees []uint64, aggregationBitsLen uint64) *electra.Attestation {
+	committeeBits := bitfield.NewBitvector64()
+	for _, index := range committees {
+		committeeBits.SetBitAt(index, true)
+	}
+	return &electra.Attestation{
+		AggregationBits: bitfield.NewBitlist(aggregationBitsLen),
+		Data: &phase0.AttestationData{
+			Slot:   slot,
+			Source: &phase0.Checkpoint{},
+			Target: &phase0.Checkpoint{},
+		},
+		CommitteeBits: committeeBits,
+	}
+}
+
+func TestFindAggregationMismatches(t *testing.T) {
+	client := testutil.NewFakeClient().
+		WithCommittee(1, 0, []phase0.ValidatorIndex{1, 2, 3}).
+		WithCommittee(1, 1, []phase0.ValidatorIndex{4, 5}).
+		WithCommittee(2, 0, []phase0.ValidatorIndex{6, 7, 8, 9}).
+		WithBlock(2,
+			attestation(1, []uint64{0, 1}, 5),
+			attestation(1, []uint64{0, 1}, 4),
+			// Not for the duty slot, so ignored despite being wrong.
+			attestation(0, []uint64{0}, 100),
+		).
+		WithBlock(3, attestation(2, []uint64{0}, 4))
+
+	blocks, err := ListEpochBlocks(context.Background(), client, 0)
+	if err != nil {
+		t.Fatalf("ListEpochBlocks: %v", err)
+	}
+	committees, err := GetBeaconCommitees(context.Background(), client, 0, 0)
+	if err != nil {
+		t.Fatalf("GetBeaconCommitees: %v", err)
+	}
+
+	mismatches := FindAggregationMismatches(blocks, committees)
+	if len(mismatches) != 1 {
+		t.Fatalf("got %d mismatches, want 1: %+v", len(mismatches), mismatches)
+	}
+	got := mismatches[0]
+	if got.BlockSlot != 2 || got.DutySlot != 1 || got.Computed != 5 || got.Actual != 4 || len(got.CommitteeIndices) != 2 {
+		t.Errorf("unexpected mismatch %+v", got)
+	}
+	if got.Delta() != -1 || got.Direction() != "undershoot" {
+		t.Errorf("got delta %d and direction %q, want -1 and undershoot", got.Delta(), got.Direction())
+	}
+}
+
+func TestFindEpochMismatchesAtEpochBoundaries(t *testing.T) {
+	defer func(previous uint64) { slotsPerEpoch = previous }(slotsPerEpoch)
+
+	newClient := func() *testutil.FakeClient {
+		return testutil.NewFakeClient().
+			WithSlotsPerEpoch(8).
+			WithCommittee(7, 0, []phase0.ValidatorIndex{1, 2}).
+			WithCommittee(8, 0, []phase0.ValidatorIndex{3, 4, 5}).
+			WithCommittee(15, 0, []phase0.ValidatorIndex{6, 7, 8, 9}).
+			// Attests to the last slot of epoch 0, so belongs to that epoch.
+			WithBlock(8, attestation(7, []uint64{0}, 3)).
+			WithBlock(9, attestation(8, []uint64{0}, 3))
+	}
+
+	tests := []struct {
+		name   string
+		client *testutil.FakeClient
+		want   []phase0.Slot
+	}{
+		{
+			name:   "next epoch's first block",
+			client: newClient().WithBlock(16, attestation(15, []uint64{0}, 5)),
+			want:   []phase0.Slot{15},
+		},
+		{
+			name:   "next epoch's first slot missed",
+			client: newClient(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			LoadSpec(ctx, tt.client)
+			blocks, err := ListEpochBlocks(ctx, tt.client, 1)
+			if err != nil {
+				t.Fatalf("ListEpochBlocks: %v", err)
+			}
+			committees, err := GetBeaconCommitees(ctx, tt.client, 0, 1)
+			if err != nil {
+				t.Fatalf("GetBeaconCommitees: %v", err)
+			}
+
+			mismatches, err := FindEpochMismatches(ctx, tt.client, 1, blocks, committees)
+			if err != nil {
+				t.Fatalf("FindEpochMismatches: %v", err)
+			}
+			if len(mismatches) != len(tt.want) {
+				t.Fatalf("got %d mismatches, want %d: %+v", len(mismatches), len(tt.want), mismatches)
+			}
+			for i, slot := range tt.want {
+				if mismatches[i].DutySlot != slot || mismatches[i].BlockSlot != slot+1 {
+					t.Errorf("mismatch %d: got duty slot %d in block %d, want %d in %d", i, mismatches[i].DutySlot, mismatches[i].BlockSlot, slot, slot+1)
+				}
+			}
+		})
+	}
+}
+
+func TestFindAggregationMismatchesNonZeroDataIndex(t *testing.T) {
+	withIndex := attestation(1, []uint64{0}, 3)
+	withIndex.Data.Index = 1
+	client := testutil.NewFakeClient().
+		WithCommittee(1, 0, []phase0.ValidatorIndex{1, 2, 3}).
+		WithBlock(2, withIndex)
+
+	blocks, err := ListEpochBlocks(context.Background(), client, 0)
+	if err != nil {
+		t.Fatalf("ListEpochBlocks: %v", err)
+	}
+	committees, err := GetBeaconCommitees(context.Background(), client, 0, 0)
+	if err != nil {
+		t.Fatalf("GetBeaconCommitees: %v", err)
+	}
+
+	mismatches := FindAggregationMismatches(blocks, committees)
+	if len(mismatches) != 1 {
+		t.Fatalf("got %d mismatches, want 1: %+v", len(mismatches), mismatches)
+	}
+	if !errors.Is(mismatches[0].Err, ErrNonZeroDataIndex) {
+		t.Errorf("got error %v, want %v", mismatches[0].Err, ErrNonZeroDataIndex)
+	}
+}
+
+func TestExpectedAggregationBitsLen(t *testing.T) {
+	committees := Committees{
+		1: {
+			0: {1, 2, 3},
+			2: {4, 5},
+			5: {6},
+		},
+	}
+	tests := []struct {
+		name       string
+		committees []uint64
+		want       uint64
+		unknown    bool
+	}{
+		{name: "single committee", committees: []uint64{2}, want: 2},
+		{name: "several committees", committees: []uint64{0, 2, 5}, want: 6},
+		{name: "unknown committee", committees: []uint64{0, 3}, want: 3, unknown: true},
+	}
+	sizes := newCommitteeSizes(committees)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := attestation(1, tt.committees, 0)
+			got, err := ExpectedAggregationBitsLen(a, committees)
+			if got != tt.want || errors.Is(err, ErrUnknownCommittee) != tt.unknown {
+				t.Fatalf("got %d, %v; want %d, unknown %v", got, err, tt.want, tt.unknown)
+			}
+
+			// The precomputed sizes used by the mismatch check must agree.
+			batched, batchedErr := expectedAggregationBitsLen(a, sizes)
+			if batched != got || (batchedErr == nil) != (err == nil) {
+				t.Errorf("precomputed sizes: got %d, %v; want %d, %v", batched, batchedErr, got, err)
+			}
+		})
+	}
+}
+
+func TestFindAggregationMismatchesEmptyCommitteeBits(t *testing.T) {
+	client := testutil.NewFakeClient().
+		WithCommittee(1, 0, []phase0.ValidatorIndex{1, 2, 3}).
+		WithBlock(2, attestation(1, nil, 3))
+
+	blocks, err := ListEpochBlocks(context.Background(), client, 0)
+	if err != nil {
+		t.Fatalf("ListEpochBlocks: %v", err)
+	}
+	committees, err := GetBeaconCommitees(context.Background(), client, 0, 0)
+	if err != nil {
+		t.Fatalf("GetBeaconCommitees: %v", err)
+	}
+
+	mismatches := FindAggregationMismatches(blocks, committees)
+	if len(mismatches) != 1 {
+		t.Fatalf("got %d mismatches, want 1: %+v", len(mismatches), mismatches)
+	}
+	if !errors.Is(mismatches[0].Err, ErrEmptyCommitteeBits) {
+		t.Errorf("got error %v, want %v", mismatches[0].Err, ErrEmptyCommitteeBits)
+	}
+	if _, err := ExpectedAggregationBitsLen(attestation(1, nil, 3), committees); !errors.Is(err, ErrEmptyCommitteeBits) {
+		t.Errorf("ExpectedAggregationBitsLen: got %v, want %v", err, ErrEmptyCommitteeBits)
+	}
+}
+
+func TestFindAggregationMismatchesTooManyCommittees(t *testing.T) {
+	defer func(previous uint64) { specMaxCommitteesPerSlot = previous }(specMaxCommitteesPerSlot)
+	client := testutil.NewFakeClient().
+		WithMaxCommitteesPerSlot(2).
+		WithCommittee(1, 0, []phase0.ValidatorIndex{1, 2, 3}).
+		WithCommittee(1, 1, []phase0.ValidatorIndex{4, 5}).
+		WithCommittee(1, 2, []phase0.ValidatorIndex{6}).
+		WithBlock(2, attestation(1, []uint64{0, 1, 2}, 6), attestation(1, []uint64{0, 1}, 5))
+	LoadSpec(context.Background(), client)
+	if specMaxCommitteesPerSlot != 2 {
+		t.Fatalf("LoadSpec: got %d max committees per slot, want 2", specMaxCommitteesPerSlot)
+	}
+
+	blocks, err := ListEpochBlocks(context.Background(), client, 0)
+	if err != nil {
+		t.Fatalf("ListEpochBlocks: %v", err)
+	}
+	committees, err := GetBeaconCommitees(context.Background(), client, 0, 0)
+	if err != nil {
+		t.Fatalf("GetBeaconCommitees: %v", err)
+	}
+
+	// The length is right, but no slot can have three committees.
+	mismatches := FindAggregationMismatches(blocks, committees)
+	if len(mismatches) != 1 {
+		t.Fatalf("got %d mismatches, want 1: %+v", len(mismatches), mismatches)
+	}
+	var tooMany *TooManyCommitteesError
+	if !errors.As(mismatches[0].Err, &tooMany) || tooMany.Index != 2 || tooMany.Max != 2 {
+		t.Errorf("got error %v, want %v for bit 2 of 2", mismatches[0].Err, ErrTooManyCommittees)
+	}
+	if !errors.Is(mismatches[0].Err, ErrTooManyCommittees) {
+		t.Errorf("got error %v, want %v", mismatches[0].Err, ErrTooManyCommittees)
+	}
+}
+
+func TestFindAggregationMismatchesTooManyCommitteesMinimal(t *testing.T) {
+	defer func(previous uint64) { specMaxCommitteesPerSlot = previous }(specMaxCommitteesPerSlot)
+	// The minimal preset allows four committees a slot, but with few
+	// validators each slot has just the one.
+	client := testutil.NewFakeClient().
+		WithMaxCommitteesPerSlot(4).
+		WithCommittee(1, 0, []phase0.ValidatorIndex{1, 2, 3}).
+		WithBlock(2, attestation(1, []uint64{0, 1}, 3))
+	LoadSpec(context.Background(), client)
+
+	blocks, err := ListEpochBlocks(context.Background(), client, 0)
+	if err != nil {
+		t.Fatalf("ListEpochBlocks: %v", err)
+	}
+	committees, err := GetBeaconCommitees(context.Background(), client, 0, 0)
+	if err != nil {
+		t.Fatalf("GetBeaconCommitees: %v", err)
+	}
+
+	mismatches := FindAggregationMismatches(blocks, committees)
+	if len(mismatches) != 1 {
+		t.Fatalf("got %d mismatches, want 1: %+v", len(mismatches), mismatches)
+	}
+	var tooMany *TooManyCommitteesError
+	if !errors.As(mismatches[0].Err, &tooMany) || tooMany.Index != 1 || tooMany.Max != 1 {
+		t.Errorf("got error %v, want %v for bit 1 of 1", mismatches[0].Err, ErrTooManyCommittees)
+	}
+}
+
+func TestFindAggregationMismatchesMalformedBitlist(t *testing.T) {
+	malformed := attestation(1, []uint64{0}, 3)
+	// The sentinel byte has been zeroed, as a misdecoding might.
+	malformed.AggregationBits = bitfield.Bitlist{0x0f, 0x00}
+	client := testutil.NewFakeClient().
+		WithCommittee(1, 0, []phase0.ValidatorIndex{1, 2, 3}).
+		WithBlock(2, malformed, attestation(1, []uint64{0}, 3))
+
+	blocks, err := ListEpochBlocks(context.Background(), client, 0)
+	if err != nil {
+		t.Fatalf("ListEpochBlocks: %v", err)
+	}
+	committees, err := GetBeaconCommitees(context.Background(), client, 0, 0)
+	if err != nil {
+		t.Fatalf("GetBeaconCommitees: %v", err)
+	}
+
+	mismatches := FindAggregationMismatches(blocks, committees)
+	if len(mismatches) != 1 {
+		t.Fatalf("got %d mismatches, want 1: %+v", len(mismatches), mismatches)
+	}
+	if !errors.Is(mismatches[0].Err, ErrMalformedBitlist) {
+		t.Errorf("got error %v, want %v", mismatches[0].Err, ErrMalformedBitlist)
+	}
+}
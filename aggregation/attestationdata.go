@@ -0,0 +1,82 @@
+package aggregation
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog/log"
+)
+
+// DistinctAttestationData counts the aggregates among attestations for
+// dutySlot by the hash tree root of their attestation data. Attestations for
+// other slots are left out, and one whose data cannot be hashed is logged and
+// skipped.
+func DistinctAttestationData(dutySlot phase0.Slot, attestations []*electra.Attestation) map[phase0.Root]int {
+	counts := make(map[phase0.Root]int)
+	for _, attestation := range attestations {
+		if attestation.Data == nil || attestation.Data.Slot != dutySlot {
+			continue
+		}
+		root, err := attestation.Data.HashTreeRoot()
+		if err != nil {
+			log.Error().Err(err).Uint64("slot", uint64(dutySlot)).Msg("failed hashing attestation data")
+			continue
+		}
+		counts[root]++
+	}
+	return counts
+}
+
+// disagreeBeyondHead reports whether attestations vote for more than one
+// source or target checkpoint. Validators seeing a late block vote for
+// different heads all the time, but differing checkpoints mean some of them
+// followed another fork.
+func disagreeBeyondHead(attestations []*electra.Attestation) bool {
+	type checkpoints struct {
+		source phase0.Checkpoint
+		target phase0.Checkpoint
+	}
+	seen := make(map[checkpoints]struct{})
+	for _, attestation := range attestations {
+		var vote checkpoints
+		if attestation.Data.Source != nil {
+			vote.source = *attestation.Data.Source
+		}
+		if attestation.Data.Target != nil {
+			vote.target = *attestation.Data.Target
+		}
+		seen[vote] = struct{}{}
+	}
+	return len(seen) > 1
+}
+
+// LogDistinctAttestationData flags every duty slot in epoch whose included
+// aggregates carry more than one distinct attestation data. Slots where only
+// the head vote differs are logged at debug level; any other difference, a
+// sign of a reorg or a non-canonical vote being included, is a warning.
+func LogDistinctAttestationData(epoch phase0.Epoch, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock) {
+	byDutySlot := GatherAttestationsByDutySlot(blocks)
+	for _, slot := range slices.Sorted(maps.Keys(byDutySlot)) {
+		if slot < EpochLowestSlot(epoch) || slot > EpochHighestSlot(epoch) {
+			continue
+		}
+		attestations := attestationsOf(byDutySlot[slot])
+		counts := DistinctAttestationData(slot, attestations)
+		if len(counts) <= 1 {
+			continue
+		}
+
+		event := log.Debug()
+		message := "attestations for one slot voted for different heads"
+		if disagreeBeyondHead(attestations) {
+			event = log.Warn()
+			message = "attestations for one slot disagree beyond the head vote"
+		}
+		aggregates := slices.Sorted(maps.Values(counts))
+		slices.Reverse(aggregates)
+		event.Uint64("slot", uint64(slot)).Int("data_roots", len(counts)).Ints("aggregates", aggregates).Msg(message)
+	}
+}
@@ -0,0 +1,41 @@
+package aggregation
+
+import (
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+)
+
+func TestDistinctAttestationData(t *testing.T) {
+	late := attestation(3, []uint64{0}, 4)
+	late.Data.BeaconBlockRoot = phase0.Root{1}
+	attestations := []*electra.Attestation{
+		attestation(3, []uint64{0}, 4),
+		attestation(3, []uint64{1}, 4),
+		late,
+		// Not for the duty slot.
+		attestation(2, []uint64{0}, 4),
+	}
+
+	counts := DistinctAttestationData(3, attestations)
+	if len(counts) != 2 {
+		t.Fatalf("got %d distinct data roots, want 2", len(counts))
+	}
+	root, err := late.Data.HashTreeRoot()
+	if err != nil {
+		t.Fatalf("HashTreeRoot: %v", err)
+	}
+	if counts[root] != 1 {
+		t.Errorf("got %d aggregates for the late head vote, want 1", counts[root])
+	}
+	if disagreeBeyondHead(attestations[:3]) {
+		t.Error("a different head vote was flagged as disagreeing beyond the head")
+	}
+
+	reorged := attestation(3, []uint64{0}, 4)
+	reorged.Data.Target = &phase0.Checkpoint{Epoch: 0, Root: phase0.Root{2}}
+	if !disagreeBeyondHead(append(attestations[:3:3], reorged)) {
+		t.Error("a different target vote was not flagged")
+	}
+}
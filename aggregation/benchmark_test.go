@@ -0,0 +1,145 @@
+package aggregation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/prysmaticlabs/go-bitfield"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"repro/internal/testutil"
+)
+
+// Mainnet-sized duties after Electra: every slot has the maximum of 64
+// committees of about 450 validators each, and every block the maximum of 8
+// aggregates.
+const (
+	benchmarkCommittees    = 64
+	benchmarkCommitteeSize = 450
+	benchmarkAggregates    = 8
+)
+
+// benchmarkSetup silences logging and lifts the request rate limit for the
+// duration of the benchmark.
+func benchmarkSetup(b *testing.B) {
+	b.Helper()
+	logger := log.Logger
+	log.Logger = zerolog.Nop()
+	limit := requestLimiter.Limit()
+	SetMaxRequestsPerSecond(0)
+	b.Cleanup(func() {
+		log.Logger = logger
+		requestLimiter.SetLimit(limit)
+	})
+}
+
+// benchmarkAttestation is a single aggregate for slot covering every
+// committee, with every other validator attesting.
+func benchmarkAttestation(slot phase0.Slot) *electra.Attestation {
+	committeeBits := bitfield.NewBitvector64()
+	for index := range uint64(benchmarkCommittees) {
+		committeeBits.SetBitAt(index, true)
+	}
+	aggregationBits := bitfield.NewBitlist(benchmarkCommittees * benchmarkCommitteeSize)
+	for i := uint64(0); i < aggregationBits.Len(); i += 2 {
+		aggregationBits.SetBitAt(i, true)
+	}
+	return &electra.Attestation{
+		AggregationBits: aggregationBits,
+		Data:            &phase0.AttestationData{Slot: slot},
+		CommitteeBits:   committeeBits,
+	}
+}
+
+// benchmarkClient serves a full epoch 1 whose every block carries aggregates
+// for the slot before it, with committees for epochs 0 and 1.
+func benchmarkClient(latency time.Duration) *testutil.FakeClient {
+	client := testutil.NewFakeClient().WithLatency(latency)
+	validator := phase0.ValidatorIndex(0)
+	for slot := EpochLowestSlot(0); slot <= EpochHighestSlot(1); slot++ {
+		for index := range phase0.CommitteeIndex(benchmarkCommittees) {
+			validators := make([]phase0.ValidatorIndex, benchmarkCommitteeSize)
+			for i := range validators {
+				validators[i] = validator
+				validator++
+			}
+			client.WithCommittee(slot, index, validators)
+		}
+	}
+	for slot := EpochLowestSlot(1); slot <= EpochHighestSlot(1); slot++ {
+		aggregates := make([]*electra.Attestation, benchmarkAggregates)
+		for i := range aggregates {
+			aggregates[i] = benchmarkAttestation(slot - 1)
+		}
+		client.WithBlock(slot, aggregates...)
+	}
+	return client
+}
+
+func BenchmarkListEpochBlocks(b *testing.B) {
+	benchmarkSetup(b)
+	client := benchmarkClient(time.Millisecond)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if _, err := ListEpochBlocks(ctx, client, 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkListEpochBlocksConcurrent(b *testing.B) {
+	benchmarkSetup(b)
+	client := benchmarkClient(time.Millisecond)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if _, err := ListEpochBlocksConcurrent(ctx, client, 1, DEFAULT_BLOCK_WORKERS); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFindAggregationMismatches(b *testing.B) {
+	benchmarkSetup(b)
+	client := benchmarkClient(0)
+	ctx := context.Background()
+	blocks, err := ListEpochBlocks(ctx, client, 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	committees, err := GetBeaconCommitees(ctx, client, 0, 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if mismatches := FindAggregationMismatches(blocks, committees); len(mismatches) != 0 {
+			b.Fatalf("got %d mismatches, want 0", len(mismatches))
+		}
+	}
+}
+
+func BenchmarkSplitAggregationBits(b *testing.B) {
+	attestation := benchmarkAttestation(0)
+	sizes := make(map[phase0.CommitteeIndex]int, benchmarkCommittees)
+	for index := range phase0.CommitteeIndex(benchmarkCommittees) {
+		sizes[index] = benchmarkCommitteeSize
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if _, err := SplitAggregationBits(attestation.CommitteeBits, attestation.AggregationBits, sizes); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
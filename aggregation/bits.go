@@ -0,0 +1,207 @@
+package aggregation
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/prysmaticlabs/go-bitfield"
+)
+
+// ErrMalformedBitlist is matched by a MalformedBitlistError.
+var ErrMalformedBitlist = errors.New("malformed bitlist")
+
+// MalformedBitlistError is a bitlist whose bytes disagree with the length its
+// sentinel bit encodes: the sentinel is the highest set bit of the last byte,
+// so a well formed bitlist of length Len has exactly Len/8+1 bytes. A last
+// byte of zero, or no bytes at all, means the sentinel is missing.
+type MalformedBitlistError struct {
+	Bytes int
+	Len   uint64
+}
+
+func (e *MalformedBitlistError) Error() string {
+	if e.Bytes == 0 {
+		return "malformed bitlist: no bytes, so no length sentinel"
+	}
+	return fmt.Sprintf("malformed bitlist: %d bytes, but its sentinel gives length %d, which takes %d", e.Bytes, e.Len, e.Len/8+1)
+}
+
+func (e *MalformedBitlistError) Is(target error) bool {
+	return target == ErrMalformedBitlist
+}
+
+// CheckBitlist recomputes the byte length of bits from its Len and returns a
+// *MalformedBitlistError if the raw bytes do not have it, so that a corrupt
+// or misdecoded bitlist is not mistaken for a length mismatch against the
+// committees.
+func CheckBitlist(bits bitfield.Bitlist) error {
+	if uint64(len(bits)) != bits.Len()/8+1 {
+		return &MalformedBitlistError{Bytes: len(bits), Len: bits.Len()}
+	}
+	return nil
+}
+
+// SplitAggregationBits slices Electra aggregation bits into one bitlist per
+// committee set in committeeBits. The aggregation bits are the concatenation of
+// those committees' bitlists in ascending committee index order, so each
+// committee's size must be known and together they must tile aggBits exactly.
+//
+// The ascending order is what gives each committee its offset, and is the
+// crux of interpreting Electra aggregation bits. The indices are sorted here
+// rather than trusting the order BitIndices happens to return them in.
+func SplitAggregationBits(committeeBits bitfield.Bitvector64, aggBits bitfield.Bitlist, committeeSizes map[phase0.CommitteeIndex]int) (map[phase0.CommitteeIndex]bitfield.Bitlist, error) {
+	result := make(map[phase0.CommitteeIndex]bitfield.Bitlist, committeeBits.Count())
+	offset := uint64(0)
+	var last phase0.CommitteeIndex
+	indices := committeeBits.BitIndices()
+	slices.Sort(indices)
+	for _, bit := range indices {
+		index := phase0.CommitteeIndex(bit)
+		size, ok := committeeSizes[index]
+		if !ok {
+			return nil, fmt.Errorf("committee %d: size unknown", index)
+		}
+		if offset+uint64(size) > aggBits.Len() {
+			return nil, fmt.Errorf("committee %d: size %d at offset %d overruns aggregation bits of length %d", index, size, offset, aggBits.Len())
+		}
+
+		bits := bitfield.NewBitlist(uint64(size))
+		for i := uint64(0); i < uint64(size); i++ {
+			if aggBits.BitAt(offset + i) {
+				bits.SetBitAt(i, true)
+			}
+		}
+		result[index] = bits
+		offset += uint64(size)
+		last = index
+	}
+
+	if offset != aggBits.Len() {
+		return nil, fmt.Errorf("committee %d: committees end at bit %d but aggregation bits have length %d", last, offset, aggBits.Len())
+	}
+	return result, nil
+}
+
+// OrderedAttestationValidators returns the validators an Electra aggregation
+// bitlist maps onto, in the order it encodes them: committees in ascending
+// index order, each in its own validator order. Bit i of the aggregation bits
+// being set means result[i] attested, so a correct attestation's aggregation
+// bits have exactly len(result) bits.
+func OrderedAttestationValidators(committeeBits bitfield.Bitvector64, committees map[phase0.CommitteeIndex][]phase0.ValidatorIndex) ([]phase0.ValidatorIndex, error) {
+	indices := committeeBits.BitIndices()
+	slices.Sort(indices)
+
+	var ordered []phase0.ValidatorIndex
+	for _, bit := range indices {
+		committee, ok := committees[phase0.CommitteeIndex(bit)]
+		if !ok {
+			return nil, fmt.Errorf("committee %d: unknown", bit)
+		}
+		ordered = append(ordered, committee...)
+	}
+	return ordered, nil
+}
+
+// AttestingValidators decodes an Electra attestation's aggregation bits into
+// the indices of the validators that attested.
+func AttestingValidators(attestation *electra.Attestation, committees map[phase0.CommitteeIndex][]phase0.ValidatorIndex) ([]phase0.ValidatorIndex, error) {
+	sizes := make(map[phase0.CommitteeIndex]int, len(committees))
+	for index, committee := range committees {
+		sizes[index] = len(committee)
+	}
+
+	split, err := SplitAggregationBits(attestation.CommitteeBits, attestation.AggregationBits, sizes)
+	if err != nil {
+		return nil, fmt.Errorf("slot %d: %w", attestation.Data.Slot, err)
+	}
+
+	var attesters []phase0.ValidatorIndex
+	for _, bit := range attestation.CommitteeBits.BitIndices() {
+		index := phase0.CommitteeIndex(bit)
+		for _, position := range split[index].BitIndices() {
+			attesters = append(attesters, committees[index][position])
+		}
+	}
+	return attesters, nil
+}
+
+// MergeAggregates ORs together, per committee, the aggregation bits of every
+// attestation covering that committee, so the result holds the union of its
+// participants. Attestations spanning several committees are split by their
+// committee bits first.
+func MergeAggregates(attestations []*electra.Attestation, committees map[phase0.CommitteeIndex][]phase0.ValidatorIndex) (map[phase0.CommitteeIndex]bitfield.Bitlist, error) {
+	sizes := make(map[phase0.CommitteeIndex]int, len(committees))
+	for index, committee := range committees {
+		sizes[index] = len(committee)
+	}
+
+	merged := make(map[phase0.CommitteeIndex]bitfield.Bitlist)
+	for _, attestation := range attestations {
+		split, err := SplitAggregationBits(attestation.CommitteeBits, attestation.AggregationBits, sizes)
+		if err != nil {
+			return nil, fmt.Errorf("slot %d: %w", attestation.Data.Slot, err)
+		}
+		for index, bits := range split {
+			existing, ok := merged[index]
+			if !ok {
+				merged[index] = bits
+				continue
+			}
+			union, err := existing.Or(bits)
+			if err != nil {
+				return nil, fmt.Errorf("slot %d: committee %d: %w", attestation.Data.Slot, index, err)
+			}
+			merged[index] = union
+		}
+	}
+	return merged, nil
+}
+
+// DutySlotAttesters returns the union of the validators that attested in any
+// of attestations, which must all be for the same slot as committees, sorted
+// ascending.
+func DutySlotAttesters(attestations []*electra.Attestation, committees map[phase0.CommitteeIndex][]phase0.ValidatorIndex) ([]phase0.ValidatorIndex, error) {
+	merged, err := MergeAggregates(attestations, committees)
+	if err != nil {
+		return nil, err
+	}
+
+	var attesters []phase0.ValidatorIndex
+	for index, bits := range merged {
+		for _, position := range bits.BitIndices() {
+			attesters = append(attesters, committees[index][position])
+		}
+	}
+	slices.Sort(attesters)
+	return slices.Compact(attesters), nil
+}
+
+// FormatBitlist renders bits as its set indices followed by a summary, e.g.
+// "[0,3,7,12] len=16 set=4".
+func FormatBitlist(bits bitfield.Bitlist) string {
+	if bits == nil {
+		return "[] len=0 set=0"
+	}
+	return formatIndices(bits.BitIndices()) + fmt.Sprintf(" len=%d set=%d", bits.Len(), bits.Count())
+}
+
+// FormatCommitteeBits renders committee bits like FormatBitlist.
+func FormatCommitteeBits(bits bitfield.Bitvector64) string {
+	if bits == nil {
+		return "[] len=0 set=0"
+	}
+	return formatIndices(bits.BitIndices()) + fmt.Sprintf(" len=%d set=%d", bits.Len(), bits.Count())
+}
+
+func formatIndices(indices []int) string {
+	parts := make([]string, 0, len(indices))
+	for _, index := range indices {
+		parts = append(parts, strconv.Itoa(index))
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
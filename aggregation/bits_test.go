@@ -0,0 +1,208 @@
+package aggregation
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/prysmaticlabs/go-bitfield"
+
+	"repro/internal/testutil"
+)
+
+func TestSplitAggregationBitsOrdersCommittees(t *testing.T) {
+	// Set out of order; the bits must still be sliced 1, 5, 9.
+	committeeBits := bitfield.NewBitvector64()
+	for _, index := range []uint64{5, 1, 9} {
+		committeeBits.SetBitAt(index, true)
+	}
+	sizes := map[phase0.CommitteeIndex]int{1: 2, 5: 3, 9: 1}
+
+	// Committee 1 is bits 0-1, committee 5 bits 2-4 and committee 9 bit 5.
+	aggBits := bitfield.NewBitlist(6)
+	for _, i := range []uint64{0, 3, 4, 5} {
+		aggBits.SetBitAt(i, true)
+	}
+
+	split, err := SplitAggregationBits(committeeBits, aggBits, sizes)
+	if err != nil {
+		t.Fatalf("SplitAggregationBits: %v", err)
+	}
+
+	want := map[phase0.CommitteeIndex][]int{1: {0}, 5: {1, 2}, 9: {0}}
+	if len(split) != len(want) {
+		t.Fatalf("got %d committees, want %d", len(split), len(want))
+	}
+	for index, bits := range want {
+		got := split[index]
+		if got.Len() != uint64(sizes[index]) {
+			t.Errorf("committee %d: got length %d, want %d", index, got.Len(), sizes[index])
+		}
+		if FormatBitlist(got) != FormatBitlist(bitlist(sizes[index], bits...)) {
+			t.Errorf("committee %d: got %s, want set bits %v", index, FormatBitlist(got), bits)
+		}
+	}
+}
+
+func TestBuiltAttestationsDecode(t *testing.T) {
+	committees := Committees{7: {
+		0: {10, 11, 12},
+		1: {20, 21},
+		4: {40, 41, 42, 43},
+	}}
+	sizes := map[phase0.CommitteeIndex]int{0: 3, 1: 2, 4: 4}
+
+	tests := []struct {
+		name      string
+		attesters map[phase0.CommitteeIndex][]int
+		want      []phase0.ValidatorIndex
+	}{
+		{name: "one committee", attesters: map[phase0.CommitteeIndex][]int{1: {1}}, want: []phase0.ValidatorIndex{21}},
+		{name: "all committees", attesters: map[phase0.CommitteeIndex][]int{4: {0, 3}, 0: {2}, 1: {0, 1}}, want: []phase0.ValidatorIndex{12, 20, 21, 40, 43}},
+		{name: "gap between committees", attesters: map[phase0.CommitteeIndex][]int{0: {0}, 4: {1}}, want: []phase0.ValidatorIndex{10, 41}},
+		{name: "committee without attesters", attesters: map[phase0.CommitteeIndex][]int{0: nil, 1: {0}}, want: []phase0.ValidatorIndex{20}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			att := testutil.BuildAttestation(sizes, tt.attesters)
+			att.Data.Slot = 7
+
+			length, err := ExpectedAggregationBitsLen(att, committees)
+			if err != nil {
+				t.Fatalf("ExpectedAggregationBitsLen: %v", err)
+			}
+			if length != att.AggregationBits.Len() {
+				t.Errorf("expected length %d, built %d", length, att.AggregationBits.Len())
+			}
+
+			split, err := SplitAggregationBits(att.CommitteeBits, att.AggregationBits, sizes)
+			if err != nil {
+				t.Fatalf("SplitAggregationBits: %v", err)
+			}
+			for index, positions := range tt.attesters {
+				if FormatBitlist(split[index]) != FormatBitlist(bitlist(sizes[index], positions...)) {
+					t.Errorf("committee %d: got %s, want set bits %v", index, FormatBitlist(split[index]), positions)
+				}
+			}
+
+			got, err := AttestingValidators(att, committees[7])
+			if err != nil {
+				t.Fatalf("AttestingValidators: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got attesters %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func bitlist(length int, set ...int) bitfield.Bitlist {
+	bits := bitfield.NewBitlist(uint64(length))
+	for _, i := range set {
+		bits.SetBitAt(uint64(i), true)
+	}
+	return bits
+}
+
+func TestOrderedAttestationValidators(t *testing.T) {
+	committees := map[phase0.CommitteeIndex][]phase0.ValidatorIndex{
+		0: {10, 11, 12},
+		2: {20, 21},
+		3: {30},
+	}
+
+	tests := []struct {
+		name        string
+		attestation []uint64
+		aggLen      uint64
+		want        []phase0.ValidatorIndex
+		match       bool
+	}{
+		{name: "single committee", attestation: []uint64{2}, aggLen: 2, want: []phase0.ValidatorIndex{20, 21}, match: true},
+		{name: "ascending committees", attestation: []uint64{3, 0}, aggLen: 4, want: []phase0.ValidatorIndex{10, 11, 12, 30}, match: true},
+		{name: "aggregation bits too long", attestation: []uint64{0, 2}, aggLen: 6, want: []phase0.ValidatorIndex{10, 11, 12, 20, 21}, match: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			att := attestation(1, tt.attestation, tt.aggLen)
+			got, err := OrderedAttestationValidators(att.CommitteeBits, committees)
+			if err != nil {
+				t.Fatalf("OrderedAttestationValidators: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if match := uint64(len(got)) == att.AggregationBits.Len(); match != tt.match {
+				t.Errorf("%d validators against %d aggregation bits: match %v, want %v", len(got), att.AggregationBits.Len(), match, tt.match)
+			}
+		})
+	}
+
+	if _, err := OrderedAttestationValidators(attestation(1, []uint64{1}, 1).CommitteeBits, committees); err == nil {
+		t.Error("expected an error for an unknown committee")
+	}
+}
+
+func TestCheckBitlist(t *testing.T) {
+	tests := []struct {
+		name  string
+		bits  bitfield.Bitlist
+		valid bool
+	}{
+		{name: "empty list", bits: bitfield.NewBitlist(0), valid: true},
+		{name: "sentinel in last byte", bits: bitfield.NewBitlist(13), valid: true},
+		{name: "byte-aligned length", bits: bitfield.NewBitlist(16), valid: true},
+		{name: "no bytes", bits: bitfield.Bitlist{}},
+		{name: "no sentinel", bits: bitfield.Bitlist{0xff, 0x00}},
+		{name: "zero bytes after the sentinel", bits: bitfield.Bitlist{0x05, 0x00, 0x00}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckBitlist(tt.bits)
+			if tt.valid && err != nil {
+				t.Errorf("got error %v for %x", err, []byte(tt.bits))
+			}
+			if !tt.valid && !errors.Is(err, ErrMalformedBitlist) {
+				t.Errorf("got error %v for %x, want %v", err, []byte(tt.bits), ErrMalformedBitlist)
+			}
+		})
+	}
+}
+
+func TestMergeAggregates(t *testing.T) {
+	committees := map[phase0.CommitteeIndex][]phase0.ValidatorIndex{
+		0: {10, 11, 12},
+		1: {20, 21},
+		2: {},
+	}
+	sizes := map[phase0.CommitteeIndex]int{0: 3, 1: 2, 2: 0}
+	attestations := []*electra.Attestation{
+		testutil.BuildAttestation(sizes, map[phase0.CommitteeIndex][]int{0: {0, 1}}),
+		// Overlaps the first on validator 11 and spans committees 0 to 2.
+		testutil.BuildAttestation(sizes, map[phase0.CommitteeIndex][]int{0: {1}, 1: {1}, 2: nil}),
+	}
+
+	merged, err := MergeAggregates(attestations, committees)
+	if err != nil {
+		t.Fatalf("MergeAggregates: %v", err)
+	}
+	for index, want := range map[phase0.CommitteeIndex][]int{0: {0, 1}, 1: {1}, 2: nil} {
+		bits, ok := merged[index]
+		if !ok {
+			t.Errorf("committee %d missing from the merge", index)
+			continue
+		}
+		if got := bits.BitIndices(); !slices.Equal(got, want) {
+			t.Errorf("committee %d: got bits %v, want %v", index, got, want)
+		}
+	}
+
+	// Aggregation bits too long for the committees are an error, not merged.
+	broken := testutil.BuildAttestation(sizes, map[phase0.CommitteeIndex][]int{1: {0}})
+	broken.AggregationBits = bitfield.NewBitlist(3)
+	if _, err := MergeAggregates(append(attestations, broken), committees); err == nil {
+		t.Error("MergeAggregates: expected an error for a misaligned aggregate")
+	}
+}
@@ -0,0 +1,451 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/attestantio/go-eth2-client/api"
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrMissedSlot is returned for a slot that has no block.
+var ErrMissedSlot = errors.New("missed slot")
+
+// ErrPreElectra is returned by GetSlotAttestations for a block from before
+// Electra, whose attestations are not Electra attestations.
+var ErrPreElectra = errors.New("block before Electra")
+
+// ErrUnsupportedFork is matched by an UnsupportedForkError.
+var ErrUnsupportedFork = errors.New("unsupported fork")
+
+// UnsupportedForkError is a block from a fork this binary does not know about.
+type UnsupportedForkError struct {
+	Block   string
+	Version spec.DataVersion
+}
+
+func (e *UnsupportedForkError) Error() string {
+	return fmt.Sprintf("unsupported fork version %v for block %s", e.Version, e.Block)
+}
+
+func (e *UnsupportedForkError) Is(target error) bool {
+	return target == ErrUnsupportedFork
+}
+
+// requestContext derives the context for a single beacon node request from the
+// caller's context, first waiting for the request rate limiter. The timeout
+// only starts once the request is allowed through.
+func requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	// Wait only fails once ctx is done, and the request then fails with ctx's
+	// error anyway.
+	_ = requestLimiter.Wait(ctx)
+	return context.WithTimeout(ctx, requestTimeout)
+}
+
+// contextError names the operation that was cut short when err is due to a
+// context deadline or cancellation, so it is clear which request ran out of
+// time. Other errors are returned unchanged.
+func contextError(op string, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return err
+}
+
+// hasForkBlock reports whether the block carries data for a fork this binary
+// knows about.
+func hasForkBlock(block *spec.VersionedSignedBeaconBlock) bool {
+	switch block.Version {
+	case spec.DataVersionPhase0:
+		return block.Phase0 != nil
+	case spec.DataVersionAltair:
+		return block.Altair != nil
+	case spec.DataVersionBellatrix:
+		return block.Bellatrix != nil
+	case spec.DataVersionCapella:
+		return block.Capella != nil
+	case spec.DataVersionDeneb:
+		return block.Deneb != nil
+	case spec.DataVersionElectra:
+		return block.Electra != nil
+	default:
+		return false
+	}
+}
+
+// GetBlock fetches the block at slot regardless of the fork it belongs to. If
+// the slot was missed the error matches ErrMissedSlot.
+func GetBlock(ctx context.Context, service BeaconClient, slot phase0.Slot) (*spec.VersionedSignedBeaconBlock, error) {
+	block, err := getBlock(ctx, service, fmt.Sprintf("%v", slot), fmt.Sprintf("at slot %d", slot))
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, fmt.Errorf("no block at slot %d: %w", slot, ErrMissedSlot)
+	}
+	return block, nil
+}
+
+// GetSlotAttestations fetches the block at slot and returns only its
+// attestations, so the rest of the block, execution payload and all, can be
+// garbage collected straight away. Unlike GetBlock it returns nil and no error
+// for a missed slot; a block without attestations gives an empty slice.
+// Blocks from before Electra do not carry Electra attestations, and the error
+// for them matches ErrPreElectra.
+func GetSlotAttestations(ctx context.Context, service BeaconClient, slot phase0.Slot) ([]*electra.Attestation, error) {
+	var attestations []*electra.Attestation
+	err := withRetry(ctx, retryAttempts, retryBaseDelay, func() error {
+		block, err := getBlock(ctx, service, fmt.Sprintf("%v", slot), fmt.Sprintf("at slot %d", slot))
+		if err != nil || block == nil {
+			return err
+		}
+		if block.Version != spec.DataVersionElectra {
+			return fmt.Errorf("block at slot %d is %s: %w", slot, block.Version, ErrPreElectra)
+		}
+		attestations = block.Electra.Message.Body.Attestations
+		if attestations == nil {
+			attestations = []*electra.Attestation{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return attestations, nil
+}
+
+// getAttestationsBlock fetches the block at slot with GetSlotAttestations and
+// returns a stand-in for it that holds only its slot and attestations, which
+// is all the analysis reads. A block from before Electra is fetched whole.
+func getAttestationsBlock(ctx context.Context, service BeaconClient, slot phase0.Slot) (*spec.VersionedSignedBeaconBlock, error) {
+	attestations, err := GetSlotAttestations(ctx, service, slot)
+	switch {
+	case errors.Is(err, ErrPreElectra):
+		return GetBlockWithRetry(ctx, service, slot, retryAttempts, retryBaseDelay)
+	case err != nil:
+		return nil, err
+	case attestations == nil:
+		return nil, fmt.Errorf("no block at slot %d: %w", slot, ErrMissedSlot)
+	}
+	return &spec.VersionedSignedBeaconBlock{
+		Version: spec.DataVersionElectra,
+		Electra: &electra.SignedBeaconBlock{
+			Message: &electra.BeaconBlock{
+				Slot: slot,
+				Body: &electra.BeaconBlockBody{Attestations: attestations},
+			},
+		},
+	}, nil
+}
+
+// GetBlockByID fetches the block identified by blockID: a slot, a 0x-prefixed
+// root, or one of "head", "finalized", "justified" or "genesis". It is an
+// error if there is no such block.
+func GetBlockByID(ctx context.Context, service BeaconClient, blockID string) (*spec.VersionedSignedBeaconBlock, error) {
+	block, err := getBlock(ctx, service, blockID, fmt.Sprintf("%q", blockID))
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, fmt.Errorf("no block %q", blockID)
+	}
+	return block, nil
+}
+
+// GetBlockByRoot fetches the block with the given root. Unlike a missed slot,
+// an unknown root is an error.
+func GetBlockByRoot(ctx context.Context, service BeaconClient, root phase0.Root) (*spec.VersionedSignedBeaconBlock, error) {
+	if root.IsZero() {
+		return nil, errors.New("cannot fetch a block by the zero root")
+	}
+	block, err := getBlock(ctx, service, fmt.Sprintf("%#x", root), fmt.Sprintf("with root %#x", root))
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, fmt.Errorf("no block with root %#x", root)
+	}
+	return block, nil
+}
+
+// getBlock fetches the block identified by blockID, which is anything the
+// beacon node accepts: a slot, a 0x-prefixed root or a name such as "head".
+// what describes the block in errors and logs. A nil block means there is
+// none, such as for a missed slot. A block from an unknown fork is an
+// *UnsupportedForkError.
+func getBlock(ctx context.Context, service BeaconClient, blockID string, what string) (*spec.VersionedSignedBeaconBlock, error) {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
+	start := time.Now()
+	defer observeRequest("signed_beacon_block", start)
+	resp, err := service.SignedBeaconBlock(ctx, &api.SignedBeaconBlockOpts{
+		Block: blockID,
+	})
+
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, contextError(fmt.Sprintf("fetching block %s", what), err)
+	}
+	log.Debug().Str("block", blockID).Dur("took", time.Since(start)).Msg("fetched block")
+
+	if resp == nil || resp.Data == nil {
+		// Missed slot
+		return nil, nil
+	}
+
+	if !hasForkBlock(resp.Data) {
+		return nil, &UnsupportedForkError{Block: what, Version: resp.Data.Version}
+	}
+
+	return resp.Data, nil
+}
+
+// AttestationCommitteeIndices returns the committees an attestation covers, in
+// the order their validators appear in the aggregation bits. Electra encodes
+// these in the committee bits; earlier forks carry a single index in the data.
+func AttestationCommitteeIndices(attestation *spec.VersionedAttestation) ([]phase0.CommitteeIndex, error) {
+	if attestation.Version >= spec.DataVersionElectra {
+		committeeBits, err := attestation.CommitteeBits()
+		if err != nil {
+			return nil, err
+		}
+		indices := make([]phase0.CommitteeIndex, 0, committeeBits.Count())
+		for _, index := range committeeBits.BitIndices() {
+			indices = append(indices, phase0.CommitteeIndex(index))
+		}
+		return indices, nil
+	}
+
+	data, err := attestation.Data()
+	if err != nil {
+		return nil, err
+	}
+	return []phase0.CommitteeIndex{data.Index}, nil
+}
+
+// SortedBlocks returns the blocks in blocks in ascending slot order, so that
+// whatever is derived from them comes out in the same order on every run.
+func SortedBlocks(blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock) []*spec.VersionedSignedBeaconBlock {
+	sorted := make([]*spec.VersionedSignedBeaconBlock, 0, len(blocks))
+	for _, slot := range slices.Sorted(maps.Keys(blocks)) {
+		sorted = append(sorted, blocks[slot])
+	}
+	return sorted
+}
+
+// MissedSlots returns the slots of epoch with no block in blocks, sorted, as
+// ListEpochBlocks leaves missed slots out of its map.
+func MissedSlots(blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, epoch phase0.Epoch) []phase0.Slot {
+	var missed []phase0.Slot
+	for slot := EpochLowestSlot(epoch); slot <= EpochHighestSlot(epoch); slot++ {
+		if _, ok := blocks[slot]; !ok {
+			missed = append(missed, slot)
+		}
+	}
+	return missed
+}
+
+func ListEpochBlocks(ctx context.Context, service BeaconClient, epoch phase0.Epoch) (map[phase0.Slot]*spec.VersionedSignedBeaconBlock, error) {
+	result := make(map[phase0.Slot]*spec.VersionedSignedBeaconBlock, slotsPerEpoch)
+	low := EpochLowestSlot(epoch)
+	high := EpochHighestSlot(epoch)
+	for slot := low; slot <= high; slot++ {
+		if err := ctx.Err(); err != nil {
+			return result, contextError(fmt.Sprintf("listing blocks of epoch %d", epoch), err)
+		}
+
+		block, err := GetBlock(ctx, service, phase0.Slot(slot))
+		switch {
+		case errors.Is(err, ErrMissedSlot):
+			continue
+		case err != nil:
+			log.Error().Err(err).Uint64("slot", uint64(slot)).Msg("failed fetching block")
+			continue
+		}
+
+		result[slot] = block
+	}
+	return result, nil
+}
+
+// ListEpochBlocksConcurrent is ListEpochBlocks with up to workers block
+// requests in flight at once. A failed or missed slot does not affect the
+// others. After SetHeadersFirst(true) it is ListProducedEpochBlocks.
+func ListEpochBlocksConcurrent(ctx context.Context, service BeaconClient, epoch phase0.Epoch, workers int) (map[phase0.Slot]*spec.VersionedSignedBeaconBlock, error) {
+	if headersFirst {
+		return ListProducedEpochBlocks(ctx, service, epoch, workers)
+	}
+	return fetchBlocks(ctx, service, epochSlots(epoch), workers)
+}
+
+// epochSlots returns every slot of epoch in ascending order.
+func epochSlots(epoch phase0.Epoch) []phase0.Slot {
+	slots := make([]phase0.Slot, 0, slotsPerEpoch)
+	for slot := EpochLowestSlot(epoch); slot <= EpochHighestSlot(epoch); slot++ {
+		slots = append(slots, slot)
+	}
+	return slots
+}
+
+// WithNextEpochBlocks returns blocks, the blocks of epoch, together with those
+// of the epoch after it. Attestations are included up to an epoch after their
+// duty slot, and those for epoch's last slot only ever in the next epoch, so
+// anything counting who attested to epoch needs both. If the next epoch
+// cannot be listed, whatever was fetched of it is merged in and the error
+// returned as well.
+func WithNextEpochBlocks(ctx context.Context, service BeaconClient, epoch phase0.Epoch, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, workers int) (map[phase0.Slot]*spec.VersionedSignedBeaconBlock, error) {
+	next, err := ListEpochBlocksConcurrent(ctx, service, epoch+1, workers)
+	merged := mergeBlocks(blocks, next)
+	if err != nil {
+		return merged, fmt.Errorf("listing blocks of epoch %d: %w", epoch+1, err)
+	}
+	return merged, nil
+}
+
+// mergeBlocks returns a new map holding the blocks of both blocks and next.
+func mergeBlocks(blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, next map[phase0.Slot]*spec.VersionedSignedBeaconBlock) map[phase0.Slot]*spec.VersionedSignedBeaconBlock {
+	merged := make(map[phase0.Slot]*spec.VersionedSignedBeaconBlock, len(blocks)+len(next))
+	maps.Copy(merged, blocks)
+	maps.Copy(merged, next)
+	return merged
+}
+
+// ListProducedEpochBlocks is ListEpochBlocksConcurrent, but first uses block
+// headers to find missed slots so that no full block fetch is spent on them.
+// If the headers cannot be fetched, every slot's block is fetched instead.
+func ListProducedEpochBlocks(ctx context.Context, service BeaconClient, epoch phase0.Epoch, workers int) (map[phase0.Slot]*spec.VersionedSignedBeaconBlock, error) {
+	slots, err := producedSlots(ctx, service, epoch)
+	if err != nil {
+		return nil, err
+	}
+	return fetchBlocks(ctx, service, slots, workers)
+}
+
+// listEpochAttestations is ListEpochBlocksConcurrent for callers that only
+// read the blocks' attestations: each block is fetched with
+// getAttestationsBlock, so that holding an epoch of them does not hold its
+// execution payloads as well.
+func listEpochAttestations(ctx context.Context, service BeaconClient, epoch phase0.Epoch, workers int) (map[phase0.Slot]*spec.VersionedSignedBeaconBlock, error) {
+	slots := epochSlots(epoch)
+	if headersFirst {
+		var err error
+		if slots, err = producedSlots(ctx, service, epoch); err != nil {
+			return nil, err
+		}
+	}
+	return fetchAttestationBlocks(ctx, service, slots, workers)
+}
+
+// producedSlots returns the slots of epoch whose block headers show a block
+// was produced. If the headers cannot be fetched it logs why and returns
+// every slot of epoch; only cancellation of ctx is an error.
+func producedSlots(ctx context.Context, service BeaconClient, epoch phase0.Epoch) ([]phase0.Slot, error) {
+	status, err := EpochSlotStatus(ctx, service, epoch)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, contextError(fmt.Sprintf("listing blocks of epoch %d", epoch), ctx.Err())
+		}
+		log.Warn().Err(err).Uint64("epoch", uint64(epoch)).Msg("failed fetching block headers; fetching every block")
+		return epochSlots(epoch), nil
+	}
+
+	slots := make([]phase0.Slot, 0, len(status))
+	for slot := EpochLowestSlot(epoch); slot <= EpochHighestSlot(epoch); slot++ {
+		if status[slot] {
+			slots = append(slots, slot)
+		}
+	}
+	return slots, nil
+}
+
+// ListEpochBlocksWithFailures is ListEpochBlocksConcurrent, but instead of
+// logging the slots whose block could not be fetched it returns their errors,
+// keyed by slot, so that a failed fetch is not taken for a missed slot.
+func ListEpochBlocksWithFailures(ctx context.Context, service BeaconClient, epoch phase0.Epoch, workers int) (map[phase0.Slot]*spec.VersionedSignedBeaconBlock, map[phase0.Slot]error, error) {
+	return fetchBlocksWithFailures(ctx, service, epochSlots(epoch), workers)
+}
+
+// fetchBlocks fetches the blocks at slots with up to workers requests in
+// flight. Individual failures are logged and skipped; only cancellation of ctx
+// stops the fetch early, in which case the blocks fetched so far are returned
+// alongside the error.
+func fetchBlocks(ctx context.Context, service BeaconClient, slots []phase0.Slot, workers int) (map[phase0.Slot]*spec.VersionedSignedBeaconBlock, error) {
+	result, failed, err := fetchBlocksWithFailures(ctx, service, slots, workers)
+	logBlockFailures(failed)
+	return result, err
+}
+
+// fetchAttestationBlocks is fetchBlocks with each block fetched by
+// getAttestationsBlock.
+func fetchAttestationBlocks(ctx context.Context, service BeaconClient, slots []phase0.Slot, workers int) (map[phase0.Slot]*spec.VersionedSignedBeaconBlock, error) {
+	result, failed, err := fetchBlocksWith(ctx, service, slots, workers, getAttestationsBlock)
+	logBlockFailures(failed)
+	return result, err
+}
+
+// logBlockFailures logs the block fetches that failed, in slot order.
+func logBlockFailures(failed map[phase0.Slot]error) {
+	for _, slot := range slices.Sorted(maps.Keys(failed)) {
+		log.Error().Err(failed[slot]).Uint64("slot", uint64(slot)).Msg("failed fetching block")
+	}
+}
+
+// fetchBlocksWithFailures is fetchBlocks, returning the error for each slot
+// that failed rather than logging it.
+func fetchBlocksWithFailures(ctx context.Context, service BeaconClient, slots []phase0.Slot, workers int) (map[phase0.Slot]*spec.VersionedSignedBeaconBlock, map[phase0.Slot]error, error) {
+	return fetchBlocksWith(ctx, service, slots, workers, func(ctx context.Context, service BeaconClient, slot phase0.Slot) (*spec.VersionedSignedBeaconBlock, error) {
+		return GetBlockWithRetry(ctx, service, slot, retryAttempts, retryBaseDelay)
+	})
+}
+
+// fetchBlocksWith is fetchBlocksWithFailures with each block fetched by get,
+// which reports a missed slot with an error matching ErrMissedSlot.
+func fetchBlocksWith(ctx context.Context, service BeaconClient, slots []phase0.Slot, workers int, get func(context.Context, BeaconClient, phase0.Slot) (*spec.VersionedSignedBeaconBlock, error)) (map[phase0.Slot]*spec.VersionedSignedBeaconBlock, map[phase0.Slot]error, error) {
+	result := make(map[phase0.Slot]*spec.VersionedSignedBeaconBlock, len(slots))
+	failed := make(map[phase0.Slot]error)
+	var mu sync.Mutex
+
+	var g errgroup.Group
+	if workers > 0 {
+		g.SetLimit(workers)
+	}
+
+	for _, slot := range slots {
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return contextError("fetching blocks", err)
+			}
+
+			block, err := get(ctx, service, slot)
+			if ctx.Err() != nil {
+				return contextError("fetching blocks", ctx.Err())
+			}
+			if errors.Is(err, ErrMissedSlot) {
+				return nil
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed[slot] = err
+				return nil
+			}
+			result[slot] = block
+			return nil
+		})
+	}
+	err := g.Wait()
+	return result, failed, err
+}
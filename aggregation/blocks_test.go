@@ -0,0 +1,511 @@
+package aggregation
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/attestantio/go-eth2-client/api"
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"repro/internal/testutil"
+)
+
+// failingBlockService fails every block request. Its other methods are not
+// implemented.
+type failingBlockService struct {
+	BeaconClient
+}
+
+func (failingBlockService) SignedBeaconBlock(context.Context, *api.SignedBeaconBlockOpts) (*api.Response[*spec.VersionedSignedBeaconBlock], error) {
+	return nil, errors.New("boom")
+}
+
+func TestListEpochBlocksLogsFetchErrors(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&buf)
+	defer func() { log.Logger = logger }()
+
+	blocks, err := ListEpochBlocks(context.Background(), failingBlockService{}, 0)
+	if err != nil {
+		t.Fatalf("ListEpochBlocks: %v", err)
+	}
+	if len(blocks) != 0 {
+		t.Fatalf("got %d blocks, want 0", len(blocks))
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != int(slotsPerEpoch) {
+		t.Fatalf("got %d log lines, want %d:\n%s", len(lines), slotsPerEpoch, buf.String())
+	}
+	for _, want := range []string{`"message":"failed fetching block"`, `"error":"boom"`, `"slot":0`} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("log line %q does not contain %s", lines[0], want)
+		}
+	}
+}
+
+func TestCancelledContextExitsEarly(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&buf)
+	defer func() { log.Logger = logger }()
+
+	client := testutil.NewFakeClient().WithBlock(1).WithCommittee(1, 0, []phase0.ValidatorIndex{1})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := ListEpochBlocks(ctx, client, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("ListEpochBlocks: got %v, want context.Canceled", err)
+	}
+	if _, err := ListEpochBlocksConcurrent(ctx, client, 0, DEFAULT_BLOCK_WORKERS); !errors.Is(err, context.Canceled) {
+		t.Errorf("ListEpochBlocksConcurrent: got %v, want context.Canceled", err)
+	}
+	if _, err := GetBeaconCommitees(ctx, client, 0, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetBeaconCommitees: got %v, want context.Canceled", err)
+	} else if !strings.Contains(err.Error(), "fetching committees for epoch 0") {
+		t.Errorf("GetBeaconCommitees: error %q does not name the operation", err)
+	}
+	if _, err := ProcessEpochRange(ctx, client, 0, 10, RangeOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("ProcessEpochRange: got %v, want context.Canceled", err)
+	}
+
+	if strings.Contains(buf.String(), "failed fetching block") {
+		t.Errorf("expected no per-slot error logs, got:\n%s", buf.String())
+	}
+}
+
+func TestGetBeaconCommiteesDuplicates(t *testing.T) {
+	identical := testutil.NewFakeClient().
+		WithCommittee(1, 0, []phase0.ValidatorIndex{1, 2}).
+		WithCommittee(1, 0, []phase0.ValidatorIndex{1, 2})
+	committees, err := GetBeaconCommitees(context.Background(), identical, 0, 0)
+	if err != nil {
+		t.Fatalf("identical duplicate: unexpected error %v", err)
+	}
+	if got := committees[1][0]; len(got) != 2 {
+		t.Errorf("identical duplicate: got committee %v", got)
+	}
+
+	conflicting := testutil.NewFakeClient().
+		WithCommittee(1, 0, []phase0.ValidatorIndex{1, 2}).
+		WithCommittee(1, 0, []phase0.ValidatorIndex{3, 4}).
+		WithCommittee(2, 0, []phase0.ValidatorIndex{5})
+	committees, err = GetBeaconCommitees(context.Background(), conflicting, 0, 0)
+	if !errors.Is(err, ErrDuplicateCommittee) {
+		t.Fatalf("conflicting duplicate: got %v, want ErrDuplicateCommittee", err)
+	}
+	var duplicate *DuplicateCommitteeError
+	if !errors.As(err, &duplicate) {
+		t.Fatalf("conflicting duplicate: %v is not a *DuplicateCommitteeError", err)
+	}
+	if duplicate.Slot != 1 || duplicate.Index != 0 || len(duplicate.First) != 2 || duplicate.First[0] != 1 || duplicate.Second[0] != 3 {
+		t.Errorf("conflicting duplicate: unexpected error fields %+v", duplicate)
+	}
+	if len(committees[2][0]) != 1 {
+		t.Errorf("conflicting duplicate: other committees were dropped: %v", committees)
+	}
+}
+
+func TestGetBeaconCommiteesConcurrentEpochs(t *testing.T) {
+	defer func(previous int) { workers = previous }(workers)
+	SetWorkers(3)
+
+	client := testutil.NewFakeClient().WithSlotsPerEpoch(2)
+	for slot := phase0.Slot(0); slot < 12; slot++ {
+		client.WithCommittee(slot, 0, []phase0.ValidatorIndex{phase0.ValidatorIndex(slot)})
+	}
+	// Conflicting duplicates in epochs 4 and 1, added out of order.
+	client.WithCommittee(9, 0, []phase0.ValidatorIndex{100}).
+		WithCommittee(3, 0, []phase0.ValidatorIndex{100})
+
+	committees, err := GetBeaconCommitees(context.Background(), client, 0, 5)
+	var failed *CommitteeFetchError
+	if !errors.As(err, &failed) {
+		t.Fatalf("got %v, want a *CommitteeFetchError", err)
+	}
+	if !slices.Equal(failed.Epochs, []phase0.Epoch{1, 4}) {
+		t.Errorf("got failed epochs %v, want [1 4]", failed.Epochs)
+	}
+	for slot := phase0.Slot(0); slot < 12; slot++ {
+		if got := committees.Validators(slot, 0); len(got) != 1 || got[0] != phase0.ValidatorIndex(slot) {
+			t.Errorf("slot %d: got committee %v, want [%d]", slot, got, slot)
+		}
+	}
+}
+
+func TestIsStateUnavailable(t *testing.T) {
+	apiError := func(code int, data string) error {
+		return fmt.Errorf("fetching committees: %w", &api.Error{Method: http.MethodGet, StatusCode: code, Data: []byte(data)})
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "404 for a missing state", err: apiError(http.StatusNotFound, `{"code":404,"message":"NOT_FOUND: beacon state at slot 64"}`), want: true},
+		{name: "404 for something else", err: apiError(http.StatusNotFound, `{"code":404,"message":"NOT_FOUND: unknown route"}`)},
+		{name: "404 saying state not found", err: apiError(http.StatusNotFound, `{"code":404,"message":"State not found"}`), want: true},
+		{name: "bare 404", err: apiError(http.StatusNotFound, "")},
+		{name: "500 for a pruned state", err: apiError(http.StatusInternalServerError, `{"message":"historic state pruned"}`), want: true},
+		{name: "500 for something else", err: apiError(http.StatusInternalServerError, `{"message":"database locked"}`)},
+		{name: "not an API error", err: errors.New("state not found")},
+	}
+	for _, tt := range tests {
+		if got := isStateUnavailable(tt.err); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnlimitedWorkers(t *testing.T) {
+	defer func(previous int) { workers = previous }(workers)
+	SetWorkers(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client := testutil.NewFakeClient().
+		WithCommittee(1, 0, []phase0.ValidatorIndex{1, 2}).
+		WithBlock(1)
+	committees, err := GetBeaconCommitees(ctx, client, 0, 1)
+	if err != nil || committees.Size(1) != 2 {
+		t.Errorf("GetBeaconCommitees: got size %d and %v, want 2", committees.Size(1), err)
+	}
+	status, err := EpochSlotStatus(ctx, client, 0)
+	if err != nil || !status[1] || status[2] {
+		t.Errorf("EpochSlotStatus: got %v and %v, want only slot 1 produced", status, err)
+	}
+}
+
+func TestGetCommitteesForEpochs(t *testing.T) {
+	defer func(previous uint64) { slotsPerEpoch = previous }(slotsPerEpoch)
+	slotsPerEpoch = 2
+	logger := log.Logger
+	defer func() { log.Logger = logger }()
+	var buf bytes.Buffer
+	log.Logger = zerolog.New(&buf)
+
+	client := testutil.NewFakeClient().WithSlotsPerEpoch(2)
+	for slot := phase0.Slot(0); slot < 12; slot++ {
+		client.WithCommittee(slot, 0, []phase0.ValidatorIndex{phase0.ValidatorIndex(slot)})
+	}
+	client.WithCommittee(9, 0, []phase0.ValidatorIndex{100})
+
+	committees, err := GetCommitteesForEpochs(context.Background(), client, []phase0.Epoch{1, 4, 5})
+	var failed *CommitteeFetchError
+	if !errors.As(err, &failed) || !slices.Equal(failed.Epochs, []phase0.Epoch{4}) {
+		t.Fatalf("got %v, want a *CommitteeFetchError for epoch 4", err)
+	}
+	for _, slot := range []phase0.Slot{2, 3, 8, 9, 10, 11} {
+		if !committees.Has(slot, 0) {
+			t.Errorf("slot %d: committee not fetched", slot)
+		}
+	}
+	if committees.Has(5, 0) {
+		t.Error("fetched committees for epoch 2, which was not asked for")
+	}
+
+	buf.Reset()
+	LogCommitteeEpochs(2, committees, zerolog.InfoLevel)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d log lines, want 3:\n%s", len(lines), buf.String())
+	}
+	if !strings.Contains(lines[0], `"duty_slot":3`) || !strings.Contains(lines[0], `"committee_epoch":1`) {
+		t.Errorf("slot 3 did not resolve to epoch 1: %s", lines[0])
+	}
+	for _, line := range lines[1:] {
+		if !strings.Contains(line, "no fetched committee epoch covers duty slot") {
+			t.Errorf("expected an unresolved duty slot of epoch 2: %s", line)
+		}
+	}
+}
+
+func TestProcessEpochRangeEpochBudget(t *testing.T) {
+	logger := log.Logger
+	log.Logger = zerolog.Nop()
+	defer func() { log.Logger = logger }()
+
+	client := testutil.NewFakeClient().WithLatency(time.Second).WithBlock(1)
+	results, err := ProcessEpochRange(context.Background(), client, 0, 1, RangeOptions{EpochBudget: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("ProcessEpochRange: %v", err)
+	}
+	if len(results) != 2 || !results[0].TimedOut || !results[1].TimedOut {
+		t.Fatalf("got %+v, want both epochs timed out", results)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteRangeSummary(&buf, results); err != nil {
+		t.Fatalf("WriteRangeSummary: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if got := strings.Join(strings.Fields(lines[len(lines)-1]), " "); got != "timed out 2" {
+		t.Errorf("summary does not count the timed out epochs:\n%s", buf.String())
+	}
+}
+
+func TestProcessEpochRangeResumeRetriesTimedOutEpoch(t *testing.T) {
+	defer func(previous uint64) { slotsPerEpoch = previous }(slotsPerEpoch)
+	logger := log.Logger
+	log.Logger = zerolog.Nop()
+	defer func() { log.Logger = logger }()
+	// Only the slow block may use up an epoch's budget.
+	defer requestLimiter.SetLimit(requestLimiter.Limit())
+	SetMaxRequestsPerSecond(0)
+
+	ctx := context.Background()
+	client := testutil.NewFakeClient().
+		WithSlotsPerEpoch(4).
+		WithBlock(4).
+		WithBlock(8).
+		WithBlockLatency(4, time.Second)
+	LoadSpec(ctx, client)
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	if err := WriteCheckpoint(path, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := RangeOptions{CheckpointFile: path, EpochBudget: 100 * time.Millisecond}
+	results, err := ProcessEpochRange(ctx, client, 1, 2, opts)
+	if err != nil {
+		t.Fatalf("ProcessEpochRange: %v", err)
+	}
+	if len(results) != 2 || !results[0].TimedOut || results[1].TimedOut {
+		t.Fatalf("got %+v, want epoch 1 timed out and epoch 2 done", results)
+	}
+	checkpoint, _, err := ReadCheckpoint(path)
+	if err != nil || checkpoint != 0 {
+		t.Fatalf("got checkpoint %d and %v, want it held at 0", checkpoint, err)
+	}
+
+	// Resuming after the checkpoint picks up the epoch that timed out.
+	client.WithBlockLatency(4, 0)
+	results, err = ProcessEpochRange(ctx, client, checkpoint+1, 2, opts)
+	if err != nil {
+		t.Fatalf("ProcessEpochRange: %v", err)
+	}
+	if len(results) != 2 || results[0].Epoch != 1 || results[0].TimedOut || results[0].Blocks != 1 || results[1].TimedOut {
+		t.Fatalf("got %+v, want epochs 1 and 2 processed", results)
+	}
+	if checkpoint, _, err := ReadCheckpoint(path); err != nil || checkpoint != 2 {
+		t.Errorf("got checkpoint %d and %v, want 2", checkpoint, err)
+	}
+}
+
+func TestProcessEpochRangeStopOnMismatch(t *testing.T) {
+	defer func(previous uint64) { slotsPerEpoch = previous }(slotsPerEpoch)
+	logger := log.Logger
+	log.Logger = zerolog.Nop()
+	defer func() { log.Logger = logger }()
+
+	ctx := context.Background()
+	client := testutil.NewFakeClient().
+		WithSlotsPerEpoch(4).
+		WithCommittee(1, 0, []phase0.ValidatorIndex{1, 2}).
+		WithCommittee(5, 0, []phase0.ValidatorIndex{3, 4}).
+		WithCommittee(6, 0, []phase0.ValidatorIndex{5, 6}).
+		WithBlock(2, attestation(1, []uint64{0}, 2)).
+		WithBlock(6, attestation(5, []uint64{0}, 3), attestation(5, []uint64{0}, 4)).
+		WithBlock(7, attestation(6, []uint64{0}, 5))
+	LoadSpec(ctx, client)
+
+	results, err := ProcessEpochRange(ctx, client, 0, 3, RangeOptions{StopOnMismatch: true})
+	if err != nil {
+		t.Fatalf("ProcessEpochRange: %v", err)
+	}
+	if len(results) != 2 || len(results[1].Mismatches) != 1 {
+		t.Fatalf("got %+v, want the scan to stop at epoch 1's first mismatch", results)
+	}
+	if got := results[1].Mismatches[0]; got.BlockSlot != 6 || got.Actual != 3 {
+		t.Errorf("got mismatch %+v, want the first one, in block 6", got)
+	}
+}
+
+func TestProcessEpochRangeSummaryCountsLateInclusions(t *testing.T) {
+	defer func(previous uint64) { slotsPerEpoch = previous }(slotsPerEpoch)
+	logger := log.Logger
+	log.Logger = zerolog.Nop()
+	defer func() { log.Logger = logger }()
+
+	sizes := map[phase0.CommitteeIndex]int{0: 2}
+	onTime := testutil.BuildAttestation(sizes, map[phase0.CommitteeIndex][]int{0: {0, 1}})
+	onTime.Data.Slot = 2
+	// Epoch 0's last slot, attested to two slots late, in epoch 1.
+	lastSlot := testutil.BuildAttestation(sizes, map[phase0.CommitteeIndex][]int{0: {0, 1}})
+	lastSlot.Data.Slot = 3
+	ctx := context.Background()
+	client := testutil.NewFakeClient().
+		WithSlotsPerEpoch(4).
+		WithCommittee(2, 0, []phase0.ValidatorIndex{1, 2}).
+		WithCommittee(3, 0, []phase0.ValidatorIndex{3, 4}).
+		WithBlock(3, onTime).
+		WithBlock(5, lastSlot)
+	LoadSpec(ctx, client)
+
+	results, err := ProcessEpochRange(ctx, client, 0, 1, RangeOptions{})
+	if err != nil {
+		t.Fatalf("ProcessEpochRange: %v", err)
+	}
+	summary := results[0].Summary
+	if summary.Attestations != 2 || summary.UniqueAttesters != 4 || summary.Participation != 1 {
+		t.Errorf("got %d attestations from %d attesters at %.2f participation, want 2 from 4 at 1", summary.Attestations, summary.UniqueAttesters, summary.Participation)
+	}
+	if summary.MaxInclusion != 2 {
+		t.Errorf("got max inclusion distance %d, want the late inclusion's 2", summary.MaxInclusion)
+	}
+	if results[1].Blocks != 1 {
+		t.Errorf("epoch 1 has %d blocks, want 1", results[1].Blocks)
+	}
+
+	var summaries []EpochSummary
+	if err := StreamEpochRange(ctx, client, 0, 0, func(summary EpochSummary) {
+		summaries = append(summaries, summary)
+	}); err != nil {
+		t.Fatalf("StreamEpochRange: %v", err)
+	}
+	if len(summaries) != 1 || summaries[0].UniqueAttesters != 4 {
+		t.Errorf("streamed %+v, want epoch 0 with 4 attesters", summaries)
+	}
+}
+
+func TestWriteRangeSummaryDirections(t *testing.T) {
+	results := []EpochResult{
+		{Epoch: 1, Blocks: 32, Mismatches: []Mismatch{
+			{Computed: 5, Actual: 7},
+			{Computed: 5, Actual: 4},
+			{Computed: 5, Actual: 6},
+		}},
+		{Epoch: 2, Blocks: 31, Mismatches: []Mismatch{
+			{Computed: 5, Actual: 3},
+			// Malformed but of the right length.
+			{Computed: 5, Actual: 5, Err: ErrEmptyCommitteeBits},
+		}},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteRangeSummary(&buf, results); err != nil {
+		t.Fatalf("WriteRangeSummary: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{"1 32 3 2 1", "2 31 2 0 1", "total 5 2 2"}
+	for i, line := range lines[1:] {
+		if got := strings.Join(strings.Fields(line), " "); got != want[i] {
+			t.Errorf("line %d: got %q, want %q", i+1, got, want[i])
+		}
+	}
+}
+
+func TestSortedBlocks(t *testing.T) {
+	client := testutil.NewFakeClient().WithBlock(7).WithBlock(2).WithBlock(30).WithBlock(3)
+	blocks, err := ListEpochBlocks(context.Background(), client, 0)
+	if err != nil {
+		t.Fatalf("ListEpochBlocks: %v", err)
+	}
+
+	var slots []phase0.Slot
+	for _, block := range SortedBlocks(blocks) {
+		slot, err := block.Slot()
+		if err != nil {
+			t.Fatalf("Slot: %v", err)
+		}
+		slots = append(slots, slot)
+	}
+	if want := []phase0.Slot{2, 3, 7, 30}; !slices.Equal(slots, want) {
+		t.Errorf("got slots %v, want %v", slots, want)
+	}
+}
+
+func TestMissedSlots(t *testing.T) {
+	defer func(previous uint64) { slotsPerEpoch = previous }(slotsPerEpoch)
+	slotsPerEpoch = 4
+	client := testutil.NewFakeClient().WithSlotsPerEpoch(4).WithBlock(5).WithBlock(7)
+	blocks, err := ListEpochBlocks(context.Background(), client, 1)
+	if err != nil {
+		t.Fatalf("ListEpochBlocks: %v", err)
+	}
+
+	if got, want := MissedSlots(blocks, 1), []phase0.Slot{4, 6}; !slices.Equal(got, want) {
+		t.Errorf("got missed slots %v, want %v", got, want)
+	}
+	var summary bytes.Buffer
+	if err := WriteEpochSummary(&summary, Summarize(1, blocks, nil, nil)); err != nil {
+		t.Fatalf("WriteEpochSummary: %v", err)
+	}
+	if !strings.Contains(summary.String(), "missed slots:  [4,6]") {
+		t.Errorf("summary does not list the missed slots:\n%s", summary.String())
+	}
+}
+
+func TestGetBlockMissedSlot(t *testing.T) {
+	client := testutil.NewFakeClient().WithBlock(1)
+	if _, err := GetBlock(context.Background(), client, 2); !errors.Is(err, ErrMissedSlot) {
+		t.Errorf("GetBlock: got %v, want ErrMissedSlot", err)
+	}
+	if block, err := GetBlock(context.Background(), client, 1); err != nil || block == nil {
+		t.Errorf("GetBlock: got %v, %v, want the block", block, err)
+	}
+}
+
+func TestListEpochBlocksHeadersFirst(t *testing.T) {
+	defer SetHeadersFirst(false)
+	SetHeadersFirst(true)
+
+	client := testutil.NewFakeClient().WithBlock(1).WithBlock(3)
+	blocks, err := ListEpochBlocksConcurrent(context.Background(), client, 0, DEFAULT_BLOCK_WORKERS)
+	if err != nil {
+		t.Fatalf("ListEpochBlocksConcurrent: %v", err)
+	}
+	if len(blocks) != 2 || blocks[1] == nil || blocks[3] == nil {
+		t.Errorf("got blocks %v, want slots 1 and 3", slices.Sorted(maps.Keys(blocks)))
+	}
+	for slot, want := range map[phase0.Slot]int{1: 1, 2: 0, 3: 1, 31: 0} {
+		if got := client.BlockRequests(slot); got != want {
+			t.Errorf("slot %d: got %d block requests, want %d", slot, got, want)
+		}
+	}
+}
+
+func TestGetSlotAttestations(t *testing.T) {
+	client := testutil.NewFakeClient().WithBlock(1, attestation(0, []uint64{0}, 3), attestation(0, []uint64{1}, 2))
+	attestations, err := GetSlotAttestations(context.Background(), client, 1)
+	if err != nil || len(attestations) != 2 {
+		t.Errorf("GetSlotAttestations: got %d attestations and %v, want 2", len(attestations), err)
+	}
+	attestations, err = GetSlotAttestations(context.Background(), client, 2)
+	if err != nil || attestations != nil {
+		t.Errorf("GetSlotAttestations: got %v and %v for a missed slot, want neither", attestations, err)
+	}
+}
+
+func TestListEpochAttestations(t *testing.T) {
+	client := testutil.NewFakeClient().WithBlock(1, attestation(0, []uint64{0}, 3)).WithBlock(3)
+	blocks, err := listEpochAttestations(context.Background(), client, 0, DEFAULT_BLOCK_WORKERS)
+	if err != nil {
+		t.Fatalf("listEpochAttestations: %v", err)
+	}
+	if len(blocks) != 2 || blocks[1] == nil || blocks[3] == nil {
+		t.Fatalf("got blocks %v, want slots 1 and 3", slices.Sorted(maps.Keys(blocks)))
+	}
+	if got := len(blocks[1].Electra.Message.Body.Attestations); got != 1 {
+		t.Errorf("slot 1: got %d attestations, want 1", got)
+	}
+	if got := len(blocks[3].Electra.Message.Body.Attestations); got != 0 {
+		t.Errorf("slot 3: got %d attestations, want none", got)
+	}
+	if len(MissedSlots(blocks, 0)) != 30 {
+		t.Errorf("got missed slots %v, want all but 1 and 3", MissedSlots(blocks, 0))
+	}
+}
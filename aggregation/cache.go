@@ -0,0 +1,84 @@
+package aggregation
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+)
+
+// CommitteeCache memoizes beacon committees per epoch. It is safe for
+// concurrent use.
+type CommitteeCache struct {
+	mu sync.Mutex
+	// maxEpochs bounds the number of cached epochs; 0 means unbounded.
+	maxEpochs int
+	entries   map[phase0.Epoch]*list.Element
+	// lru holds *committeeCacheEntry, most recently used at the front.
+	lru *list.List
+}
+
+type committeeCacheEntry struct {
+	epoch      phase0.Epoch
+	committees Committees
+}
+
+// NewCommitteeCache returns a cache holding at most maxEpochs epochs, evicting
+// the least recently used. A maxEpochs of 0 means no limit.
+func NewCommitteeCache(maxEpochs int) *CommitteeCache {
+	return &CommitteeCache{
+		maxEpochs: maxEpochs,
+		entries:   make(map[phase0.Epoch]*list.Element),
+		lru:       list.New(),
+	}
+}
+
+// Get returns the committees for epoch, fetching them from the beacon node if
+// they are not cached. The returned map must not be modified.
+func (c *CommitteeCache) Get(ctx context.Context, service BeaconClient, epoch phase0.Epoch) (Committees, error) {
+	c.mu.Lock()
+	if element, ok := c.entries[epoch]; ok {
+		c.lru.MoveToFront(element)
+		c.mu.Unlock()
+		return element.Value.(*committeeCacheEntry).committees, nil
+	}
+	c.mu.Unlock()
+
+	// Fetch without holding the lock so lookups for other epochs are not
+	// blocked behind the network.
+	committees, err := GetBeaconCommitees(ctx, service, epoch, epoch)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[epoch]; ok {
+		// Someone else fetched this whilst we were.
+		c.lru.MoveToFront(element)
+		return element.Value.(*committeeCacheEntry).committees, nil
+	}
+	c.entries[epoch] = c.lru.PushFront(&committeeCacheEntry{epoch: epoch, committees: committees})
+	if c.maxEpochs > 0 && c.lru.Len() > c.maxEpochs {
+		oldest := c.lru.Remove(c.lru.Back()).(*committeeCacheEntry)
+		delete(c.entries, oldest.epoch)
+	}
+	return committees, nil
+}
+
+// GetRange returns the committees for epochs start to end inclusive merged into
+// a single map keyed by slot.
+func (c *CommitteeCache) GetRange(ctx context.Context, service BeaconClient, start phase0.Epoch, end phase0.Epoch) (Committees, error) {
+	result := make(Committees)
+	for epoch := start; epoch <= end; epoch++ {
+		committees, err := c.Get(ctx, service, epoch)
+		if err != nil {
+			return nil, err
+		}
+		for slot, slotCommittees := range committees {
+			result[slot] = slotCommittees
+		}
+	}
+	return result, nil
+}
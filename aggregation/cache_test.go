@@ -0,0 +1,112 @@
+package aggregation
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+
+	"repro/internal/testutil"
+)
+
+// committeeClient returns a fake with 4-slot epochs and, in every slot of the
+// first epochs epochs, a single committee whose only member is the slot's
+// number.
+func committeeClient(epochs phase0.Epoch) *testutil.FakeClient {
+	client := testutil.NewFakeClient().WithSlotsPerEpoch(4)
+	for slot := phase0.Slot(0); slot < phase0.Slot(epochs)*4; slot++ {
+		client.WithCommittee(slot, 0, []phase0.ValidatorIndex{phase0.ValidatorIndex(slot)})
+	}
+	return client
+}
+
+func TestCommitteeCacheEviction(t *testing.T) {
+	defer func(previous uint64) { slotsPerEpoch = previous }(slotsPerEpoch)
+	slotsPerEpoch = 4
+
+	ctx := context.Background()
+	client := committeeClient(3)
+	cache := NewCommitteeCache(2)
+	get := func(epoch phase0.Epoch) {
+		t.Helper()
+		committees, err := cache.Get(ctx, client, epoch)
+		if err != nil {
+			t.Fatalf("Get(%d): %v", epoch, err)
+		}
+		slot := EpochLowestSlot(epoch)
+		if got := committees.Validators(slot, 0); len(got) != 1 || got[0] != phase0.ValidatorIndex(slot) {
+			t.Fatalf("Get(%d): got committee %v at slot %d", epoch, got, slot)
+		}
+	}
+	requests := func(want ...int) {
+		t.Helper()
+		for epoch, w := range want {
+			if got := client.CommitteeRequests(phase0.Epoch(epoch)); got != w {
+				t.Errorf("epoch %d: got %d committee requests, want %d", epoch, got, w)
+			}
+		}
+	}
+
+	get(0)
+	get(1)
+	get(0)
+	requests(1, 1, 0)
+
+	// Epoch 1 is now the least recently used, so it makes way for epoch 2.
+	get(2)
+	get(0)
+	requests(1, 1, 1)
+	get(1)
+	requests(1, 2, 1)
+
+	// Fetching epoch 1 again evicted epoch 2, not epoch 0.
+	get(0)
+	get(2)
+	requests(1, 2, 2)
+}
+
+func TestCommitteeCacheConcurrent(t *testing.T) {
+	defer func(previous uint64) { slotsPerEpoch = previous }(slotsPerEpoch)
+	slotsPerEpoch = 4
+
+	ctx := context.Background()
+	client := committeeClient(4)
+	cache := NewCommitteeCache(0)
+
+	var wg sync.WaitGroup
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				committees, err := cache.Get(ctx, client, phase0.Epoch(i/2%4))
+				if err != nil || len(committees) != 4 {
+					t.Errorf("Get: got %d slots and %v, want 4", len(committees), err)
+				}
+				return
+			}
+			committees, err := cache.GetRange(ctx, client, 0, 3)
+			if err != nil || len(committees) != 16 {
+				t.Errorf("GetRange: got %d slots and %v, want 16", len(committees), err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	before := make([]int, 4)
+	for epoch := range before {
+		before[epoch] = client.CommitteeRequests(phase0.Epoch(epoch))
+		if before[epoch] == 0 {
+			t.Errorf("epoch %d: never fetched", epoch)
+		}
+	}
+	if _, err := cache.GetRange(ctx, client, 0, 3); err != nil {
+		t.Fatalf("GetRange: %v", err)
+	}
+	for epoch, want := range before {
+		if got := client.CommitteeRequests(phase0.Epoch(epoch)); got != want {
+			t.Errorf("epoch %d: a cached epoch was fetched again, %d requests after %d", epoch, got, want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package aggregation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	apiv1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog/log"
+)
+
+// FixtureBlockPath is where CaptureMismatches writes the block at slot in dir.
+func FixtureBlockPath(dir string, slot phase0.Slot) string {
+	return filepath.Join(dir, fmt.Sprintf("block-%d.json", slot))
+}
+
+// FixtureCommitteesPath is where CaptureMismatches writes the committees
+// needed to check the block at slot in dir.
+func FixtureCommitteesPath(dir string, slot phase0.Slot) string {
+	return filepath.Join(dir, fmt.Sprintf("committees-%d.json", slot))
+}
+
+// CaptureMismatches writes a fixture to dir for every block with a mismatch:
+// the block as the beacon API serves it, and the committees of the slots its
+// mismatching attestations are for, as a list of beacon API committees. Blocks
+// not in blocks, such as the first block of the following epoch checked by
+// FindEpochMismatches, are fetched again. Capturing is best effort, so any
+// failure is logged and the rest are still attempted.
+func CaptureMismatches(ctx context.Context, service BeaconClient, dir string, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, committees Committees, mismatches []Mismatch) {
+	dutySlots := make(map[phase0.Slot][]phase0.Slot)
+	for _, mismatch := range mismatches {
+		if mismatch.Pool || slices.Contains(dutySlots[mismatch.BlockSlot], mismatch.DutySlot) {
+			continue
+		}
+		dutySlots[mismatch.BlockSlot] = append(dutySlots[mismatch.BlockSlot], mismatch.DutySlot)
+	}
+	if len(dutySlots) == 0 {
+		return
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Error().Err(err).Str("dir", dir).Msg("failed creating capture directory")
+		return
+	}
+
+	for _, slot := range slices.Sorted(maps.Keys(dutySlots)) {
+		block, ok := blocks[slot]
+		if !ok {
+			var err error
+			block, err = GetBlockWithRetry(ctx, service, slot, retryAttempts, retryBaseDelay)
+			if err != nil {
+				log.Error().Err(err).Uint64("slot", uint64(slot)).Msg("failed fetching block to capture")
+				continue
+			}
+		}
+		if err := captureFixture(dir, slot, block, committees, dutySlots[slot]); err != nil {
+			log.Error().Err(err).Uint64("slot", uint64(slot)).Msg("failed capturing fixture")
+			continue
+		}
+		log.Info().Uint64("slot", uint64(slot)).Str("dir", dir).Msg("captured fixture")
+	}
+}
+
+func captureFixture(dir string, slot phase0.Slot, block *spec.VersionedSignedBeaconBlock, committees Committees, dutySlots []phase0.Slot) error {
+	if block.Version != spec.DataVersionElectra || block.Electra == nil {
+		return fmt.Errorf("cannot capture %s block", block.Version)
+	}
+	blockJSON, err := json.MarshalIndent(block.Electra, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding block: %w", err)
+	}
+
+	list := []*apiv1.BeaconCommittee{}
+	slices.Sort(dutySlots)
+	for _, dutySlot := range dutySlots {
+		for _, index := range slices.Sorted(maps.Keys(committees[dutySlot])) {
+			list = append(list, &apiv1.BeaconCommittee{
+				Slot:       dutySlot,
+				Index:      index,
+				Validators: committees.Validators(dutySlot, index),
+			})
+		}
+	}
+	committeesJSON, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding committees: %w", err)
+	}
+
+	if err := os.WriteFile(FixtureBlockPath(dir, slot), append(blockJSON, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(FixtureCommitteesPath(dir, slot), append(committeesJSON, '\n'), 0o644)
+}
+
+// LoadFixture reads back the block at slot and its committees as written to
+// dir by CaptureMismatches.
+func LoadFixture(dir string, slot phase0.Slot) (*electra.SignedBeaconBlock, []*apiv1.BeaconCommittee, error) {
+	data, err := os.ReadFile(FixtureBlockPath(dir, slot))
+	if err != nil {
+		return nil, nil, err
+	}
+	block := &electra.SignedBeaconBlock{}
+	if err := json.Unmarshal(data, block); err != nil {
+		return nil, nil, fmt.Errorf("invalid block in %s: %w", FixtureBlockPath(dir, slot), err)
+	}
+
+	data, err = os.ReadFile(FixtureCommitteesPath(dir, slot))
+	if err != nil {
+		return nil, nil, err
+	}
+	var committees []*apiv1.BeaconCommittee
+	if err := json.Unmarshal(data, &committees); err != nil {
+		return nil, nil, fmt.Errorf("invalid committees in %s: %w", FixtureCommitteesPath(dir, slot), err)
+	}
+	return block, committees, nil
+}
@@ -0,0 +1,131 @@
+package aggregation
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/altair"
+	"github.com/attestantio/go-eth2-client/spec/deneb"
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/holiman/uint256"
+	"github.com/prysmaticlabs/go-bitfield"
+
+	"repro/internal/testutil"
+)
+
+func TestCaptureMismatchesReplays(t *testing.T) {
+	defer func(previous uint64) { slotsPerEpoch = previous }(slotsPerEpoch)
+	ctx := context.Background()
+	client := testutil.NewFakeClient().
+		WithSlotsPerEpoch(8).
+		WithCommittee(3, 0, []phase0.ValidatorIndex{1, 2, 3}).
+		WithCommittee(3, 1, []phase0.ValidatorIndex{4, 5}).
+		WithCommittee(5, 0, []phase0.ValidatorIndex{6, 7}).
+		WithCommittee(7, 0, []phase0.ValidatorIndex{8, 9, 10}).
+		WithSignedBlock(fullBlock(4, attestation(3, []uint64{0, 1}, 4), attestation(3, []uint64{0}, 3))).
+		WithSignedBlock(fullBlock(6, attestation(5, []uint64{0}, 2))).
+		// The next epoch's first block carries the last slot's mismatch.
+		WithSignedBlock(fullBlock(8, attestation(7, []uint64{0}, 4)))
+	LoadSpec(ctx, client)
+
+	blocks, err := ListEpochBlocks(ctx, client, 0)
+	if err != nil {
+		t.Fatalf("ListEpochBlocks: %v", err)
+	}
+	committees, err := GetBeaconCommitees(ctx, client, 0, 0)
+	if err != nil {
+		t.Fatalf("GetBeaconCommitees: %v", err)
+	}
+	mismatches, err := FindEpochMismatches(ctx, client, 0, blocks, committees)
+	if err != nil {
+		t.Fatalf("FindEpochMismatches: %v", err)
+	}
+	if len(mismatches) != 2 {
+		t.Fatalf("got %d mismatches, want 2: %+v", len(mismatches), mismatches)
+	}
+
+	dir := filepath.Join(t.TempDir(), "fixtures")
+	CaptureMismatches(ctx, client, dir, blocks, committees, mismatches)
+	if _, err := os.Stat(FixtureBlockPath(dir, 6)); !os.IsNotExist(err) {
+		t.Errorf("captured block 6 without a mismatch: %v", err)
+	}
+
+	for _, slot := range []phase0.Slot{4, 8} {
+		block, fixtureCommittees, err := LoadFixture(dir, slot)
+		if err != nil {
+			t.Fatalf("LoadFixture(%d): %v", slot, err)
+		}
+		replay := testutil.NewFakeClient().
+			WithSlotsPerEpoch(8).
+			WithSignedBlock(block).
+			WithBeaconCommittees(fixtureCommittees...)
+		replayCommittees, err := GetBeaconCommitees(ctx, replay, SlotEpoch(slot-1), SlotEpoch(slot-1))
+		if err != nil {
+			t.Fatalf("GetBeaconCommitees: %v", err)
+		}
+		replayBlock, err := GetBlock(ctx, replay, slot)
+		if err != nil {
+			t.Fatalf("GetBlock: %v", err)
+		}
+		replayed, err := CheckBlock(replayBlock, replayCommittees)
+		if err != nil {
+			t.Fatalf("CheckBlock: %v", err)
+		}
+		if len(replayed) != 1 || replayed[0].BlockSlot != slot || replayed[0].Computed != mismatchAt(mismatches, slot).Computed {
+			t.Errorf("slot %d: replayed %+v, want %+v", slot, replayed, mismatchAt(mismatches, slot))
+		}
+	}
+}
+
+func TestCaptureMismatchesUnwritableDir(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	client := testutil.NewFakeClient().
+		WithCommittee(1, 0, []phase0.ValidatorIndex{1, 2, 3}).
+		WithBlock(2, attestation(1, []uint64{0}, 4))
+	blocks, err := ListEpochBlocks(context.Background(), client, 0)
+	if err != nil {
+		t.Fatalf("ListEpochBlocks: %v", err)
+	}
+	committees, err := GetBeaconCommitees(context.Background(), client, 0, 0)
+	if err != nil {
+		t.Fatalf("GetBeaconCommitees: %v", err)
+	}
+
+	// Only logged: the directory cannot be created under a file.
+	CaptureMismatches(context.Background(), client, filepath.Join(file, "fixtures"), blocks, committees, FindAggregationMismatches(blocks, committees))
+}
+
+// fullBlock is an Electra block at slot with every field a beacon node would
+// serve filled in, unlike those of FakeClient.WithBlock, so that it survives
+// the JSON round trip of a fixture.
+func fullBlock(slot phase0.Slot, attestations ...*electra.Attestation) *electra.SignedBeaconBlock {
+	return &electra.SignedBeaconBlock{
+		Message: &electra.BeaconBlock{
+			Slot: slot,
+			Body: &electra.BeaconBlockBody{
+				ETH1Data:     &phase0.ETH1Data{BlockHash: make([]byte, 32)},
+				Attestations: attestations,
+				SyncAggregate: &altair.SyncAggregate{
+					SyncCommitteeBits: bitfield.NewBitvector512(),
+				},
+				ExecutionPayload:  &deneb.ExecutionPayload{BaseFeePerGas: uint256.NewInt(0)},
+				ExecutionRequests: &electra.ExecutionRequests{},
+			},
+		},
+	}
+}
+
+func mismatchAt(mismatches []Mismatch, blockSlot phase0.Slot) Mismatch {
+	for _, mismatch := range mismatches {
+		if mismatch.BlockSlot == blockSlot {
+			return mismatch
+		}
+	}
+	return Mismatch{}
+}
@@ -0,0 +1,236 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	eth2client "github.com/attestantio/go-eth2-client"
+	"github.com/attestantio/go-eth2-client/api"
+	apiv1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DEFAULT_SLOTS_PER_EPOCH is used when the beacon node's spec cannot be read.
+	DEFAULT_SLOTS_PER_EPOCH = 32
+
+	// DEFAULT_EPOCHS_PER_SYNC_COMMITTEE_PERIOD is mainnet's sync committee
+	// period, used when the beacon node's spec cannot be read.
+	DEFAULT_EPOCHS_PER_SYNC_COMMITTEE_PERIOD = 256
+
+	// DEFAULT_MAX_COMMITTEES_PER_SLOT is mainnet's MAX_COMMITTEES_PER_SLOT,
+	// used when the beacon node's spec cannot be read.
+	DEFAULT_MAX_COMMITTEES_PER_SLOT = maxCommitteesPerSlot
+
+	// DEFAULT_TARGET_COMMITTEE_SIZE is mainnet's TARGET_COMMITTEE_SIZE, used
+	// when the beacon node's spec cannot be read.
+	DEFAULT_TARGET_COMMITTEE_SIZE = 128
+
+	// DEFAULT_BLOCK_WORKERS is the default number of block or committee
+	// requests issued in parallel.
+	DEFAULT_BLOCK_WORKERS = 8
+
+	// DEFAULT_REQUEST_TIMEOUT bounds each individual beacon node request.
+	DEFAULT_REQUEST_TIMEOUT = time.Minute
+
+	// DEFAULT_MAX_RPS caps the beacon node requests made per second.
+	DEFAULT_MAX_RPS = 50
+)
+
+// slotsPerEpoch is read from the beacon node once at startup by LoadSpec.
+var slotsPerEpoch uint64 = DEFAULT_SLOTS_PER_EPOCH
+
+// workers is the number of block or committee requests issued in parallel by
+// the functions that do not take it as an argument.
+var workers = DEFAULT_BLOCK_WORKERS
+
+// SetWorkers sets how many block or committee requests are issued in
+// parallel. Zero or less removes the limit.
+func SetWorkers(n int) {
+	workers = n
+}
+
+// epochsPerSyncCommitteePeriod is read from the beacon node by LoadSpec.
+var epochsPerSyncCommitteePeriod uint64 = DEFAULT_EPOCHS_PER_SYNC_COMMITTEE_PERIOD
+
+// targetCommitteeSize is read from the beacon node by LoadSpec.
+var targetCommitteeSize uint64 = DEFAULT_TARGET_COMMITTEE_SIZE
+
+// specMaxCommitteesPerSlot is read from the beacon node by LoadSpec.
+var specMaxCommitteesPerSlot uint64 = DEFAULT_MAX_COMMITTEES_PER_SLOT
+
+// requestTimeout bounds each beacon node request made by this package.
+var requestTimeout = DEFAULT_REQUEST_TIMEOUT
+
+// requestLimiter paces every beacon node request made by this package.
+var requestLimiter = rate.NewLimiter(DEFAULT_MAX_RPS, 1)
+
+// SetMaxRequestsPerSecond limits beacon node requests to rps per second. Zero
+// removes the limit.
+func SetMaxRequestsPerSecond(rps float64) {
+	if rps == 0 {
+		requestLimiter.SetLimit(rate.Inf)
+		return
+	}
+	requestLimiter.SetLimit(rate.Limit(rps))
+}
+
+// SetRequestTimeout sets the timeout applied to each beacon node request.
+func SetRequestTimeout(timeout time.Duration) {
+	requestTimeout = timeout
+}
+
+// fetchSpec fetches the beacon node's spec, retrying transient failures.
+func fetchSpec(ctx context.Context, service BeaconClient) (map[string]any, error) {
+	var resp *api.Response[map[string]any]
+	err := withRetry(ctx, retryAttempts, retryBaseDelay, func() error {
+		requestCtx, cancel := requestContext(ctx)
+		defer cancel()
+
+		var err error
+		resp, err = service.Spec(requestCtx, &api.SpecOpts{})
+		return err
+	})
+	if err != nil {
+		return nil, contextError("fetching spec", err)
+	}
+	return resp.Data, nil
+}
+
+// LoadSpec fetches the beacon node's spec once and caches the values this
+// package reads from it: SLOTS_PER_EPOCH, MAX_COMMITTEES_PER_SLOT,
+// EPOCHS_PER_SYNC_COMMITTEE_PERIOD and TARGET_COMMITTEE_SIZE. A value the spec
+// does not hold, or every value if the spec cannot be fetched, falls back to
+// its DEFAULT_*.
+func LoadSpec(ctx context.Context, service BeaconClient) {
+	data, err := fetchSpec(ctx, service)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed fetching spec, using defaults")
+	}
+
+	slotsPerEpoch = specUint64(data, "SLOTS_PER_EPOCH", DEFAULT_SLOTS_PER_EPOCH)
+	epochsPerSyncCommitteePeriod = specUint64(data, "EPOCHS_PER_SYNC_COMMITTEE_PERIOD", DEFAULT_EPOCHS_PER_SYNC_COMMITTEE_PERIOD)
+	targetCommitteeSize = specUint64(data, "TARGET_COMMITTEE_SIZE", DEFAULT_TARGET_COMMITTEE_SIZE)
+	specMaxCommitteesPerSlot = specUint64(data, "MAX_COMMITTEES_PER_SLOT", DEFAULT_MAX_COMMITTEES_PER_SLOT)
+	if specMaxCommitteesPerSlot > maxCommitteesPerSlot {
+		log.Warn().Uint64("value", specMaxCommitteesPerSlot).Uint64("default", DEFAULT_MAX_COMMITTEES_PER_SLOT).Msg("MAX_COMMITTEES_PER_SLOT exceeds what committee bits can hold, using default")
+		specMaxCommitteesPerSlot = DEFAULT_MAX_COMMITTEES_PER_SLOT
+	}
+}
+
+// specUint64 returns the positive integer data holds under key, or fallback if
+// there is none. A nil data, a spec that could not be fetched, gives fallback
+// without a warning of its own.
+func specUint64(data map[string]any, key string, fallback uint64) uint64 {
+	if data == nil {
+		return fallback
+	}
+	value, ok := data[key].(uint64)
+	if !ok || value == 0 {
+		log.Warn().Interface("value", data[key]).Uint64("default", fallback).Msgf("spec has no usable %s, using default", key)
+		return fallback
+	}
+	return value
+}
+
+// SyncCommitteePeriod returns the sync committee period epoch belongs to, for
+// periods of epochsPerPeriod epochs.
+func SyncCommitteePeriod(epoch phase0.Epoch, epochsPerPeriod uint64) uint64 {
+	if epochsPerPeriod == 0 {
+		return 0
+	}
+	return uint64(epoch) / epochsPerPeriod
+}
+
+// SlotEpoch returns the epoch slot belongs to.
+func SlotEpoch(slot phase0.Slot) phase0.Epoch {
+	return phase0.Epoch(uint64(slot) / slotsPerEpoch)
+}
+
+func EpochLowestSlot(epoch phase0.Epoch) phase0.Slot {
+	return phase0.Slot(uint64(epoch) * slotsPerEpoch)
+}
+
+func EpochHighestSlot(epoch phase0.Epoch) phase0.Slot {
+	return phase0.Slot(((uint64(epoch) + 1) * slotsPerEpoch) - 1)
+}
+
+// LatestFinalizedEpoch returns the most recent finalized epoch known to the
+// beacon node, retrying transient failures.
+func LatestFinalizedEpoch(ctx context.Context, service BeaconClient) (phase0.Epoch, error) {
+	var resp *api.Response[*apiv1.Finality]
+	err := withRetry(ctx, retryAttempts, retryBaseDelay, func() error {
+		requestCtx, cancel := requestContext(ctx)
+		defer cancel()
+
+		var err error
+		resp, err = service.Finality(requestCtx, &api.FinalityOpts{
+			State: "head",
+		})
+		return err
+	})
+	if err != nil {
+		return 0, contextError("fetching finality", err)
+	}
+	if resp.Data == nil || resp.Data.Finalized == nil {
+		return 0, errors.New("no finalized checkpoint returned")
+	}
+	return resp.Data.Finalized.Epoch, nil
+}
+
+// fetchGenesis fetches the beacon node's genesis, retrying transient failures.
+func fetchGenesis(ctx context.Context, service BeaconClient) (*apiv1.Genesis, error) {
+	provider, ok := service.(eth2client.GenesisProvider)
+	if !ok {
+		return nil, errors.New("beacon client does not provide genesis")
+	}
+	var genesis *apiv1.Genesis
+	err := withRetry(ctx, retryAttempts, retryBaseDelay, func() error {
+		requestCtx, cancel := requestContext(ctx)
+		defer cancel()
+
+		resp, err := provider.Genesis(requestCtx, &api.GenesisOpts{})
+		if err != nil {
+			return err
+		}
+		genesis = resp.Data
+		return nil
+	})
+	if err != nil {
+		return nil, contextError("fetching genesis", err)
+	}
+	if genesis == nil {
+		return nil, errors.New("no genesis returned")
+	}
+	return genesis, nil
+}
+
+// GenesisValidatorsRoot returns the beacon node's genesis validators root,
+// which tells networks apart.
+func GenesisValidatorsRoot(ctx context.Context, service BeaconClient) (phase0.Root, error) {
+	genesis, err := fetchGenesis(ctx, service)
+	if err != nil {
+		return phase0.Root{}, err
+	}
+	return genesis.GenesisValidatorsRoot, nil
+}
+
+// HeadEpoch returns the epoch of the beacon node's head block.
+func HeadEpoch(ctx context.Context, service BeaconClient) (phase0.Epoch, error) {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
+	resp, err := service.BeaconBlockHeader(ctx, &api.BeaconBlockHeaderOpts{
+		Block: "head",
+	})
+	if err != nil {
+		return 0, contextError("fetching head header", err)
+	}
+	if resp.Data == nil || resp.Data.Header == nil || resp.Data.Header.Message == nil {
+		return 0, errors.New("no head header returned")
+	}
+	return SlotEpoch(resp.Data.Header.Message.Slot), nil
+}
@@ -0,0 +1,78 @@
+package aggregation
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestEpochSlotBoundaries(t *testing.T) {
+	tests := []struct {
+		name          string
+		slotsPerEpoch uint64
+		epoch         phase0.Epoch
+		lowest        phase0.Slot
+		highest       phase0.Slot
+	}{
+		{name: "genesis", slotsPerEpoch: 32, epoch: 0, lowest: 0, highest: 31},
+		{name: "mid range", slotsPerEpoch: 32, epoch: 300000, lowest: 9600000, highest: 9600031},
+		{
+			name:          "before overflow",
+			slotsPerEpoch: 32,
+			epoch:         math.MaxUint64/32 - 1,
+			lowest:        phase0.Slot((math.MaxUint64/32 - 1) * 32),
+			highest:       phase0.Slot((math.MaxUint64/32)*32 - 1),
+		},
+		{name: "minimal genesis", slotsPerEpoch: 8, epoch: 0, lowest: 0, highest: 7},
+		{name: "minimal mid range", slotsPerEpoch: 8, epoch: 1000, lowest: 8000, highest: 8007},
+	}
+
+	defer func(previous uint64) { slotsPerEpoch = previous }(slotsPerEpoch)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slotsPerEpoch = tt.slotsPerEpoch
+
+			lowest, highest := EpochLowestSlot(tt.epoch), EpochHighestSlot(tt.epoch)
+			if lowest != tt.lowest || highest != tt.highest {
+				t.Fatalf("epoch %d: got [%d, %d], want [%d, %d]", tt.epoch, lowest, highest, tt.lowest, tt.highest)
+			}
+			if got := uint64(highest-lowest) + 1; got != tt.slotsPerEpoch {
+				t.Errorf("epoch %d spans %d slots, want %d", tt.epoch, got, tt.slotsPerEpoch)
+			}
+			if next := EpochLowestSlot(tt.epoch + 1); next != highest+1 {
+				t.Errorf("epoch %d starts at %d, want %d", tt.epoch+1, next, highest+1)
+			}
+		})
+	}
+}
+
+func TestSpecUint64(t *testing.T) {
+	logger := log.Logger
+	log.Logger = zerolog.Nop()
+	defer func() { log.Logger = logger }()
+
+	data := map[string]any{"SLOTS_PER_EPOCH": uint64(8), "ZERO": uint64(0), "DURATION": 12 * time.Second}
+	tests := []struct {
+		name string
+		data map[string]any
+		key  string
+		want uint64
+	}{
+		{name: "present", data: data, key: "SLOTS_PER_EPOCH", want: 8},
+		{name: "missing", data: data, key: "TARGET_COMMITTEE_SIZE", want: 32},
+		{name: "zero", data: data, key: "ZERO", want: 32},
+		{name: "wrong type", data: data, key: "DURATION", want: 32},
+		{name: "no spec", data: nil, key: "SLOTS_PER_EPOCH", want: 32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := specUint64(tt.data, tt.key, 32); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package aggregation
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+)
+
+// ReadCheckpoint returns the last fully processed epoch recorded at path. The
+// boolean is false if no checkpoint has been written yet.
+func ReadCheckpoint(path string) (phase0.Epoch, bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	epoch, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid checkpoint in %s: %w", path, err)
+	}
+	return phase0.Epoch(epoch), true, nil
+}
+
+// WriteCheckpoint records epoch as the last fully processed epoch at path. The
+// file is replaced atomically so an interrupted write leaves the previous
+// checkpoint intact.
+func WriteCheckpoint(path string, epoch phase0.Epoch) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := fmt.Fprintf(tmp, "%d\n", epoch); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,15 @@
+package aggregation
+
+import (
+	eth2client "github.com/attestantio/go-eth2-client"
+)
+
+// BeaconClient is the part of the beacon node API the analysis relies on. The
+// eth2http service satisfies it; tests can substitute a fake.
+type BeaconClient interface {
+	eth2client.SignedBeaconBlockProvider
+	eth2client.BeaconCommitteesProvider
+	eth2client.BeaconBlockHeadersProvider
+	eth2client.SpecProvider
+	eth2client.FinalityProvider
+}
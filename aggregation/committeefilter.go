@@ -0,0 +1,99 @@
+package aggregation
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+)
+
+// CommitteeSegment is the part of an attestation's aggregation bits that
+// belongs to a single committee, as reported by --committee-index.
+type CommitteeSegment struct {
+	Index phase0.CommitteeIndex `json:"index"`
+	// Offset is where the committee's bits start: the summed size of the
+	// attestation's lower committees.
+	Offset         uint64 `json:"offset"`
+	ExpectedLength uint64 `json:"expected_length"`
+	// ActualLength is how many of the committee's bits the aggregation bits
+	// hold, which falls short of ExpectedLength if they end early.
+	ActualLength  uint64 `json:"actual_length"`
+	AttesterCount uint64 `json:"attester_count"`
+}
+
+// committeeSegment locates the bits of committee index within the checked
+// attestation, which is for slot.
+func committeeSegment(attestation AttestationReport, slot phase0.Slot, committees Committees, index phase0.CommitteeIndex) *CommitteeSegment {
+	segment := &CommitteeSegment{
+		Index:          index,
+		ExpectedLength: uint64(committees.CommitteeSize(slot, index)),
+	}
+	for _, other := range attestation.CommitteeIndices {
+		if other < index {
+			segment.Offset += uint64(committees.CommitteeSize(slot, other))
+		}
+	}
+	end := min(segment.Offset+segment.ExpectedLength, attestation.aggregationBits.Len())
+	for i := segment.Offset; i < end; i++ {
+		segment.ActualLength++
+		if attestation.aggregationBits.BitAt(i) {
+			segment.AttesterCount++
+		}
+	}
+	return segment
+}
+
+// FilterReportsByCommittee narrows reports down to committee index: each
+// keeps only the attestations whose committee bits include it, with their
+// Committee segment filled in, and its expected committee length is that of
+// the one committee. A slot is only marked as a mismatch if one of the
+// attestations kept is.
+func FilterReportsByCommittee(reports []BlockAttestationReport, committees Committees, index phase0.CommitteeIndex) {
+	for i := range reports {
+		report := &reports[i]
+		report.ExpectedCommitteeLength = uint64(committees.CommitteeSize(report.DutySlot, index))
+		report.Mismatch = false
+		kept := report.Attestations[:0]
+		for _, attestation := range report.Attestations {
+			if !slices.Contains(attestation.CommitteeIndices, index) {
+				continue
+			}
+			attestation.Committee = committeeSegment(attestation, attestation.slot, committees, index)
+			report.Mismatch = report.Mismatch || attestation.Mismatch
+			kept = append(kept, attestation)
+		}
+		report.Attestations = kept
+	}
+}
+
+// FilterMismatchesByCommittee returns the mismatches whose attestations cover
+// committee index.
+func FilterMismatchesByCommittee(mismatches []Mismatch, index phase0.CommitteeIndex) []Mismatch {
+	var filtered []Mismatch
+	for _, mismatch := range mismatches {
+		if slices.Contains(mismatch.CommitteeIndices, index) {
+			filtered = append(filtered, mismatch)
+		}
+	}
+	return filtered
+}
+
+// WriteCommitteeSegments writes, for every attestation left in reports by
+// FilterReportsByCommittee, where the committee's bits lie and how many of
+// its members attested, next to the attestation's overall length check.
+func WriteCommitteeSegments(w io.Writer, reports []BlockAttestationReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "block\tduty\toffset\texpected\tactual\tattesters\tmismatch\t")
+	for _, report := range reports {
+		for _, attestation := range report.Attestations {
+			segment := attestation.Committee
+			if segment == nil {
+				continue
+			}
+			fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%d\t%t\t\n", report.BlockSlot, report.DutySlot, segment.Offset, segment.ExpectedLength, segment.ActualLength, segment.AttesterCount, attestation.Mismatch)
+		}
+	}
+	return tw.Flush()
+}
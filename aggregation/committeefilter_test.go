@@ -0,0 +1,80 @@
+package aggregation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+
+	"repro/internal/testutil"
+)
+
+func TestFilterReportsByCommittee(t *testing.T) {
+	// Committee 1 sits after committee 0 in the first aggregate, first in
+	// the second, and cut off entirely in the third.
+	first := attestation(1, []uint64{0, 1}, 5)
+	first.AggregationBits.SetBitAt(3, true)
+	first.AggregationBits.SetBitAt(4, true)
+	second := attestation(1, []uint64{1, 2}, 6)
+	second.AggregationBits.SetBitAt(0, true)
+	short := attestation(1, []uint64{0, 1}, 3)
+	client := testutil.NewFakeClient().
+		WithCommittee(1, 0, []phase0.ValidatorIndex{1, 2, 3}).
+		WithCommittee(1, 1, []phase0.ValidatorIndex{4, 5}).
+		WithCommittee(1, 2, []phase0.ValidatorIndex{6, 7, 8, 9}).
+		WithBlock(2, first, second, attestation(1, []uint64{0}, 3), short)
+
+	blocks, err := ListEpochBlocks(context.Background(), client, 0)
+	if err != nil {
+		t.Fatalf("ListEpochBlocks: %v", err)
+	}
+	committees, err := GetBeaconCommitees(context.Background(), client, 0, 0)
+	if err != nil {
+		t.Fatalf("GetBeaconCommitees: %v", err)
+	}
+	reports := BuildBlockReports(0, blocks, committees)
+	FilterReportsByCommittee(reports, committees, 1)
+
+	report := reports[2]
+	if report.ExpectedCommitteeLength != 2 || !report.Mismatch {
+		t.Errorf("got expected committee length %d and mismatch %t, want 2 and true", report.ExpectedCommitteeLength, report.Mismatch)
+	}
+	want := []CommitteeSegment{
+		{Index: 1, Offset: 3, ExpectedLength: 2, ActualLength: 2, AttesterCount: 2},
+		{Index: 1, Offset: 0, ExpectedLength: 2, ActualLength: 2, AttesterCount: 1},
+		{Index: 1, Offset: 3, ExpectedLength: 2, ActualLength: 0, AttesterCount: 0},
+	}
+	if len(report.Attestations) != len(want) {
+		t.Fatalf("got %d attestations, want %d", len(report.Attestations), len(want))
+	}
+	for i, attestation := range report.Attestations {
+		if attestation.Committee == nil || *attestation.Committee != want[i] {
+			t.Errorf("attestation %d: got segment %+v, want %+v", i, attestation.Committee, want[i])
+		}
+	}
+	if reports[3].Mismatch || len(reports[3].Attestations) != 0 {
+		t.Errorf("slot 3: got %+v, want no attestations", reports[3])
+	}
+}
+
+func TestCommitteeParticipationRate(t *testing.T) {
+	committees := map[phase0.CommitteeIndex][]phase0.ValidatorIndex{
+		0: {1, 2, 3},
+		1: {4, 5, 6, 7},
+	}
+	both := attestation(1, []uint64{0, 1}, 7)
+	both.AggregationBits.SetBitAt(0, true)
+	both.AggregationBits.SetBitAt(3, true)
+	only := attestation(1, []uint64{1}, 4)
+	only.AggregationBits.SetBitAt(0, true)
+	only.AggregationBits.SetBitAt(2, true)
+
+	rate, attested, total, err := CommitteeParticipationRate(1, []*electra.Attestation{both, only, attestation(1, []uint64{0}, 3)}, committees, 1)
+	if err != nil {
+		t.Fatalf("CommitteeParticipationRate: %v", err)
+	}
+	if attested != 2 || total != 4 || rate != 0.5 {
+		t.Errorf("got %d of %d (%v), want 2 of 4", attested, total, rate)
+	}
+}
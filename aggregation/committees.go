@@ -0,0 +1,314 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/attestantio/go-eth2-client/api"
+	apiv1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// Committees holds the beacon committees of one or more epochs, keyed by slot
+// and then committee index. Its methods return zero values for slots and
+// committees it does not hold.
+type Committees map[phase0.Slot]map[phase0.CommitteeIndex][]phase0.ValidatorIndex
+
+// Size returns the summed size of every committee at slot.
+func (c Committees) Size(slot phase0.Slot) int {
+	size := 0
+	for _, validators := range c[slot] {
+		size += len(validators)
+	}
+	return size
+}
+
+// CommitteeSize returns the size of committee index at slot.
+func (c Committees) CommitteeSize(slot phase0.Slot, index phase0.CommitteeIndex) int {
+	return len(c[slot][index])
+}
+
+// Validators returns the members of committee index at slot, in committee
+// order.
+func (c Committees) Validators(slot phase0.Slot, index phase0.CommitteeIndex) []phase0.ValidatorIndex {
+	return c[slot][index]
+}
+
+// Has reports whether committee index exists at slot.
+func (c Committees) Has(slot phase0.Slot, index phase0.CommitteeIndex) bool {
+	_, ok := c[slot][index]
+	return ok
+}
+
+// CommitteeFetchError reports the epochs whose committees could not be fetched
+// by GetBeaconCommitees.
+type CommitteeFetchError struct {
+	Epochs []phase0.Epoch
+	Errs   []error
+}
+
+func (e *CommitteeFetchError) Error() string {
+	return fmt.Sprintf("failed fetching committees for epochs %v: %v", e.Epochs, errors.Join(e.Errs...))
+}
+
+func (e *CommitteeFetchError) Unwrap() []error {
+	return e.Errs
+}
+
+// ErrDuplicateCommittee is matched by a DuplicateCommitteeError.
+var ErrDuplicateCommittee = errors.New("duplicate committee")
+
+// DuplicateCommitteeError is a committee reported twice for the same slot and
+// index with different members. The first set is the one kept.
+type DuplicateCommitteeError struct {
+	Slot   phase0.Slot
+	Index  phase0.CommitteeIndex
+	First  []phase0.ValidatorIndex
+	Second []phase0.ValidatorIndex
+}
+
+func (e *DuplicateCommitteeError) Error() string {
+	return fmt.Sprintf("duplicate committee %d at slot %d: %v and %v", e.Index, e.Slot, e.First, e.Second)
+}
+
+func (e *DuplicateCommitteeError) Is(target error) bool {
+	return target == ErrDuplicateCommittee
+}
+
+// ErrStateUnavailable is matched by a StateUnavailableError.
+var ErrStateUnavailable = errors.New("state unavailable")
+
+// StateUnavailableError is the beacon node lacking the state needed for an
+// epoch's committees, usually because it prunes historical states.
+type StateUnavailableError struct {
+	Epoch phase0.Epoch
+	Err   error
+}
+
+func (e *StateUnavailableError) Error() string {
+	return fmt.Sprintf("beacon node has no state for epoch %d, most likely because it has been pruned; use an archive node or a more recent epoch: %v", e.Epoch, e.Err)
+}
+
+func (e *StateUnavailableError) Is(target error) bool {
+	return target == ErrStateUnavailable
+}
+
+func (e *StateUnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// isStateUnavailable reports whether err is the beacon node saying it does not
+// have the requested state. A 404 alone is not enough, as a node also answers
+// it for an unknown epoch or route; the message has to say the state is
+// missing, which clients do whether they report it as a 404 or, for pruned
+// states, a server error.
+func isStateUnavailable(err error) bool {
+	var apiErr *api.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	message := strings.ToLower(string(apiErr.Data))
+	return strings.Contains(message, "state") &&
+		(strings.Contains(message, "pruned") || strings.Contains(message, "historic") || strings.Contains(message, "not available") || strings.Contains(message, "unavailable") || strings.Contains(message, "not found") || strings.Contains(message, "not_found"))
+}
+
+// GetBeaconCommitees fetches the committees for epochs start to end inclusive,
+// up to SetWorkers epochs at a time, retrying transient failures. An epoch
+// that still fails is logged and skipped, and one reporting conflicting
+// members for a committee keeps the first set and records a
+// *DuplicateCommitteeError. An epoch whose state the node no longer has
+// records a *StateUnavailableError. Either way the committees fetched are
+// returned together with a *CommitteeFetchError listing the problem epochs in
+// epoch order.
+func GetBeaconCommitees(ctx context.Context, service BeaconClient, start phase0.Epoch, end phase0.Epoch) (Committees, error) {
+	if start > end {
+		return make(Committees), nil
+	}
+	result := make(Committees)
+	var mu sync.Mutex
+	// errs holds the problem, if any, with each epoch, so that they are
+	// listed in epoch order however the fetches finish.
+	errs := make([]error, end-start+1)
+
+	var g errgroup.Group
+	if workers > 0 {
+		g.SetLimit(workers)
+	}
+	for epoch := start; epoch <= end; epoch++ {
+		g.Go(func() error {
+			// Once ctx is done the remaining epochs are not attempted, but
+			// are still listed as failed.
+			if err := ctx.Err(); err != nil {
+				errs[epoch-start] = contextError(fmt.Sprintf("fetching committees for epoch %d", epoch), err)
+				return nil
+			}
+			committees, err := fetchEpochCommittees(ctx, service, epoch)
+			if err != nil {
+				errs[epoch-start] = err
+			}
+			// Epochs have disjoint slots, so merging cannot clash.
+			mu.Lock()
+			maps.Copy(result, committees)
+			mu.Unlock()
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	var failed *CommitteeFetchError
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if failed == nil {
+			failed = &CommitteeFetchError{}
+		}
+		failed.Epochs = append(failed.Epochs, start+phase0.Epoch(i))
+		failed.Errs = append(failed.Errs, err)
+	}
+	if failed != nil {
+		return result, failed
+	}
+	return result, nil
+}
+
+// GetCommitteesForEpochs fetches the committees for each of epochs, which need
+// not be contiguous, as GetBeaconCommitees does for a range. The problems with
+// every epoch are returned together in a single *CommitteeFetchError.
+func GetCommitteesForEpochs(ctx context.Context, service BeaconClient, epochs []phase0.Epoch) (Committees, error) {
+	result := make(Committees)
+	var failed *CommitteeFetchError
+	for _, epoch := range epochs {
+		committees, err := GetBeaconCommitees(ctx, service, epoch, epoch)
+		maps.Copy(result, committees)
+		var fetchErr *CommitteeFetchError
+		if errors.As(err, &fetchErr) {
+			if failed == nil {
+				failed = &CommitteeFetchError{}
+			}
+			failed.Epochs = append(failed.Epochs, fetchErr.Epochs...)
+			failed.Errs = append(failed.Errs, fetchErr.Errs...)
+		}
+	}
+	if failed != nil {
+		return result, failed
+	}
+	return result, nil
+}
+
+// LogCommitteeEpochs logs at level, for every duty slot the blocks of epoch
+// attest to, the epoch whose committees its lookups resolve to, or that none
+// of those fetched cover it.
+func LogCommitteeEpochs(epoch phase0.Epoch, committees Committees, level zerolog.Level) {
+	first := EpochLowestSlot(epoch)
+	if first > 0 {
+		// The epoch's first block attests to the previous epoch's last slot.
+		first--
+	}
+	for slot := first; slot <= EpochHighestSlot(epoch); slot++ {
+		event := log.WithLevel(level).Uint64("duty_slot", uint64(slot))
+		if len(committees[slot]) == 0 {
+			event.Msg("no fetched committee epoch covers duty slot")
+			continue
+		}
+		event.Uint64("committee_epoch", uint64(SlotEpoch(slot))).Int("committees", len(committees[slot])).Msg("resolved committee epoch for duty slot")
+	}
+}
+
+// fetchEpochCommittees fetches the committees for epoch. If the node reports
+// conflicting members for a committee, the first set is kept and the
+// committees are returned with a *DuplicateCommitteeError. Any slot whose
+// committee indices have gaps is logged. With SetCommitteeDiskCache, epochs
+// on disk are not fetched, and fetched epochs are saved.
+func fetchEpochCommittees(ctx context.Context, service BeaconClient, epoch phase0.Epoch) (Committees, error) {
+	if diskCache != nil {
+		if committees, ok := diskCache.load(epoch); ok {
+			LogCommitteeIndexGaps(CheckCommitteeIndices(committees))
+			return committees, nil
+		}
+	}
+
+	var resp *api.Response[[]*apiv1.BeaconCommittee]
+	err := withRetry(ctx, retryAttempts, retryBaseDelay, func() error {
+		requestCtx, cancel := requestContext(ctx)
+		defer cancel()
+
+		requested := time.Now()
+		defer observeRequest("beacon_committees", requested)
+		var err error
+		resp, err = service.BeaconCommittees(requestCtx, &api.BeaconCommitteesOpts{
+			State: fmt.Sprintf("%d", EpochLowestSlot(epoch)),
+			Epoch: &epoch,
+		})
+		if err != nil {
+			return err
+		}
+		log.Debug().Uint64("epoch", uint64(epoch)).Dur("took", time.Since(requested)).Msg("fetched committees")
+		return nil
+	})
+	if err != nil {
+		if isStateUnavailable(err) {
+			err = &StateUnavailableError{Epoch: epoch, Err: err}
+		}
+		err = contextError(fmt.Sprintf("fetching committees for epoch %d", epoch), err)
+		log.Error().Err(err).Uint64("epoch", uint64(epoch)).Msg("failed fetching committees")
+		return nil, err
+	}
+
+	result, err := committeesFromList(resp.Data)
+	// A missing committee index shows up as exactly the kind of length
+	// undershoot being chased, so surface it as soon as the committees are in.
+	LogCommitteeIndexGaps(CheckCommitteeIndices(result))
+	if err != nil {
+		log.Error().Err(err).Uint64("epoch", uint64(epoch)).Msg("conflicting duplicate committee")
+		return result, err
+	}
+	if diskCache != nil {
+		if err := diskCache.save(epoch, result); err != nil {
+			log.Warn().Err(err).Uint64("epoch", uint64(epoch)).Msg("failed caching committees")
+		}
+	}
+	return result, nil
+}
+
+// committeesFromList arranges committees as the beacon API lists them into
+// Committees. If the list has conflicting members for a committee, the first
+// set is kept and the committees are returned with a *DuplicateCommitteeError.
+func committeesFromList(list []*apiv1.BeaconCommittee) (Committees, error) {
+	result := make(Committees)
+	var duplicate error
+	for _, committee := range list {
+		if _, ok := result[committee.Slot]; !ok {
+			result[committee.Slot] = make(map[phase0.CommitteeIndex][]phase0.ValidatorIndex)
+		}
+		existing, ok := result[committee.Slot][committee.Index]
+		if !ok {
+			result[committee.Slot][committee.Index] = committee.Validators
+			continue
+		}
+		// Each (slot, index) should be reported once. A repeat with the
+		// same members is harmless, but differing members mean either the
+		// node or this tool is wrong about the committee.
+		if !slices.Equal(existing, committee.Validators) && duplicate == nil {
+			duplicate = &DuplicateCommitteeError{
+				Slot:   committee.Slot,
+				Index:  committee.Index,
+				First:  existing,
+				Second: committee.Validators,
+			}
+		}
+	}
+	if duplicate != nil {
+		return result, duplicate
+	}
+	return result, nil
+}
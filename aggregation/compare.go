@@ -0,0 +1,190 @@
+package aggregation
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+)
+
+// AttestationDiff is a field of one attestation that two beacon nodes report
+// differently. A and B hold each node's value, or "absent" if its block has no
+// attestation at Position.
+type AttestationDiff struct {
+	// Position is the attestation's index in the block.
+	Position int
+	Field    string
+	A        string
+	B        string
+}
+
+// SlotDiff is a slot whose block two beacon nodes disagree on. Block says how
+// the blocks differ, if that is known, and Attestations which of their
+// attestations do; the latter is empty when only one node has a block.
+//
+// FetchFailed marks a slot that could not be compared at all because fetching
+// its block failed on at least one node, as Block then explains. It is not a
+// disagreement.
+type SlotDiff struct {
+	Slot         phase0.Slot
+	Block        string
+	Attestations []AttestationDiff
+	FetchFailed  bool
+}
+
+// CompareEpochBlocks diffs the blocks two beacon nodes returned for epoch and
+// returns the slots where they disagree, in slot order. Attestations are
+// compared position by position on their data, committee bits, aggregation
+// bits and attester count. failedA and failedB hold the error for each slot
+// whose block could not be fetched from either node, as
+// ListEpochBlocksWithFailures returns them; those slots are reported with
+// FetchFailed set rather than as missed on one node.
+func CompareEpochBlocks(epoch phase0.Epoch, a map[phase0.Slot]*spec.VersionedSignedBeaconBlock, b map[phase0.Slot]*spec.VersionedSignedBeaconBlock, failedA map[phase0.Slot]error, failedB map[phase0.Slot]error) []SlotDiff {
+	var diffs []SlotDiff
+	for slot := EpochLowestSlot(epoch); slot <= EpochHighestSlot(epoch); slot++ {
+		errA, errB := failedA[slot], failedB[slot]
+		if errA != nil || errB != nil {
+			diffs = append(diffs, SlotDiff{Slot: slot, Block: fetchFailure(errA, errB), FetchFailed: true})
+			continue
+		}
+		if diff, ok := compareBlocks(slot, a[slot], b[slot]); ok {
+			diffs = append(diffs, diff)
+		}
+	}
+	return diffs
+}
+
+// fetchFailure describes the failure to fetch a slot's block from either or
+// both nodes.
+func fetchFailure(errA error, errB error) string {
+	switch {
+	case errB == nil:
+		return fmt.Sprintf("fetch failed on a: %v", errA)
+	case errA == nil:
+		return fmt.Sprintf("fetch failed on b: %v", errB)
+	default:
+		return fmt.Sprintf("fetch failed on both: a: %v, b: %v", errA, errB)
+	}
+}
+
+// compareBlocks diffs two nodes' blocks for slot, either of which may be nil
+// for a missed slot. It returns false if they agree.
+func compareBlocks(slot phase0.Slot, a *spec.VersionedSignedBeaconBlock, b *spec.VersionedSignedBeaconBlock) (SlotDiff, bool) {
+	diff := SlotDiff{Slot: slot}
+	switch {
+	case a == nil && b == nil:
+		return diff, false
+	case a == nil:
+		diff.Block = "missed on a only"
+		return diff, true
+	case b == nil:
+		diff.Block = "missed on b only"
+		return diff, true
+	}
+
+	// Equal roots mean equal blocks. Without roots, such as for a block a
+	// node returned incomplete, the attestations decide.
+	rootA, errA := a.Root()
+	rootB, errB := b.Root()
+	if errA == nil && errB == nil {
+		if rootA == rootB {
+			return diff, false
+		}
+		diff.Block = fmt.Sprintf("block roots differ: a=%#x b=%#x", rootA, rootB)
+	}
+
+	attestationsA, errA := a.Attestations()
+	attestationsB, errB := b.Attestations()
+	if errA != nil || errB != nil {
+		diff.Block = fmt.Sprintf("failed reading attestations: a: %v, b: %v", errA, errB)
+		return diff, true
+	}
+	for i := range max(len(attestationsA), len(attestationsB)) {
+		fieldsA, fieldsB := attestationFields(attestationsA, i), attestationFields(attestationsB, i)
+		for _, field := range attestationFieldNames {
+			if fieldsA[field] != fieldsB[field] {
+				diff.Attestations = append(diff.Attestations, AttestationDiff{
+					Position: i,
+					Field:    field,
+					A:        fieldsA[field],
+					B:        fieldsB[field],
+				})
+			}
+		}
+	}
+	return diff, diff.Block != "" || len(diff.Attestations) > 0
+}
+
+// attestationFieldNames are the attestation fields compared, in the order
+// differences are listed.
+var attestationFieldNames = []string{"data", "committee_bits", "aggregation_bits", "attester_count"}
+
+// attestationFields renders the compared fields of attestations[i], or marks
+// each as absent if there is no such attestation.
+func attestationFields(attestations []*spec.VersionedAttestation, i int) map[string]string {
+	fields := make(map[string]string, len(attestationFieldNames))
+	if i >= len(attestations) {
+		for _, field := range attestationFieldNames {
+			fields[field] = "absent"
+		}
+		return fields
+	}
+
+	attestation := attestations[i]
+	if data, err := attestation.Data(); err != nil {
+		fields["data"] = "error: " + err.Error()
+	} else {
+		fields["data"] = strings.TrimSpace(data.String())
+	}
+	if committees, err := AttestationCommitteeIndices(attestation); err != nil {
+		fields["committee_bits"] = "error: " + err.Error()
+	} else {
+		fields["committee_bits"] = fmt.Sprint(committees)
+	}
+	if bits, err := attestation.AggregationBits(); err != nil {
+		fields["aggregation_bits"] = "error: " + err.Error()
+		fields["attester_count"] = "error: " + err.Error()
+	} else {
+		fields["aggregation_bits"] = FormatBitlist(bits)
+		fields["attester_count"] = strconv.FormatUint(bits.Count(), 10)
+	}
+	return fields
+}
+
+// WriteSlotDiffs writes diffs to w, naming the two nodes nameA and nameB, or a
+// single line saying they agree if there are none. Slots that could not be
+// compared are counted apart from those the nodes disagree on.
+func WriteSlotDiffs(w io.Writer, epoch phase0.Epoch, nameA string, nameB string, diffs []SlotDiff) error {
+	if _, err := fmt.Fprintf(w, "a: %s\nb: %s\n", nameA, nameB); err != nil {
+		return err
+	}
+	if len(diffs) == 0 {
+		_, err := fmt.Fprintf(w, "epoch %d: nodes agree on every block\n", epoch)
+		return err
+	}
+	failed := 0
+	for _, diff := range diffs {
+		if diff.FetchFailed {
+			failed++
+		}
+		if diff.Block != "" {
+			if _, err := fmt.Fprintf(w, "slot %d: %s\n", diff.Slot, diff.Block); err != nil {
+				return err
+			}
+		}
+		for _, attestation := range diff.Attestations {
+			if _, err := fmt.Fprintf(w, "slot %d: attestation %d: %s differs\n  a: %s\n  b: %s\n", diff.Slot, attestation.Position, attestation.Field, attestation.A, attestation.B); err != nil {
+				return err
+			}
+		}
+	}
+	if failed > 0 {
+		_, err := fmt.Fprintf(w, "epoch %d: nodes disagree on %d of %d slots, and %d could not be compared\n", epoch, len(diffs)-failed, slotsPerEpoch, failed)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "epoch %d: nodes disagree on %d of %d slots\n", epoch, len(diffs), slotsPerEpoch)
+	return err
+}
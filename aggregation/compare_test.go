@@ -0,0 +1,85 @@
+package aggregation
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"repro/internal/testutil"
+)
+
+func TestCompareEpochBlocks(t *testing.T) {
+	a := testutil.NewFakeClient().
+		WithBlock(1, attestation(0, []uint64{0}, 3)).
+		WithBlock(2, attestation(1, []uint64{0, 1}, 5)).
+		WithBlock(3)
+	b := testutil.NewFakeClient().
+		WithBlock(1, attestation(0, []uint64{0}, 3)).
+		WithBlock(2, attestation(1, []uint64{0, 1}, 4))
+
+	ctx := context.Background()
+	blocksA, err := fetchBlocks(ctx, a, []phase0.Slot{1, 2, 3}, DEFAULT_BLOCK_WORKERS)
+	if err != nil {
+		t.Fatalf("fetchBlocks: %v", err)
+	}
+	blocksB, err := fetchBlocks(ctx, b, []phase0.Slot{1, 2, 3}, DEFAULT_BLOCK_WORKERS)
+	if err != nil {
+		t.Fatalf("fetchBlocks: %v", err)
+	}
+
+	diffs := CompareEpochBlocks(0, blocksA, blocksB, nil, nil)
+	if len(diffs) != 2 {
+		t.Fatalf("got %d differing slots, want 2: %+v", len(diffs), diffs)
+	}
+	if diffs[0].Slot != 2 || len(diffs[0].Attestations) != 1 || diffs[0].Attestations[0].Field != "aggregation_bits" {
+		t.Errorf("slot 2: got %+v, want only the aggregation bits to differ", diffs[0])
+	}
+	if diffs[1].Slot != 3 || diffs[1].Block != "missed on b only" {
+		t.Errorf("slot 3: got %+v, want missed on b only", diffs[1])
+	}
+}
+
+func TestCompareEpochBlocksFetchFailure(t *testing.T) {
+	defer func(previous uint64) { slotsPerEpoch = previous }(slotsPerEpoch)
+	slotsPerEpoch = 4
+	defer SetRetry(retryAttempts, retryBaseDelay)
+	SetRetry(2, 0)
+	logger := log.Logger
+	log.Logger = zerolog.Nop()
+	defer func() { log.Logger = logger }()
+
+	a := testutil.NewFakeClient().WithSlotsPerEpoch(4).WithBlock(1).WithBlock(2)
+	b := testutil.NewFakeClient().WithSlotsPerEpoch(4).WithBlock(1).WithBlock(2).WithBlockFailure(2, http.StatusInternalServerError)
+
+	ctx := context.Background()
+	blocksA, failedA, err := ListEpochBlocksWithFailures(ctx, a, 0, DEFAULT_BLOCK_WORKERS)
+	if err != nil {
+		t.Fatalf("ListEpochBlocksWithFailures: %v", err)
+	}
+	blocksB, failedB, err := ListEpochBlocksWithFailures(ctx, b, 0, DEFAULT_BLOCK_WORKERS)
+	if err != nil {
+		t.Fatalf("ListEpochBlocksWithFailures: %v", err)
+	}
+	if len(failedA) != 0 || failedB[2] == nil {
+		t.Fatalf("got failures %v and %v, want slot 2 failed on b only", failedA, failedB)
+	}
+
+	diffs := CompareEpochBlocks(0, blocksA, blocksB, failedA, failedB)
+	if len(diffs) != 1 || !diffs[0].FetchFailed || !strings.HasPrefix(diffs[0].Block, "fetch failed on b") {
+		t.Fatalf("got %+v, want slot 2 reported as a failed fetch on b", diffs)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSlotDiffs(&buf, 0, "a", "b", diffs); err != nil {
+		t.Fatalf("WriteSlotDiffs: %v", err)
+	}
+	if !strings.Contains(buf.String(), "disagree on 0 of 4 slots, and 1 could not be compared") {
+		t.Errorf("the failed fetch was counted as a disagreement:\n%s", buf.String())
+	}
+}
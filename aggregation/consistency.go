@@ -0,0 +1,162 @@
+package aggregation
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog/log"
+)
+
+// CommitteeAnomaly is a slot whose committees do not have the shape the spec
+// gives them, which would point at the committees rather than the
+// attestations when chasing a mismatch.
+type CommitteeAnomaly struct {
+	Slot    phase0.Slot
+	Problem string
+}
+
+// ExpectedCommitteesPerSlot is get_committee_count_per_slot: the number of
+// committees each slot of an epoch with activeValidators active validators
+// has.
+func ExpectedCommitteesPerSlot(activeValidators uint64, targetCommitteeSize uint64) uint64 {
+	return max(1, min(specMaxCommitteesPerSlot, activeValidators/slotsPerEpoch/targetCommitteeSize))
+}
+
+// CheckCommitteeConsistency compares the structure of epoch's committees with
+// what the spec derives from the number of active validators, which is taken
+// to be the number of distinct committee members over the epoch, and
+// TARGET_COMMITTEE_SIZE and MAX_COMMITTEES_PER_SLOT as loaded by LoadSpec.
+// Every slot should have the same number of committees, indexed from 0, and as
+// the validators are shuffled evenly across them the committee sizes differ by
+// at most one, which bounds each slot's total. No validator may sit in more than
+// one committee in the epoch. If some slot has no committees at all, such as
+// when their fetch failed, only that is reported.
+func CheckCommitteeConsistency(epoch phase0.Epoch, committees Committees) []CommitteeAnomaly {
+	var anomalies []CommitteeAnomaly
+	for slot := EpochLowestSlot(epoch); slot <= EpochHighestSlot(epoch); slot++ {
+		if len(committees[slot]) == 0 {
+			anomalies = append(anomalies, CommitteeAnomaly{Slot: slot, Problem: "no committees"})
+		}
+	}
+	if len(anomalies) > 0 {
+		return anomalies
+	}
+
+	seen := make(map[phase0.ValidatorIndex]phase0.Slot)
+	duplicates := make(map[phase0.Slot]phase0.ValidatorIndex)
+	for slot := EpochLowestSlot(epoch); slot <= EpochHighestSlot(epoch); slot++ {
+		for _, index := range slices.Sorted(maps.Keys(committees[slot])) {
+			for _, validator := range committees.Validators(slot, index) {
+				if _, ok := seen[validator]; ok {
+					if _, reported := duplicates[slot]; !reported {
+						duplicates[slot] = validator
+					}
+					continue
+				}
+				seen[validator] = slot
+			}
+		}
+	}
+
+	active := uint64(len(seen))
+	perSlot := ExpectedCommitteesPerSlot(active, targetCommitteeSize)
+	committeeCount := perSlot * slotsPerEpoch
+	// Committee sizes are the floor or ceiling of this.
+	smallest, largest := active/committeeCount, (active+committeeCount-1)/committeeCount
+	for slot := EpochLowestSlot(epoch); slot <= EpochHighestSlot(epoch); slot++ {
+		if got := uint64(len(committees[slot])); got != perSlot {
+			anomalies = append(anomalies, CommitteeAnomaly{
+				Slot:    slot,
+				Problem: fmt.Sprintf("%d committees, expected %d for %d active validators", got, perSlot, active),
+			})
+		}
+		for index := range phase0.CommitteeIndex(len(committees[slot])) {
+			if !committees.Has(slot, index) {
+				anomalies = append(anomalies, CommitteeAnomaly{
+					Slot:    slot,
+					Problem: fmt.Sprintf("committee indices are not contiguous from 0: %d is missing", index),
+				})
+				break
+			}
+		}
+		total := uint64(committees.Size(slot))
+		if total < perSlot*smallest || total > perSlot*largest {
+			anomalies = append(anomalies, CommitteeAnomaly{
+				Slot:    slot,
+				Problem: fmt.Sprintf("%d members, expected between %d and %d", total, perSlot*smallest, perSlot*largest),
+			})
+		}
+		if validator, ok := duplicates[slot]; ok {
+			anomalies = append(anomalies, CommitteeAnomaly{
+				Slot:    slot,
+				Problem: fmt.Sprintf("validator %d is also in a committee at slot %d", validator, seen[validator]),
+			})
+		}
+	}
+	return anomalies
+}
+
+// CommitteeIndexGap is a slot whose committee indices do not run from 0
+// without gaps. An attestation whose committee bits name a missing committee
+// cannot be decoded, and if the gap is in the committees fetched rather than
+// on chain, the lengths computed for attestations spanning it fall short by
+// the missing committees' members.
+type CommitteeIndexGap struct {
+	Slot    phase0.Slot
+	Missing []phase0.CommitteeIndex
+	// Committees and Members count the committees present and their members.
+	Committees int
+	Members    int
+}
+
+// EstimatedShortfall is the number of members the missing committees would
+// add to the slot's total, assuming they are of the average size of those
+// present.
+func (g CommitteeIndexGap) EstimatedShortfall() int {
+	if g.Committees == 0 {
+		return 0
+	}
+	return len(g.Missing) * g.Members / g.Committees
+}
+
+// CheckCommitteeIndices finds every slot in committees whose indices are not
+// contiguous from 0. Only gaps below a slot's highest index can be seen;
+// committees missing from the end are caught by CheckCommitteeConsistency,
+// which knows how many a slot should have.
+func CheckCommitteeIndices(committees Committees) []CommitteeIndexGap {
+	var gaps []CommitteeIndexGap
+	for _, slot := range slices.Sorted(maps.Keys(committees)) {
+		indices := slices.Sorted(maps.Keys(committees[slot]))
+		if len(indices) == 0 || int(indices[len(indices)-1]) == len(indices)-1 {
+			continue
+		}
+		gap := CommitteeIndexGap{Slot: slot, Committees: len(indices), Members: committees.Size(slot)}
+		for index := range indices[len(indices)-1] {
+			if !committees.Has(slot, index) {
+				gap.Missing = append(gap.Missing, index)
+			}
+		}
+		gaps = append(gaps, gap)
+	}
+	return gaps
+}
+
+// LogCommitteeIndexGaps writes each gap to the warning log.
+func LogCommitteeIndexGaps(gaps []CommitteeIndexGap) {
+	for _, gap := range gaps {
+		missing := make([]int, 0, len(gap.Missing))
+		for _, index := range gap.Missing {
+			missing = append(missing, int(index))
+		}
+		log.Warn().Uint64("slot", uint64(gap.Slot)).Str("missing", formatIndices(missing)).Int("committees", gap.Committees).Int("members", gap.Members).Int("estimated_shortfall", gap.EstimatedShortfall()).Msg("committee indices are not contiguous from 0")
+	}
+}
+
+// LogCommitteeAnomalies writes each anomaly to the warning log.
+func LogCommitteeAnomalies(anomalies []CommitteeAnomaly) {
+	for _, anomaly := range anomalies {
+		log.Warn().Uint64("slot", uint64(anomaly.Slot)).Msgf("unexpected committees: %s", anomaly.Problem)
+	}
+}
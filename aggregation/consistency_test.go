@@ -0,0 +1,124 @@
+package aggregation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+)
+
+// consistentCommittees lays out 36 validators over an epoch of 4 slots as the
+// spec would with a target committee size of 2: 4 committees per slot of 2 or
+// 3 members each.
+func consistentCommittees() Committees {
+	committees := make(Committees)
+	validator := phase0.ValidatorIndex(0)
+	for slot := range phase0.Slot(4) {
+		committees[slot] = make(map[phase0.CommitteeIndex][]phase0.ValidatorIndex)
+		for index, size := range []int{3, 2, 2, 2} {
+			for range size {
+				committees[slot][phase0.CommitteeIndex(index)] = append(committees[slot][phase0.CommitteeIndex(index)], validator)
+				validator++
+			}
+		}
+	}
+	return committees
+}
+
+func TestCheckCommitteeConsistency(t *testing.T) {
+	defer func(previous uint64) { slotsPerEpoch = previous }(slotsPerEpoch)
+	defer func(previous uint64) { targetCommitteeSize = previous }(targetCommitteeSize)
+	slotsPerEpoch = 4
+	targetCommitteeSize = 2
+
+	if anomalies := CheckCommitteeConsistency(0, consistentCommittees()); len(anomalies) != 0 {
+		t.Errorf("consistent committees: got anomalies %+v", anomalies)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(Committees)
+		slot   phase0.Slot
+		want   string
+	}{
+		{
+			name:   "missing slot",
+			modify: func(c Committees) { delete(c, 1) },
+			slot:   1,
+			want:   "no committees",
+		},
+		{
+			name:   "missing committee",
+			modify: func(c Committees) { delete(c[2], 3) },
+			slot:   2,
+			want:   "3 committees, expected 4",
+		},
+		{
+			name: "index gap",
+			modify: func(c Committees) {
+				c[3][5] = c[3][3]
+				delete(c[3], 3)
+			},
+			slot: 3,
+			want: "3 is missing",
+		},
+		{
+			name: "unbalanced slots",
+			modify: func(c Committees) {
+				c[0][1] = append(c[0][1], c[1][0][1:]...)
+				c[1][0] = c[1][0][:1]
+			},
+			slot: 1,
+			want: "7 members, expected between 8 and 12",
+		},
+		{
+			name:   "validator in two committees",
+			modify: func(c Committees) { c[2][0][0] = 0 },
+			slot:   2,
+			want:   "validator 0 is also in a committee at slot 0",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			committees := consistentCommittees()
+			test.modify(committees)
+			anomalies := CheckCommitteeConsistency(0, committees)
+			found := false
+			for _, anomaly := range anomalies {
+				if anomaly.Slot == test.slot && strings.Contains(anomaly.Problem, test.want) {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("got anomalies %+v, want %q at slot %d", anomalies, test.want, test.slot)
+			}
+		})
+	}
+}
+
+func TestCheckCommitteeIndices(t *testing.T) {
+	committees := consistentCommittees()
+	if gaps := CheckCommitteeIndices(committees); len(gaps) != 0 {
+		t.Errorf("consistent committees: got gaps %+v", gaps)
+	}
+
+	// Slot 1 loses committees 0 and 2, slot 3 loses its last one, which
+	// leaves no gap below the highest index.
+	delete(committees[1], 0)
+	delete(committees[1], 2)
+	delete(committees[3], 3)
+	gaps := CheckCommitteeIndices(committees)
+	if len(gaps) != 1 {
+		t.Fatalf("got gaps %+v, want one at slot 1", gaps)
+	}
+	gap := gaps[0]
+	if gap.Slot != 1 || len(gap.Missing) != 2 || gap.Missing[0] != 0 || gap.Missing[1] != 2 {
+		t.Errorf("got gap %+v, want committees 0 and 2 missing at slot 1", gap)
+	}
+	if gap.Committees != 2 || gap.Members != 4 {
+		t.Errorf("got %d committees of %d members, want 2 of 4", gap.Committees, gap.Members)
+	}
+	if got := gap.EstimatedShortfall(); got != 4 {
+		t.Errorf("EstimatedShortfall: got %d, want 4", got)
+	}
+}
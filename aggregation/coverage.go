@@ -0,0 +1,109 @@
+package aggregation
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+)
+
+// CoverageStep is one aggregate for a duty slot and the attester coverage of
+// the slot once it is OR-ed into the aggregates before it.
+type CoverageStep struct {
+	InclusionSlot    phase0.Slot             `json:"inclusion_slot"`
+	CommitteeIndices []phase0.CommitteeIndex `json:"committee_indices"`
+	// Attesters is how many validators the aggregate itself carries, and New
+	// how many of them no earlier aggregate did.
+	Attesters int `json:"attesters"`
+	New       int `json:"new"`
+	// Covered is the running count of distinct attesters for the slot.
+	Covered int `json:"covered"`
+	// Error is set if the aggregate could not be decoded against the
+	// committees, in which case it adds no coverage.
+	Error string `json:"error,omitempty"`
+}
+
+// Redundant reports whether the aggregate added no attester that earlier
+// aggregates had not already covered.
+func (s CoverageStep) Redundant() bool {
+	return s.Error == "" && s.New == 0
+}
+
+// AggregateCoverage walks the aggregates for dutySlot in the order given,
+// normally inclusion order as returned by GatherAttestationsByDutySlot, and
+// records how each one grows the set of distinct attesters. Attestations for
+// other slots are ignored.
+func AggregateCoverage(dutySlot phase0.Slot, attestations []IncludedAttestation, committees Committees) []CoverageStep {
+	covered := make(map[phase0.ValidatorIndex]struct{})
+	var steps []CoverageStep
+	for _, included := range attestations {
+		attestation := included.Attestation
+		if attestation.Data.Slot != dutySlot {
+			continue
+		}
+		step := CoverageStep{InclusionSlot: included.InclusionSlot}
+		for _, bit := range attestation.CommitteeBits.BitIndices() {
+			step.CommitteeIndices = append(step.CommitteeIndices, phase0.CommitteeIndex(bit))
+		}
+
+		validators, err := AttestingValidators(attestation, committees[dutySlot])
+		if err != nil {
+			step.Error = err.Error()
+		}
+		step.Attesters = len(validators)
+		for _, validator := range validators {
+			if _, ok := covered[validator]; !ok {
+				covered[validator] = struct{}{}
+				step.New++
+			}
+		}
+		step.Covered = len(covered)
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// WriteCoverageReport writes, for every duty slot of epoch, how many
+// aggregates blocks include for it and how the attester coverage of the
+// slot's committees builds up as each is added, marking redundant ones.
+// Attestations for the end of epoch are mostly included in the following
+// epoch, so blocks should cover both.
+func WriteCoverageReport(w io.Writer, epoch phase0.Epoch, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, committees Committees) error {
+	byDutySlot := GatherAttestationsByDutySlot(blocks)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "slot\tblock\tcommittees\tattesters\tnew\tcovered\tcoverage\t\t")
+	for slot := EpochLowestSlot(epoch); slot <= EpochHighestSlot(epoch); slot++ {
+		size := committees.Size(slot)
+		steps := AggregateCoverage(slot, byDutySlot[slot], committees)
+		if len(steps) == 0 {
+			fmt.Fprintf(tw, "%d\t-\t-\t-\t-\t0\t%s\tno aggregates\t\n", slot, coverageRatio(0, size))
+			continue
+		}
+		for _, step := range steps {
+			flag := ""
+			switch {
+			case step.Error != "":
+				flag = "error: " + step.Error
+			case step.Redundant():
+				flag = "redundant"
+			}
+			indices := make([]int, 0, len(step.CommitteeIndices))
+			for _, index := range step.CommitteeIndices {
+				indices = append(indices, int(index))
+			}
+			fmt.Fprintf(tw, "%d\t%d\t%s\t%d\t%d\t%d\t%s\t%s\t\n", slot, step.InclusionSlot, formatIndices(indices), step.Attesters, step.New, step.Covered, coverageRatio(step.Covered, size), flag)
+		}
+	}
+	return tw.Flush()
+}
+
+// coverageRatio formats covered attesters as a percentage of the slot's size,
+// or "-" if its committees are unknown.
+func coverageRatio(covered int, size int) string {
+	if size == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(covered)/float64(size)*100)
+}
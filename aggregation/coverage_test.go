@@ -0,0 +1,74 @@
+package aggregation
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+)
+
+func TestAggregateCoverage(t *testing.T) {
+	committees := Committees{1: {
+		0: {1, 2, 3},
+		1: {4, 5},
+	}}
+	first := attestation(1, []uint64{0}, 3)
+	first.AggregationBits.SetBitAt(0, true)
+	first.AggregationBits.SetBitAt(1, true)
+	both := attestation(1, []uint64{0, 1}, 5)
+	both.AggregationBits.SetBitAt(1, true)
+	both.AggregationBits.SetBitAt(2, true)
+	both.AggregationBits.SetBitAt(4, true)
+	repeat := attestation(1, []uint64{0}, 3)
+	repeat.AggregationBits.SetBitAt(2, true)
+
+	steps := AggregateCoverage(1, []IncludedAttestation{
+		{Attestation: first, InclusionSlot: 2},
+		{Attestation: attestation(2, []uint64{0}, 3), InclusionSlot: 3},
+		{Attestation: both, InclusionSlot: 3},
+		{Attestation: repeat, InclusionSlot: 4},
+		{Attestation: attestation(1, []uint64{1}, 4), InclusionSlot: 5},
+	}, committees)
+
+	want := []struct {
+		attesters, added, covered int
+		redundant, failed         bool
+	}{
+		{attesters: 2, added: 2, covered: 2},
+		{attesters: 3, added: 2, covered: 4},
+		{attesters: 1, added: 0, covered: 4, redundant: true},
+		{covered: 4, failed: true},
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("got %d steps, want %d: %+v", len(steps), len(want), steps)
+	}
+	for i, step := range steps {
+		if step.Attesters != want[i].attesters || step.New != want[i].added || step.Covered != want[i].covered || step.Redundant() != want[i].redundant || (step.Error != "") != want[i].failed {
+			t.Errorf("step %d: got %+v, want %+v", i, step, want[i])
+		}
+	}
+	if steps[1].InclusionSlot != 3 || len(steps[1].CommitteeIndices) != 2 {
+		t.Errorf("step 1: got inclusion slot %d and committees %v", steps[1].InclusionSlot, steps[1].CommitteeIndices)
+	}
+}
+
+func TestWriteCoverageReport(t *testing.T) {
+	defer func(previous uint64) { slotsPerEpoch = previous }(slotsPerEpoch)
+	slotsPerEpoch = 2
+	first := attestation(0, []uint64{0}, 2)
+	first.AggregationBits.SetBitAt(0, true)
+	blocks := map[phase0.Slot]*spec.VersionedSignedBeaconBlock{
+		1: {Version: spec.DataVersionElectra, Electra: fullBlock(1, first)},
+	}
+
+	var out bytes.Buffer
+	if err := WriteCoverageReport(&out, 0, blocks, Committees{0: {0: {1, 2}}}); err != nil {
+		t.Fatalf("WriteCoverageReport: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "50.0%") || !strings.Contains(lines[2], "no aggregates") {
+		t.Errorf("got report:\n%s", out.String())
+	}
+}
@@ -0,0 +1,133 @@
+package aggregation
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog/log"
+)
+
+// CommitteeDiskCache keeps the committees of finalized epochs on disk across
+// runs, one gob file per epoch, since they never change once finalized and
+// are slow to fetch for historical epochs. Each file records the genesis
+// validators root of the network it came from, and is only used for that
+// network.
+type CommitteeDiskCache struct {
+	dir  string
+	root phase0.Root
+	// finalized is the latest epoch whose committees may be saved.
+	finalized phase0.Epoch
+	// refresh ignores what is on disk, replacing it as epochs are fetched.
+	refresh bool
+}
+
+// committeeCacheFile is the content of a cache file.
+type committeeCacheFile struct {
+	GenesisValidatorsRoot phase0.Root
+	Epoch                 phase0.Epoch
+	Committees            Committees
+}
+
+// diskCache, if set by SetCommitteeDiskCache, is consulted before fetching
+// an epoch's committees, and filled in after.
+var diskCache *CommitteeDiskCache
+
+// OpenCommitteeDiskCache returns a cache in dir, creating it if needed, for
+// the network with genesis validators root root. Epochs up to finalized are
+// saved as they are fetched. With refresh, files already in dir are not read
+// but overwritten.
+//
+// The network a cache directory was first used for is recorded in it, and a
+// *NetworkMismatchError is returned if that is not root's, refresh or not.
+func OpenCommitteeDiskCache(dir string, root phase0.Root, finalized phase0.Epoch, refresh bool) (*CommitteeDiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed creating cache directory: %w", err)
+	}
+	path := filepath.Join(dir, "network")
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("%#x\n", root)), 0o644); err != nil {
+			return nil, fmt.Errorf("failed recording the cache's network: %w", err)
+		}
+	case err != nil:
+		return nil, err
+	default:
+		recorded, err := parseRoot(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid network in %s: %w", path, err)
+		}
+		if recorded != root {
+			return nil, &NetworkMismatchError{What: "cache", Recorded: recorded, Current: root}
+		}
+	}
+	return &CommitteeDiskCache{dir: dir, root: root, finalized: finalized, refresh: refresh}, nil
+}
+
+// SetCommitteeDiskCache makes every committee fetch go through cache. A nil
+// cache turns the disk cache off.
+func SetCommitteeDiskCache(cache *CommitteeDiskCache) {
+	diskCache = cache
+}
+
+// Path is the file holding epoch's committees.
+func (c *CommitteeDiskCache) Path(epoch phase0.Epoch) string {
+	return filepath.Join(c.dir, fmt.Sprintf("epoch-%d.gob", epoch))
+}
+
+// load returns epoch's committees if they are on disk for c's network. A
+// file that cannot be read is logged and treated as missing.
+func (c *CommitteeDiskCache) load(epoch phase0.Epoch) (Committees, bool) {
+	if c.refresh {
+		return nil, false
+	}
+	f, err := os.Open(c.Path(epoch))
+	if os.IsNotExist(err) {
+		return nil, false
+	}
+	if err != nil {
+		log.Warn().Err(err).Uint64("epoch", uint64(epoch)).Msg("failed reading cached committees")
+		return nil, false
+	}
+	defer f.Close()
+
+	var cached committeeCacheFile
+	if err := gob.NewDecoder(f).Decode(&cached); err != nil {
+		log.Warn().Err(err).Str("path", c.Path(epoch)).Msg("ignoring corrupt committee cache file")
+		return nil, false
+	}
+	if cached.GenesisValidatorsRoot != c.root || cached.Epoch != epoch {
+		log.Warn().Str("path", c.Path(epoch)).Msg("ignoring committee cache file from another network")
+		return nil, false
+	}
+	log.Debug().Uint64("epoch", uint64(epoch)).Msg("loaded committees from cache")
+	return cached.Committees, true
+}
+
+// save writes epoch's committees to disk if epoch is finalized and the node
+// returned any, so that an empty answer is asked again next time. The file is
+// written under a temporary name and renamed, so an interrupted run never
+// leaves a truncated one behind.
+func (c *CommitteeDiskCache) save(epoch phase0.Epoch, committees Committees) error {
+	if epoch > c.finalized || len(committees) == 0 {
+		return nil
+	}
+	f, err := os.CreateTemp(c.dir, fmt.Sprintf("epoch-%d.gob.*", epoch))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	err = gob.NewEncoder(f).Encode(committeeCacheFile{GenesisValidatorsRoot: c.root, Epoch: epoch, Committees: committees})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.Path(epoch))
+}
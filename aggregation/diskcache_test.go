@@ -0,0 +1,66 @@
+package aggregation
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"repro/internal/testutil"
+)
+
+func TestCommitteeDiskCache(t *testing.T) {
+	defer func(previous uint64) { slotsPerEpoch = previous }(slotsPerEpoch)
+	slotsPerEpoch = 2
+	defer SetCommitteeDiskCache(nil)
+	logger := log.Logger
+	log.Logger = zerolog.Nop()
+	defer func() { log.Logger = logger }()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	mainnet := phase0.Root{1}
+	client := testutil.NewFakeClient().
+		WithSlotsPerEpoch(2).
+		WithCommittee(0, 0, []phase0.ValidatorIndex{1, 2}).
+		WithCommittee(2, 0, []phase0.ValidatorIndex{3, 4})
+
+	cache, err := OpenCommitteeDiskCache(dir, mainnet, 0, false)
+	if err != nil {
+		t.Fatalf("OpenCommitteeDiskCache: %v", err)
+	}
+	SetCommitteeDiskCache(cache)
+	if _, err := GetBeaconCommitees(ctx, client, 0, 1); err != nil {
+		t.Fatalf("GetBeaconCommitees: %v", err)
+	}
+	if _, err := os.Stat(cache.Path(0)); err != nil {
+		t.Errorf("finalized epoch 0 was not cached: %v", err)
+	}
+	if _, err := os.Stat(cache.Path(1)); !os.IsNotExist(err) {
+		t.Errorf("epoch 1 is not finalized but was cached: %v", err)
+	}
+
+	// A node without the committees only has the cache to go on.
+	empty := testutil.NewFakeClient().WithSlotsPerEpoch(2)
+	committees, err := GetBeaconCommitees(ctx, empty, 0, 0)
+	if err != nil {
+		t.Fatalf("GetBeaconCommitees from cache: %v", err)
+	}
+	if got := committees.Validators(0, 0); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("got cached committee %v, want [1 2]", got)
+	}
+
+	for name, other := range map[string]*CommitteeDiskCache{
+		"another network": {dir: dir, root: phase0.Root{2}},
+		"refresh":         {dir: dir, root: mainnet, refresh: true},
+	} {
+		SetCommitteeDiskCache(other)
+		committees, _ := GetBeaconCommitees(ctx, empty, 0, 0)
+		if committees.Has(0, 0) {
+			t.Errorf("%s: used the cached committees", name)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+// Package aggregation checks the aggregation bits of attestations included in
+// beacon blocks against the beacon committees they claim to cover.
+//
+// It fetches an epoch's blocks and committees from a beacon node through
+// BeaconClient, reports attestations whose aggregation bits do not match the
+// combined length of their committees, and can process whole epoch ranges
+// with optional persistence and checkpointing. The repro command is a thin
+// CLI over this package.
+package aggregation
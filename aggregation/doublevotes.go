@@ -0,0 +1,92 @@
+package aggregation
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog/log"
+)
+
+// DoubleVote is a validator whose bit is set in two attestations for the same
+// slot that carry different attestation data.
+type DoubleVote struct {
+	Validator phase0.ValidatorIndex `json:"validator"`
+	Slot      phase0.Slot           `json:"slot"`
+	First     phase0.Root           `json:"first_data_root"`
+	Second    phase0.Root           `json:"second_data_root"`
+}
+
+// FindDoubleVotes reports every validator that attested to conflicting data
+// across attestations. Each conflicting data root is reported once per
+// validator. This is not full slashing detection: only attestations for the
+// same slot are compared.
+func FindDoubleVotes(attestations []*electra.Attestation, committees map[phase0.CommitteeIndex][]phase0.ValidatorIndex) ([]DoubleVote, error) {
+	type duty struct {
+		validator phase0.ValidatorIndex
+		slot      phase0.Slot
+	}
+	type vote struct {
+		duty
+		root phase0.Root
+	}
+	first := make(map[duty]phase0.Root)
+	reported := make(map[vote]struct{})
+
+	var doubleVotes []DoubleVote
+	for _, attestation := range attestations {
+		root, err := attestation.Data.HashTreeRoot()
+		if err != nil {
+			return nil, fmt.Errorf("slot %d: hashing attestation data: %w", attestation.Data.Slot, err)
+		}
+		validators, err := AttestingValidators(attestation, committees)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, validator := range validators {
+			key := duty{validator, attestation.Data.Slot}
+			seen, ok := first[key]
+			if !ok {
+				first[key] = root
+				continue
+			}
+			if seen == root {
+				continue
+			}
+			if _, ok := reported[vote{key, root}]; ok {
+				continue
+			}
+			reported[vote{key, root}] = struct{}{}
+			doubleVotes = append(doubleVotes, DoubleVote{
+				Validator: validator,
+				Slot:      key.slot,
+				First:     seen,
+				Second:    root,
+			})
+		}
+	}
+	return doubleVotes, nil
+}
+
+// LogDoubleVotes checks the attestations for every duty slot in epoch for
+// double votes and writes each one to the warning log.
+func LogDoubleVotes(epoch phase0.Epoch, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, committees Committees) {
+	byDutySlot := GatherAttestationsByDutySlot(blocks)
+	for _, slot := range slices.Sorted(maps.Keys(byDutySlot)) {
+		if slot < EpochLowestSlot(epoch) || slot > EpochHighestSlot(epoch) {
+			continue
+		}
+		doubleVotes, err := FindDoubleVotes(attestationsOf(byDutySlot[slot]), committees[slot])
+		if err != nil {
+			log.Error().Err(err).Uint64("slot", uint64(slot)).Msg("failed checking for double votes")
+			continue
+		}
+		for _, doubleVote := range doubleVotes {
+			log.Warn().Uint64("validator", uint64(doubleVote.Validator)).Uint64("slot", uint64(doubleVote.Slot)).Str("first", fmt.Sprintf("%#x", doubleVote.First)).Str("second", fmt.Sprintf("%#x", doubleVote.Second)).Msg("validator attested to conflicting data")
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package aggregation
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/prysmaticlabs/go-bitfield"
+	"github.com/rs/zerolog/log"
+)
+
+// SlotDump is the raw attestation data for one duty slot, as printed by
+// --dump-slot.
+type SlotDump struct {
+	Slot         phase0.Slot                                       `json:"slot"`
+	Committees   map[phase0.CommitteeIndex][]phase0.ValidatorIndex `json:"committees"`
+	Attestations []AttestationDump                                 `json:"attestations"`
+}
+
+// AttestationDump is an Electra attestation with its bitfields spelled out.
+type AttestationDump struct {
+	BlockSlot          phase0.Slot             `json:"block_slot"`
+	Data               *phase0.AttestationData `json:"data"`
+	CommitteeBits      []phase0.CommitteeIndex `json:"committee_bits"`
+	AggregationBits    string                  `json:"aggregation_bits"`
+	AggregationBitsLen uint64                  `json:"aggregation_bits_len"`
+}
+
+// bitString renders bits as a string of 0s and 1s, lowest index first.
+func bitString(bits bitfield.Bitlist) string {
+	var b strings.Builder
+	for i := uint64(0); i < bits.Len(); i++ {
+		if bits.BitAt(i) {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return b.String()
+}
+
+// DumpSlot fetches every block that may include attestations for slot, up to
+// the end of the following epoch, and collects those attestations together
+// with the slot's committees.
+func DumpSlot(ctx context.Context, service BeaconClient, slot phase0.Slot) (*SlotDump, error) {
+	epoch := SlotEpoch(slot)
+	committees, err := GetBeaconCommitees(ctx, service, epoch, epoch)
+	if err != nil {
+		// The attestations are still worth seeing without their committees.
+		log.Error().Err(err).Uint64("slot", uint64(slot)).Msg("failed fetching committees")
+	}
+
+	slots := make([]phase0.Slot, 0, 2*slotsPerEpoch)
+	for s := slot + 1; s <= EpochHighestSlot(epoch+1); s++ {
+		slots = append(slots, s)
+	}
+	blocks, err := fetchAttestationBlocks(ctx, service, slots, workers)
+	if err != nil {
+		return nil, err
+	}
+
+	dump := &SlotDump{
+		Slot:         slot,
+		Committees:   committees[slot],
+		Attestations: []AttestationDump{},
+	}
+	for _, blockSlot := range slices.Sorted(maps.Keys(blocks)) {
+		block := blocks[blockSlot]
+		if block.Version < spec.DataVersionElectra || block.Electra == nil {
+			continue
+		}
+		for _, attestation := range block.Electra.Message.Body.Attestations {
+			if attestation.Data.Slot != slot {
+				continue
+			}
+			indices := make([]phase0.CommitteeIndex, 0, attestation.CommitteeBits.Count())
+			for _, bit := range attestation.CommitteeBits.BitIndices() {
+				indices = append(indices, phase0.CommitteeIndex(bit))
+			}
+			dump.Attestations = append(dump.Attestations, AttestationDump{
+				BlockSlot:          blockSlot,
+				Data:               attestation.Data,
+				CommitteeBits:      indices,
+				AggregationBits:    bitString(attestation.AggregationBits),
+				AggregationBitsLen: attestation.AggregationBits.Len(),
+			})
+		}
+	}
+	return dump, nil
+}
+
+// WriteSlotDump writes dump to w as indented JSON.
+func WriteSlotDump(w io.Writer, dump *SlotDump) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(dump)
+}
@@ -0,0 +1,170 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+
+	eth2client "github.com/attestantio/go-eth2-client"
+	"github.com/attestantio/go-eth2-client/api"
+	apiv1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog/log"
+)
+
+// DEFAULT_DUTIES_BATCH_SIZE is how many validators GetDutyCommittees asks for
+// the attester duties of per request.
+const DEFAULT_DUTIES_BATCH_SIZE = 1000
+
+// UnassignedValidator fills the committee positions no attester duty placed a
+// validator in, in committees rebuilt by GetDutyCommittees.
+const UnassignedValidator = phase0.ValidatorIndex(math.MaxUint64)
+
+// CommitteeDisagreement is a committee on which two committee sources differ.
+type CommitteeDisagreement struct {
+	Slot    phase0.Slot
+	Index   phase0.CommitteeIndex
+	Problem string
+}
+
+// GetDutyCommittees rebuilds the committees of each epoch from start to end
+// from the attester duties of the validators in reference's committees for
+// that epoch, as an independent check on BeaconCommittees. Each duty places
+// its validator at a position in a committee of a given length; positions no
+// duty fills hold UnassignedValidator. The number of committees a duty
+// reports for its slot is checked against the committees rebuilt for it, and
+// any disagreement logged.
+func GetDutyCommittees(ctx context.Context, service BeaconClient, start phase0.Epoch, end phase0.Epoch, reference Committees) (Committees, error) {
+	provider, ok := service.(eth2client.AttesterDutiesProvider)
+	if !ok {
+		return nil, errors.New("beacon client does not provide attester duties")
+	}
+
+	result := make(Committees)
+	committeesAtSlot := make(map[phase0.Slot]uint64)
+	for epoch := start; epoch <= end; epoch++ {
+		var validators []phase0.ValidatorIndex
+		for slot := EpochLowestSlot(epoch); slot <= EpochHighestSlot(epoch); slot++ {
+			for _, index := range slices.Sorted(maps.Keys(reference[slot])) {
+				validators = append(validators, reference.Validators(slot, index)...)
+			}
+		}
+
+		for batch := range slices.Chunk(validators, DEFAULT_DUTIES_BATCH_SIZE) {
+			var duties []*apiv1.AttesterDuty
+			err := withRetry(ctx, retryAttempts, retryBaseDelay, func() error {
+				requestCtx, cancel := requestContext(ctx)
+				defer cancel()
+
+				resp, err := provider.AttesterDuties(requestCtx, &api.AttesterDutiesOpts{Epoch: epoch, Indices: batch})
+				if err != nil {
+					return err
+				}
+				duties = resp.Data
+				return nil
+			})
+			if err != nil {
+				return nil, contextError(fmt.Sprintf("fetching attester duties of %d validators for epoch %d", len(batch), epoch), err)
+			}
+
+			for _, duty := range duties {
+				if err := placeDuty(result, duty); err != nil {
+					return nil, fmt.Errorf("epoch %d: %w", epoch, err)
+				}
+				committeesAtSlot[duty.Slot] = max(committeesAtSlot[duty.Slot], duty.CommitteesAtSlot)
+			}
+		}
+	}
+
+	for _, slot := range slices.Sorted(maps.Keys(committeesAtSlot)) {
+		if got := uint64(len(result[slot])); got != committeesAtSlot[slot] {
+			log.Warn().Uint64("slot", uint64(slot)).Uint64("committees_at_slot", committeesAtSlot[slot]).Uint64("committees", got).Msg("attester duties disagree on the number of committees")
+		}
+	}
+	return result, nil
+}
+
+// placeDuty puts the validator of duty at its position in committees, creating
+// the committee at its length if needed.
+func placeDuty(committees Committees, duty *apiv1.AttesterDuty) error {
+	if _, ok := committees[duty.Slot]; !ok {
+		committees[duty.Slot] = make(map[phase0.CommitteeIndex][]phase0.ValidatorIndex)
+	}
+	committee, ok := committees[duty.Slot][duty.CommitteeIndex]
+	if !ok {
+		committee = make([]phase0.ValidatorIndex, duty.CommitteeLength)
+		for i := range committee {
+			committee[i] = UnassignedValidator
+		}
+		committees[duty.Slot][duty.CommitteeIndex] = committee
+	}
+	if uint64(len(committee)) != duty.CommitteeLength {
+		return fmt.Errorf("validator %d: committee %d at slot %d has length %d, but another duty gave %d", duty.ValidatorIndex, duty.CommitteeIndex, duty.Slot, duty.CommitteeLength, len(committee))
+	}
+	if duty.ValidatorCommitteeIndex >= duty.CommitteeLength {
+		return fmt.Errorf("validator %d: position %d is outside committee %d at slot %d of length %d", duty.ValidatorIndex, duty.ValidatorCommitteeIndex, duty.CommitteeIndex, duty.Slot, duty.CommitteeLength)
+	}
+	committee[duty.ValidatorCommitteeIndex] = duty.ValidatorIndex
+	return nil
+}
+
+// CompareCommittees returns every committee on which committees and other,
+// such as those from BeaconCommittees and GetDutyCommittees, differ: present
+// in only one, of different lengths, or with a different member at some
+// position, of which only the first is reported.
+func CompareCommittees(committees Committees, other Committees) []CommitteeDisagreement {
+	slots := slices.Collect(maps.Keys(committees))
+	slots = append(slots, slices.Collect(maps.Keys(other))...)
+	slices.Sort(slots)
+
+	var disagreements []CommitteeDisagreement
+	for _, slot := range slices.Compact(slots) {
+		indices := slices.Collect(maps.Keys(committees[slot]))
+		indices = append(indices, slices.Collect(maps.Keys(other[slot]))...)
+		slices.Sort(indices)
+		for _, index := range slices.Compact(indices) {
+			disagreement := CommitteeDisagreement{Slot: slot, Index: index}
+			first, second := committees.Validators(slot, index), other.Validators(slot, index)
+			switch {
+			case !committees.Has(slot, index):
+				disagreement.Problem = "only in the second source"
+			case !other.Has(slot, index):
+				disagreement.Problem = "only in the first source"
+			case len(first) != len(second):
+				disagreement.Problem = fmt.Sprintf("%d members against %d", len(first), len(second))
+			default:
+				position := -1
+				for i := range first {
+					if first[i] != second[i] {
+						position = i
+						break
+					}
+				}
+				if position < 0 {
+					continue
+				}
+				disagreement.Problem = fmt.Sprintf("position %d holds validator %s against %s", position, formatCommitteeMember(first[position]), formatCommitteeMember(second[position]))
+			}
+			disagreements = append(disagreements, disagreement)
+		}
+	}
+	return disagreements
+}
+
+func formatCommitteeMember(validator phase0.ValidatorIndex) string {
+	if validator == UnassignedValidator {
+		return "unassigned"
+	}
+	return fmt.Sprint(validator)
+}
+
+// LogCommitteeDisagreements writes each disagreement to the warning log,
+// naming the two sources compared.
+func LogCommitteeDisagreements(disagreements []CommitteeDisagreement, first string, second string) {
+	for _, disagreement := range disagreements {
+		log.Warn().Uint64("slot", uint64(disagreement.Slot)).Uint64("committee_index", uint64(disagreement.Index)).Str("first", first).Str("second", second).Msgf("committee sources disagree: %s", disagreement.Problem)
+	}
+}
@@ -0,0 +1,72 @@
+package aggregation
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+
+	"repro/internal/testutil"
+)
+
+func TestGetDutyCommittees(t *testing.T) {
+	defer func(previous uint64) { slotsPerEpoch = previous }(slotsPerEpoch)
+	ctx := context.Background()
+	client := testutil.NewFakeClient().
+		WithSlotsPerEpoch(4).
+		WithCommittee(1, 0, []phase0.ValidatorIndex{5, 2, 9}).
+		WithCommittee(1, 1, []phase0.ValidatorIndex{4, 7}).
+		WithCommittee(2, 0, []phase0.ValidatorIndex{1, 3, 6, 8}).
+		WithCommittee(5, 0, []phase0.ValidatorIndex{2, 1})
+	LoadSpec(ctx, client)
+	committees, err := GetBeaconCommitees(ctx, client, 0, 1)
+	if err != nil {
+		t.Fatalf("GetBeaconCommitees: %v", err)
+	}
+
+	duties, err := GetDutyCommittees(ctx, client, 0, 1, committees)
+	if err != nil {
+		t.Fatalf("GetDutyCommittees: %v", err)
+	}
+	if disagreements := CompareCommittees(committees, duties); len(disagreements) != 0 {
+		t.Errorf("got disagreements %+v", disagreements)
+	}
+
+	// A validator the duties do not cover leaves a hole in its committee.
+	committees[1][0] = []phase0.ValidatorIndex{5, 2}
+	duties, err = GetDutyCommittees(ctx, client, 0, 0, committees)
+	if err != nil {
+		t.Fatalf("GetDutyCommittees: %v", err)
+	}
+	if got := duties.Validators(1, 0); len(got) != 3 || got[2] != UnassignedValidator {
+		t.Errorf("got committee %v, want validator 9 unassigned", got)
+	}
+}
+
+func TestCompareCommittees(t *testing.T) {
+	committees := Committees{
+		1: {0: {1, 2, 3}, 1: {4, 5}, 2: {6}},
+		2: {0: {7, 8}},
+	}
+	other := Committees{
+		1: {0: {1, 2, 3}, 1: {4, 5, 9}, 3: {10}},
+		2: {0: {7, UnassignedValidator}},
+	}
+
+	disagreements := CompareCommittees(committees, other)
+	want := []CommitteeDisagreement{
+		{Slot: 1, Index: 1, Problem: "2 members against 3"},
+		{Slot: 1, Index: 2, Problem: "only in the first source"},
+		{Slot: 1, Index: 3, Problem: "only in the second source"},
+		{Slot: 2, Index: 0, Problem: "position 1 holds validator 8 against unassigned"},
+	}
+	if len(disagreements) != len(want) {
+		t.Fatalf("got disagreements %+v, want %+v", disagreements, want)
+	}
+	for i, disagreement := range disagreements {
+		if disagreement.Slot != want[i].Slot || disagreement.Index != want[i].Index || !strings.Contains(disagreement.Problem, want[i].Problem) {
+			t.Errorf("disagreement %d: got %+v, want %+v", i, disagreement, want[i])
+		}
+	}
+}
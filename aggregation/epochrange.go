@@ -0,0 +1,324 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog/log"
+)
+
+// EpochResult is the outcome of analyzing a single epoch of a range.
+type EpochResult struct {
+	Epoch      phase0.Epoch
+	Blocks     int
+	Mismatches []Mismatch
+	Summary    EpochSummary
+	// Skipped is set for epochs already in the store.
+	Skipped bool
+	// Unavailable is set for epochs whose committees the beacon node could
+	// not serve because it has pruned their state.
+	Unavailable bool
+	// TimedOut is set for epochs abandoned for exceeding
+	// RangeOptions.EpochBudget.
+	TimedOut bool
+}
+
+// RangeOptions configures ProcessEpochRange.
+type RangeOptions struct {
+	// Store, if set, receives the results of each epoch. Epochs it already
+	// holds are skipped unless Force is set.
+	Store *Store
+	Force bool
+	// CheckpointFile, if set, is updated with each epoch once it has been
+	// fully processed, until an epoch times out: the checkpoint then stays
+	// before it, so that resuming from the checkpoint retries it.
+	CheckpointFile string
+	// IncludeAttesters fills in the Attesters of the reports passed to Store
+	// and Reports.
+	IncludeAttesters bool
+	// Pubkeys, if set with IncludeAttesters, adds the pubkey of each attester
+	// to the reports.
+	Pubkeys *PubkeyResolver
+	// Reports, if set, is called with the per-slot reports of each epoch as
+	// soon as it has been analyzed.
+	Reports func(epoch phase0.Epoch, reports []BlockAttestationReport) error
+	// Progress periodically logs how many epochs have been processed and an
+	// estimate of the time left.
+	Progress bool
+	// CaptureDir, if set, receives a fixture for every block with a
+	// mismatch, as written by CaptureMismatches.
+	CaptureDir string
+	// EpochBudget, if set, bounds the time spent on each epoch. An epoch that
+	// exceeds it is recorded as timed out and the scan moves on.
+	EpochBudget time.Duration
+	// StopOnMismatch ends the scan at the first mismatch: the epoch it is in
+	// is the last, and its other mismatches are neither logged nor returned.
+	StopOnMismatch bool
+}
+
+// PreviousEpoch returns the epoch before epoch, or epoch itself at genesis.
+func PreviousEpoch(epoch phase0.Epoch) phase0.Epoch {
+	if epoch == 0 {
+		return 0
+	}
+	return epoch - 1
+}
+
+// ProcessEpochRange runs the aggregation bits check over every epoch from start
+// to end inclusive. Mismatches are counted against the epoch of their duty
+// slot, as FindEpochMismatches does. The per-slot reports cover an epoch's own
+// blocks, the first of which attests to the last slot of the previous epoch,
+// so committees are fetched for the previous epoch as well; the cache means
+// each epoch's committees are only fetched once. The summaries take in the
+// next epoch's blocks as well, for the attestations included late, and those
+// are kept for the next epoch rather than fetched again.
+func ProcessEpochRange(ctx context.Context, service BeaconClient, start phase0.Epoch, end phase0.Epoch, opts RangeOptions) ([]EpochResult, error) {
+	if start > end {
+		return nil, nil
+	}
+	cache := NewCommitteeCache(2)
+	// Only a capture writes out whole blocks.
+	lookahead := &blockLookahead{attestationsOnly: opts.CaptureDir == ""}
+	progress := newRangeProgress(int(end - start + 1))
+
+	// Once an epoch has timed out the checkpoint stays before it, so that a
+	// resumed scan retries it rather than starting after the epochs that
+	// followed.
+	timedOutEpoch := false
+	checkpoint := func(epoch phase0.Epoch) error {
+		if timedOutEpoch {
+			return nil
+		}
+		return writeRangeCheckpoint(opts, epoch)
+	}
+
+	results := make([]EpochResult, 0, end-start+1)
+	for epoch := start; epoch <= end; epoch++ {
+		began := time.Now()
+		if err := ctx.Err(); err != nil {
+			return results, contextError(fmt.Sprintf("processing epoch %d", epoch), err)
+		}
+
+		if opts.Store != nil && !opts.Force {
+			stored, err := opts.Store.HasEpoch(ctx, epoch)
+			if err != nil {
+				return results, fmt.Errorf("epoch %d: %w", epoch, err)
+			}
+			if stored {
+				log.Info().Uint64("epoch", uint64(epoch)).Msg("epoch already stored, skipping")
+				results = append(results, EpochResult{Epoch: epoch, Skipped: true})
+				if opts.Progress {
+					progress.epochSkipped()
+				}
+				if err := checkpoint(epoch); err != nil {
+					return results, err
+				}
+				continue
+			}
+		}
+
+		epochCtx, cancel := ctx, context.CancelFunc(func() {})
+		if opts.EpochBudget > 0 {
+			epochCtx, cancel = context.WithTimeout(ctx, opts.EpochBudget)
+		}
+		result, err := processRangeEpoch(epochCtx, service, cache, lookahead, epoch, opts)
+		timedOut := err != nil && errors.Is(epochCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil
+		cancel()
+		switch {
+		case timedOut:
+			log.Warn().Uint64("epoch", uint64(epoch)).Dur("budget", opts.EpochBudget).Msg("epoch exceeded its budget, skipping")
+			results = append(results, EpochResult{Epoch: epoch, TimedOut: true})
+			timedOutEpoch = true
+			if opts.Progress {
+				progress.epochSkipped()
+			}
+			continue
+		case err != nil:
+			return results, err
+		}
+
+		results = append(results, result)
+		if result.Unavailable {
+			if opts.Progress {
+				progress.epochSkipped()
+			}
+			continue
+		}
+		if err := checkpoint(epoch); err != nil {
+			return results, err
+		}
+		if opts.Progress {
+			progress.epochDone(time.Since(began), len(result.Mismatches))
+		}
+		if opts.StopOnMismatch && len(result.Mismatches) > 0 {
+			log.Info().Uint64("epoch", uint64(epoch)).Msg("stopping at the first epoch with a mismatch")
+			return results, nil
+		}
+	}
+	return results, nil
+}
+
+// blockLookahead holds the blocks of the last epoch listed. Each epoch of a
+// scan needs the next epoch's blocks too, for the attestations included late,
+// so keeping them means every epoch's blocks are only fetched once. With
+// attestationsOnly the blocks are listed with listEpochAttestations, holding
+// only their attestations.
+type blockLookahead struct {
+	attestationsOnly bool
+	epoch            phase0.Epoch
+	blocks           map[phase0.Slot]*spec.VersionedSignedBeaconBlock
+}
+
+// list returns the blocks of epoch, listing them unless they are held.
+func (l *blockLookahead) list(ctx context.Context, service BeaconClient, epoch phase0.Epoch) (map[phase0.Slot]*spec.VersionedSignedBeaconBlock, error) {
+	if l.blocks != nil && l.epoch == epoch {
+		return l.blocks, nil
+	}
+	list := ListEpochBlocksConcurrent
+	if l.attestationsOnly {
+		list = listEpochAttestations
+	}
+	blocks, err := list(ctx, service, epoch, workers)
+	if err != nil {
+		return nil, fmt.Errorf("epoch %d: %w", epoch, err)
+	}
+	l.epoch, l.blocks = epoch, blocks
+	return blocks, nil
+}
+
+// listWithNext returns the blocks of epoch, and those of epoch and the next
+// epoch together.
+func (l *blockLookahead) listWithNext(ctx context.Context, service BeaconClient, epoch phase0.Epoch) (map[phase0.Slot]*spec.VersionedSignedBeaconBlock, map[phase0.Slot]*spec.VersionedSignedBeaconBlock, error) {
+	blocks, err := l.list(ctx, service, epoch)
+	if err != nil {
+		return nil, nil, err
+	}
+	next, err := l.list(ctx, service, epoch+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	return blocks, mergeBlocks(blocks, next), nil
+}
+
+// processRangeEpoch analyzes a single epoch of a range scan, saving and
+// reporting its results as opts asks.
+func processRangeEpoch(ctx context.Context, service BeaconClient, cache *CommitteeCache, lookahead *blockLookahead, epoch phase0.Epoch, opts RangeOptions) (EpochResult, error) {
+	blocks, withNext, err := lookahead.listWithNext(ctx, service, epoch)
+	if err != nil {
+		return EpochResult{}, err
+	}
+
+	committees, err := cache.GetRange(ctx, service, PreviousEpoch(epoch), epoch)
+	if errors.Is(err, ErrStateUnavailable) {
+		log.Warn().Err(err).Uint64("epoch", uint64(epoch)).Msg("skipping epoch without state")
+		return EpochResult{Epoch: epoch, Unavailable: true}, nil
+	}
+	if err != nil {
+		return EpochResult{}, fmt.Errorf("epoch %d: %w", epoch, err)
+	}
+
+	mismatches, err := FindEpochMismatches(ctx, service, epoch, withNext, committees)
+	if err != nil {
+		return EpochResult{}, err
+	}
+	RecordEpochMetrics(epoch, len(blocks), len(mismatches))
+	if opts.StopOnMismatch && len(mismatches) > 1 {
+		// The scan stops here, and the first mismatch is the one to debug.
+		mismatches = mismatches[:1]
+	}
+	LogMismatches(mismatches)
+	if opts.CaptureDir != "" {
+		CaptureMismatches(ctx, service, opts.CaptureDir, blocks, committees, mismatches)
+	}
+	LogCommitteeAnomalies(CheckCommitteeConsistency(epoch, committees))
+	summary := Summarize(epoch, withNext, committees, mismatches)
+	log.Info().Uint64("epoch", uint64(epoch)).Int("blocks", len(blocks)).Int("missed", summary.BlocksMissed).Int("attestations", summary.Attestations).Int("attesters", summary.UniqueAttesters).Float64("epoch_participation", summary.EpochParticipation).Float64("participation", summary.Participation).Float64("avg_inclusion", summary.AvgInclusion).Int("mismatches", len(mismatches)).Msg("processed epoch")
+
+	if opts.Store != nil || opts.Reports != nil {
+		reports := BuildBlockReports(epoch, blocks, committees)
+		if opts.IncludeAttesters {
+			AddAttesters(reports, blocks, committees)
+			if opts.Pubkeys != nil {
+				if err := AddAttesterPubkeys(ctx, opts.Pubkeys, reports); err != nil {
+					return EpochResult{}, fmt.Errorf("epoch %d: failed resolving pubkeys: %w", epoch, err)
+				}
+			}
+		}
+		if opts.Store != nil {
+			if err := opts.Store.SaveEpoch(ctx, epoch, reports); err != nil {
+				return EpochResult{}, fmt.Errorf("epoch %d: failed saving results: %w", epoch, err)
+			}
+		}
+		if opts.Reports != nil {
+			if err := opts.Reports(epoch, reports); err != nil {
+				return EpochResult{}, fmt.Errorf("epoch %d: failed writing reports: %w", epoch, err)
+			}
+		}
+	}
+
+	return EpochResult{
+		Epoch:      epoch,
+		Blocks:     len(blocks),
+		Mismatches: mismatches,
+		Summary:    summary,
+	}, nil
+}
+
+func writeRangeCheckpoint(opts RangeOptions, epoch phase0.Epoch) error {
+	if opts.CheckpointFile == "" {
+		return nil
+	}
+	if err := WriteCheckpoint(opts.CheckpointFile, epoch); err != nil {
+		return fmt.Errorf("epoch %d: failed writing checkpoint: %w", epoch, err)
+	}
+	return nil
+}
+
+// WriteRangeSummary writes the per-epoch mismatch counts of a range scan, split
+// into length overshoots and undershoots, and their totals to w, along with
+// the number of epochs that exceeded their budget if any did. Mismatches
+// whose lengths agree, such as malformed attestations, count towards neither.
+func WriteRangeSummary(w io.Writer, results []EpochResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "epoch\tblocks\tmismatches\tovershoot\tundershoot\t")
+	total, overshoots, undershoots, timedOut := 0, 0, 0, 0
+	for _, result := range results {
+		if result.Skipped {
+			fmt.Fprintf(tw, "%d\tstored\t-\t-\t-\t\n", result.Epoch)
+			continue
+		}
+		if result.Unavailable {
+			fmt.Fprintf(tw, "%d\tpruned\t-\t-\t-\t\n", result.Epoch)
+			continue
+		}
+		if result.TimedOut {
+			fmt.Fprintf(tw, "%d\ttimeout\t-\t-\t-\t\n", result.Epoch)
+			timedOut++
+			continue
+		}
+		over, under := 0, 0
+		for _, mismatch := range result.Mismatches {
+			switch mismatch.Direction() {
+			case "overshoot":
+				over++
+			case "undershoot":
+				under++
+			}
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t\n", result.Epoch, result.Blocks, len(result.Mismatches), over, under)
+		total += len(result.Mismatches)
+		overshoots += over
+		undershoots += under
+	}
+	fmt.Fprintf(tw, "total\t\t%d\t%d\t%d\t\n", total, overshoots, undershoots)
+	if timedOut > 0 {
+		fmt.Fprintf(tw, "timed out\t%d\t\t\t\t\n", timedOut)
+	}
+	return tw.Flush()
+}
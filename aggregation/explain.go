@@ -0,0 +1,79 @@
+package aggregation
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+)
+
+// WriteMismatchDetail writes everything known about a single mismatch to w:
+// where it was found, its bits and lengths, and how its aggregation bits
+// divide between the committees it covers, in the ascending index order they
+// are concatenated in, down to which bits each committee has set. Bits past
+// the last committee, as an overshoot has, are listed on their own, and a
+// committee missing from committees shows as unknown with no bits.
+func WriteMismatchDetail(w io.Writer, mismatch Mismatch, committees Committees) error {
+	where := fmt.Sprintf("block slot %d", mismatch.BlockSlot)
+	if mismatch.Pool {
+		where = "the attestation pool"
+	}
+	fmt.Fprintf(w, "mismatch in %s for duty slot %d\n", where, mismatch.DutySlot)
+	if mismatch.Err != nil {
+		fmt.Fprintf(w, "  error:            %v\n", mismatch.Err)
+	}
+	if mismatch.CommitteeBits != nil {
+		fmt.Fprintf(w, "  committee bits:   %s\n", FormatCommitteeBits(mismatch.CommitteeBits))
+	}
+	fmt.Fprintf(w, "  aggregation bits: %s\n", FormatBitlist(mismatch.AggregationBits))
+	fmt.Fprintf(w, "  computed length:  %d\n", mismatch.Computed)
+	fmt.Fprintf(w, "  actual length:    %d", mismatch.Actual)
+	if direction := mismatch.Direction(); direction != "" {
+		fmt.Fprintf(w, " (%s by %d)", direction, max(mismatch.Delta(), -mismatch.Delta()))
+	}
+	fmt.Fprintln(w)
+
+	actual := uint64(0)
+	if mismatch.AggregationBits != nil {
+		actual = mismatch.AggregationBits.Len()
+	}
+	// setBits lists the set aggregation bits from start for up to size bits,
+	// relative to start, stopping at the end of the bitlist.
+	setBits := func(start uint64, size uint64) []int {
+		var set []int
+		for i := start; i < start+size && i < actual; i++ {
+			if mismatch.AggregationBits.BitAt(i) {
+				set = append(set, int(i-start))
+			}
+		}
+		return set
+	}
+
+	fmt.Fprintln(w, "committees in aggregation bits order:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "committee\tsize\tbits\tset\t")
+	offset := uint64(0)
+	indices := slices.Clone(mismatch.CommitteeIndices)
+	slices.Sort(indices)
+	for _, index := range indices {
+		if !committees.Has(mismatch.DutySlot, index) {
+			fmt.Fprintf(tw, "%d\tunknown\t-\t-\t\n", index)
+			continue
+		}
+		size := uint64(committees.CommitteeSize(mismatch.DutySlot, index))
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t\n", index, size, bitRange(offset, size), formatIndices(setBits(offset, size)))
+		offset += size
+	}
+	if actual > offset {
+		fmt.Fprintf(tw, "beyond\t%d\t%s\t%s\t\n", actual-offset, bitRange(offset, actual-offset), formatIndices(setBits(offset, actual-offset)))
+	}
+	return tw.Flush()
+}
+
+// bitRange formats size bits from start as an inclusive range.
+func bitRange(start uint64, size uint64) string {
+	if size == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d-%d", start, start+size-1)
+}
@@ -0,0 +1,65 @@
+package aggregation
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+
+	"repro/internal/testutil"
+)
+
+func TestWriteMismatchDetail(t *testing.T) {
+	committees := Committees{4: {
+		0: {1, 2, 3},
+		2: {4, 5},
+	}}
+	// Committees 0, 2 and the unknown 5 set, with two stray bits past the
+	// known committees.
+	att := testutil.BuildAttestation(map[phase0.CommitteeIndex]int{0: 3, 2: 2, 5: 2}, map[phase0.CommitteeIndex][]int{0: {1}, 2: {0, 1}, 5: {1}})
+	att.Data.Slot = 4
+	mismatch := Mismatch{
+		BlockSlot:        5,
+		DutySlot:         4,
+		CommitteeIndices: []phase0.CommitteeIndex{5, 0, 2},
+		Computed:         5,
+		Actual:           att.AggregationBits.Len(),
+		Err:              &UnknownCommitteeError{Slot: 4, Index: 5},
+		AggregationBits:  att.AggregationBits,
+		CommitteeBits:    att.CommitteeBits,
+	}
+
+	var buf bytes.Buffer
+	if err := WriteMismatchDetail(&buf, mismatch, committees); err != nil {
+		t.Fatalf("WriteMismatchDetail: %v", err)
+	}
+	output := buf.String()
+	for _, want := range []string{
+		"mismatch in block slot 5 for duty slot 4",
+		"error:            unknown committee",
+		"actual length:    7 (overshoot by 2)",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output lacks %q:\n%s", want, output)
+		}
+	}
+
+	rows := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 4 {
+			rows[fields[0]] = strings.Join(fields[1:], " ")
+		}
+	}
+	for committee, want := range map[string]string{
+		"0":      "3 0-2 [1]",
+		"2":      "2 3-4 [0,1]",
+		"5":      "unknown - -",
+		"beyond": "2 5-6 [1]",
+	} {
+		if rows[committee] != want {
+			t.Errorf("committee %s: got %q, want %q\n%s", committee, rows[committee], want, output)
+		}
+	}
+}
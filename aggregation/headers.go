@@ -0,0 +1,72 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/attestantio/go-eth2-client/api"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"golang.org/x/sync/errgroup"
+)
+
+// headersFirst makes ListEpochBlocksConcurrent find missed slots from block
+// headers before fetching any blocks.
+var headersFirst bool
+
+// SetHeadersFirst sets whether block listings first fetch the block headers
+// of an epoch, so that no full block request is spent on a missed slot.
+func SetHeadersFirst(enabled bool) {
+	headersFirst = enabled
+}
+
+// isNotFound reports whether err is the beacon node saying the requested
+// object does not exist, which for a slot means it was missed.
+func isNotFound(err error) bool {
+	var apiErr *api.Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// EpochSlotStatus reports for every slot in epoch whether a block was produced,
+// using block headers so that no block bodies need to be downloaded.
+func EpochSlotStatus(ctx context.Context, service BeaconClient, epoch phase0.Epoch) (map[phase0.Slot]bool, error) {
+	result := make(map[phase0.Slot]bool, slotsPerEpoch)
+	var mu sync.Mutex
+
+	g, ctx := errgroup.WithContext(ctx)
+	if workers > 0 {
+		g.SetLimit(workers)
+	}
+	for slot := EpochLowestSlot(epoch); slot <= EpochHighestSlot(epoch); slot++ {
+		g.Go(func() error {
+			requestCtx, cancel := requestContext(ctx)
+			defer cancel()
+			resp, err := service.BeaconBlockHeader(requestCtx, &api.BeaconBlockHeaderOpts{
+				Block: fmt.Sprintf("%d", slot),
+			})
+			produced := true
+			switch {
+			case isNotFound(err):
+				produced = false
+			case err != nil:
+				return fmt.Errorf("failed fetching header for slot %d: %w", slot, err)
+			case resp == nil || resp.Data == nil || resp.Data.Header == nil || resp.Data.Header.Message == nil:
+				produced = false
+			default:
+				// A missed slot may be answered with the latest block before it.
+				produced = resp.Data.Header.Message.Slot == slot
+			}
+
+			mu.Lock()
+			result[slot] = produced
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
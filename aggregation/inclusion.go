@@ -0,0 +1,66 @@
+package aggregation
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+)
+
+// InclusionDistance returns how many slots after dutySlot an attestation was
+// included, or 0 if inclusionSlot is not after dutySlot.
+func InclusionDistance(dutySlot phase0.Slot, inclusionSlot phase0.Slot) uint64 {
+	if inclusionSlot <= dutySlot {
+		return 0
+	}
+	return uint64(inclusionSlot - dutySlot)
+}
+
+// InclusionHistogram counts the attestations for an epoch's duty slots by
+// inclusion distance. Counts[d] holds distance d for d below slots per epoch;
+// the last entry collects every larger distance.
+type InclusionHistogram struct {
+	Epoch  phase0.Epoch `json:"epoch"`
+	Counts []int        `json:"counts"`
+}
+
+// BuildInclusionHistogram measures the inclusion distance of every
+// attestation in blocks whose duty slot falls in epoch. Attestations for the
+// end of epoch are mostly included in the following epoch, so blocks should
+// cover both.
+func BuildInclusionHistogram(epoch phase0.Epoch, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock) InclusionHistogram {
+	histogram := InclusionHistogram{
+		Epoch:  epoch,
+		Counts: make([]int, slotsPerEpoch+1),
+	}
+	for slot, attestations := range GatherAttestationsByDutySlot(blocks) {
+		if slot < EpochLowestSlot(epoch) || slot > EpochHighestSlot(epoch) {
+			continue
+		}
+		for _, attestation := range attestations {
+			distance := min(InclusionDistance(slot, attestation.InclusionSlot), slotsPerEpoch)
+			histogram.Counts[distance]++
+		}
+	}
+	return histogram
+}
+
+// WriteInclusionHistogram writes the non-empty buckets of histogram to w.
+func WriteInclusionHistogram(w io.Writer, histogram InclusionHistogram) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "distance\tattestations\t")
+	last := len(histogram.Counts) - 1
+	for distance, count := range histogram.Counts {
+		if distance == 0 || count == 0 {
+			continue
+		}
+		if distance == last {
+			fmt.Fprintf(tw, "%d+\t%d\t\n", distance, count)
+			continue
+		}
+		fmt.Fprintf(tw, "%d\t%d\t\n", distance, count)
+	}
+	return tw.Flush()
+}
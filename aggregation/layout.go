@@ -0,0 +1,74 @@
+package aggregation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+)
+
+// SlotCommitteeLayout is the committees of one slot, as printed by
+// --committees-only.
+type SlotCommitteeLayout struct {
+	Slot       phase0.Slot       `json:"slot"`
+	Validators int               `json:"validators"`
+	Committees []CommitteeLayout `json:"committees"`
+}
+
+// CommitteeLayout is one committee and its members, in committee order.
+type CommitteeLayout struct {
+	Index      phase0.CommitteeIndex   `json:"index"`
+	Size       int                     `json:"size"`
+	Validators []phase0.ValidatorIndex `json:"validators"`
+}
+
+// CommitteeLayouts lays out the committees of every slot of epoch, in slot and
+// then committee order. Slots without committees are left out.
+func CommitteeLayouts(epoch phase0.Epoch, committees Committees) []SlotCommitteeLayout {
+	layouts := make([]SlotCommitteeLayout, 0, slotsPerEpoch)
+	for slot := EpochLowestSlot(epoch); slot <= EpochHighestSlot(epoch); slot++ {
+		if len(committees[slot]) == 0 {
+			continue
+		}
+		layout := SlotCommitteeLayout{
+			Slot:       slot,
+			Validators: committees.Size(slot),
+			Committees: make([]CommitteeLayout, 0, len(committees[slot])),
+		}
+		for _, index := range slices.Sorted(maps.Keys(committees[slot])) {
+			layout.Committees = append(layout.Committees, CommitteeLayout{
+				Index:      index,
+				Size:       committees.CommitteeSize(slot, index),
+				Validators: committees.Validators(slot, index),
+			})
+		}
+		layouts = append(layouts, layout)
+	}
+	return layouts
+}
+
+// WriteCommitteeTable writes the size of every committee in layouts to w, with
+// each slot's total.
+func WriteCommitteeTable(w io.Writer, layouts []SlotCommitteeLayout) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "slot\tcommittee\tsize\t")
+	total := 0
+	for _, layout := range layouts {
+		for _, committee := range layout.Committees {
+			fmt.Fprintf(tw, "%d\t%d\t%d\t\n", layout.Slot, committee.Index, committee.Size)
+		}
+		fmt.Fprintf(tw, "%d\ttotal\t%d\t\n", layout.Slot, layout.Validators)
+		total += layout.Validators
+	}
+	fmt.Fprintf(tw, "epoch\ttotal\t%d\t\n", total)
+	return tw.Flush()
+}
+
+// WriteCommitteesJSON writes layouts to w as a single JSON array.
+func WriteCommitteesJSON(w io.Writer, layouts []SlotCommitteeLayout) error {
+	return json.NewEncoder(w).Encode(layouts)
+}
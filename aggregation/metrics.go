@@ -0,0 +1,66 @@
+package aggregation
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	aggregationMismatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregation_mismatches_total",
+		Help: "Attestations whose aggregation bits length disagrees with their committees.",
+	}, []string{"epoch"})
+	beaconRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "beacon_request_duration_seconds",
+		Help:    "Duration of beacon node API requests.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+	lastProcessedEpoch = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "last_processed_epoch",
+		Help: "The most recent epoch fully analyzed.",
+	})
+	missedSlots = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "missed_slots_total",
+		Help: "Slots in analyzed epochs without a block.",
+	})
+)
+
+// RegisterMetrics registers the package's Prometheus metrics with r.
+func RegisterMetrics(r prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{aggregationMismatches, beaconRequestDuration, lastProcessedEpoch, missedSlots} {
+		if err := r.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// observeRequest records the duration of a beacon node request to endpoint
+// that started at start. Call it deferred.
+func observeRequest(endpoint string, start time.Time) {
+	beaconRequestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+}
+
+// RecordEpochMetrics updates the per-epoch metrics once epoch has been
+// analyzed.
+func RecordEpochMetrics(epoch phase0.Epoch, blocks int, mismatches int) {
+	recordMismatchMetrics(epoch, mismatches)
+	recordEpochBlockMetrics(epoch, blocks)
+}
+
+// recordMismatchMetrics counts mismatches found for the duty slots of epoch.
+func recordMismatchMetrics(epoch phase0.Epoch, mismatches int) {
+	aggregationMismatches.WithLabelValues(strconv.FormatUint(uint64(epoch), 10)).Add(float64(mismatches))
+}
+
+// recordEpochBlockMetrics counts the missed slots of epoch, which had blocks
+// blocks, and marks it processed.
+func recordEpochBlockMetrics(epoch phase0.Epoch, blocks int) {
+	if uint64(blocks) < slotsPerEpoch {
+		missedSlots.Add(float64(slotsPerEpoch - uint64(blocks)))
+	}
+	lastProcessedEpoch.Set(float64(epoch))
+}
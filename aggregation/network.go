@@ -0,0 +1,66 @@
+package aggregation
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+)
+
+// networks maps the genesis validators roots of the public networks to their
+// names.
+var networks = map[phase0.Root]string{
+	mustRoot("0x4b363db94e286120d76eb905340fdd4e54bfe9f06bf33ff6cf5ad27f511bfe95"): "mainnet",
+	mustRoot("0x9143aa7c615a7f7115e2b6aac319c03529df8242ae705fba9df39b79c59fa8b1"): "holesky",
+	mustRoot("0xd8ea171f3c94aea21ebc42a1ed61052acf3f9209c00e4efbaaddac09ed9b8078"): "sepolia",
+}
+
+func mustRoot(value string) phase0.Root {
+	root, err := parseRoot(value)
+	if err != nil {
+		panic(err)
+	}
+	return root
+}
+
+// parseRoot parses a 0x-prefixed hex root.
+func parseRoot(value string) (phase0.Root, error) {
+	var root phase0.Root
+	decoded, err := hex.DecodeString(strings.TrimPrefix(value, "0x"))
+	if err != nil || len(decoded) != len(root) {
+		return root, fmt.Errorf("invalid root %q", value)
+	}
+	copy(root[:], decoded)
+	return root, nil
+}
+
+// NetworkName returns the name of the public network with genesis validators
+// root root, or "unknown" for any other, such as a devnet.
+func NetworkName(root phase0.Root) string {
+	if name, ok := networks[root]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ErrNetworkMismatch is matched by a NetworkMismatchError.
+var ErrNetworkMismatch = errors.New("different network")
+
+// NetworkMismatchError is saved data, such as the committee cache or the
+// database, recorded from a network other than the beacon node's.
+type NetworkMismatchError struct {
+	// What names the data, e.g. "cache".
+	What     string
+	Recorded phase0.Root
+	Current  phase0.Root
+}
+
+func (e *NetworkMismatchError) Error() string {
+	return fmt.Sprintf("%s is from a different network: it was recorded on %s (genesis validators root %#x), but the beacon node is on %s (%#x)", e.What, NetworkName(e.Recorded), e.Recorded, NetworkName(e.Current), e.Current)
+}
+
+func (e *NetworkMismatchError) Is(target error) bool {
+	return target == ErrNetworkMismatch
+}
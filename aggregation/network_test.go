@@ -0,0 +1,57 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+)
+
+func TestNetworkName(t *testing.T) {
+	if got := NetworkName(mustRoot("0x4b363db94e286120d76eb905340fdd4e54bfe9f06bf33ff6cf5ad27f511bfe95")); got != "mainnet" {
+		t.Errorf("got %q for mainnet's root", got)
+	}
+	if got := NetworkName(phase0.Root{1}); got != "unknown" {
+		t.Errorf("got %q for a devnet root", got)
+	}
+}
+
+func TestCommitteeDiskCacheNetworkMismatch(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := OpenCommitteeDiskCache(dir, phase0.Root{1}, 0, false); err != nil {
+		t.Fatalf("OpenCommitteeDiskCache: %v", err)
+	}
+	if _, err := OpenCommitteeDiskCache(dir, phase0.Root{1}, 0, true); err != nil {
+		t.Errorf("reopening for the same network: %v", err)
+	}
+
+	_, err := OpenCommitteeDiskCache(dir, phase0.Root{2}, 0, true)
+	if !errors.Is(err, ErrNetworkMismatch) {
+		t.Fatalf("got %v, want ErrNetworkMismatch", err)
+	}
+	if !strings.Contains(err.Error(), "cache is from a different network") || !strings.Contains(err.Error(), "0x0100") {
+		t.Errorf("error %q does not explain the mismatch", err)
+	}
+}
+
+func TestStoreCheckNetwork(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenStore(filepath.Join(t.TempDir(), "results.db"))
+	if err != nil {
+		t.Fatalf("OpenStore: %v", err)
+	}
+	defer store.Close()
+
+	for range 2 {
+		if err := store.CheckNetwork(ctx, phase0.Root{1}); err != nil {
+			t.Fatalf("CheckNetwork: %v", err)
+		}
+	}
+	var mismatch *NetworkMismatchError
+	if err := store.CheckNetwork(ctx, phase0.Root{2}); !errors.As(err, &mismatch) || mismatch.Recorded != (phase0.Root{1}) {
+		t.Errorf("got %v, want a *NetworkMismatchError recording root 1", err)
+	}
+}
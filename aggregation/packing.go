@@ -0,0 +1,102 @@
+package aggregation
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+)
+
+// DEFAULT_LOW_PACKING_FILL is the average aggregation bit fill ratio below
+// which a block is flagged as poorly packed.
+const DEFAULT_LOW_PACKING_FILL = 0.5
+
+// BlockPackingStats describes how densely a proposer packed attestations into
+// a block.
+type BlockPackingStats struct {
+	BlockSlot       phase0.Slot `json:"block_slot"`
+	Attestations    int         `json:"attestations"`
+	UniqueAttesters int         `json:"unique_attesters"`
+	Committees      int         `json:"committees"`
+	AverageFill     float64     `json:"average_fill"`
+}
+
+// Low reports whether the block's aggregates are unusually sparse, which
+// wastes block space.
+func (s BlockPackingStats) Low() bool {
+	return s.Attestations > 0 && s.AverageFill < DEFAULT_LOW_PACKING_FILL
+}
+
+// PackingEfficiency computes packing statistics for an Electra block. The fill
+// ratio of an attestation is the fraction of its aggregation bits that are set.
+func PackingEfficiency(block *spec.VersionedSignedBeaconBlock, committees Committees) (BlockPackingStats, error) {
+	blockSlot, err := block.Slot()
+	if err != nil {
+		return BlockPackingStats{}, err
+	}
+	stats := BlockPackingStats{BlockSlot: blockSlot}
+	if block.Version < spec.DataVersionElectra || block.Electra == nil {
+		return stats, errors.New("packing efficiency requires an Electra block")
+	}
+
+	type duty struct {
+		slot  phase0.Slot
+		index phase0.CommitteeIndex
+	}
+	covered := make(map[duty]struct{})
+	attesters := make(map[phase0.ValidatorIndex]struct{})
+	fill := 0.0
+	for _, attestation := range block.Electra.Message.Body.Attestations {
+		stats.Attestations++
+		if length := attestation.AggregationBits.Len(); length > 0 {
+			fill += float64(attestation.AggregationBits.Count()) / float64(length)
+		}
+		for _, bit := range attestation.CommitteeBits.BitIndices() {
+			covered[duty{attestation.Data.Slot, phase0.CommitteeIndex(bit)}] = struct{}{}
+		}
+
+		validators, err := AttestingValidators(attestation, committees[attestation.Data.Slot])
+		if err != nil {
+			return stats, fmt.Errorf("block %d: %w", blockSlot, err)
+		}
+		for _, validator := range validators {
+			attesters[validator] = struct{}{}
+		}
+	}
+
+	stats.UniqueAttesters = len(attesters)
+	stats.Committees = len(covered)
+	if stats.Attestations > 0 {
+		stats.AverageFill = fill / float64(stats.Attestations)
+	}
+	return stats, nil
+}
+
+// WritePackingTable writes the packing statistics of every Electra block in
+// blocks to w, marking poorly packed ones.
+func WritePackingTable(w io.Writer, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, committees Committees) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "slot\tattestations\tattesters\tcommittees\tfill\t\t")
+	for _, slot := range slices.Sorted(maps.Keys(blocks)) {
+		block := blocks[slot]
+		if block.Version < spec.DataVersionElectra {
+			continue
+		}
+		stats, err := PackingEfficiency(block, committees)
+		if err != nil {
+			fmt.Fprintf(tw, "%d\t-\t-\t-\t-\terror: %v\t\n", slot, err)
+			continue
+		}
+		flag := ""
+		if stats.Low() {
+			flag = "low"
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%.2f%%\t%s\t\n", slot, stats.Attestations, stats.UniqueAttesters, stats.Committees, stats.AverageFill*100, flag)
+	}
+	return tw.Flush()
+}
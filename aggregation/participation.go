@@ -0,0 +1,181 @@
+package aggregation
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+)
+
+// ParticipationRate returns the fraction of dutySlot's committee members that
+// attested in any of attestations, along with the attesting and total counts.
+// Overlapping aggregates are merged first so validators included in several
+// of them are only counted once.
+func ParticipationRate(dutySlot phase0.Slot, attestations []*electra.Attestation, committees map[phase0.CommitteeIndex][]phase0.ValidatorIndex) (float64, int, int, error) {
+	total := 0
+	for _, committee := range committees {
+		total += len(committee)
+	}
+
+	forSlot := make([]*electra.Attestation, 0, len(attestations))
+	for _, attestation := range attestations {
+		if attestation.Data.Slot == dutySlot {
+			forSlot = append(forSlot, attestation)
+		}
+	}
+	merged, err := MergeAggregates(forSlot, committees)
+	if err != nil {
+		return 0, 0, total, err
+	}
+
+	attested := 0
+	for _, bits := range merged {
+		attested += int(bits.Count())
+	}
+
+	if total == 0 {
+		return 0, attested, 0, nil
+	}
+	return float64(attested) / float64(total), attested, total, nil
+}
+
+// CommitteeParticipationRate is ParticipationRate for committee index of
+// dutySlot alone. The rest of committees are still needed to locate its bits
+// in aggregates covering several committees.
+func CommitteeParticipationRate(dutySlot phase0.Slot, attestations []*electra.Attestation, committees map[phase0.CommitteeIndex][]phase0.ValidatorIndex, index phase0.CommitteeIndex) (float64, int, int, error) {
+	total := len(committees[index])
+
+	forSlot := make([]*electra.Attestation, 0, len(attestations))
+	for _, attestation := range attestations {
+		if attestation.Data.Slot == dutySlot && attestation.CommitteeBits.BitAt(uint64(index)) {
+			forSlot = append(forSlot, attestation)
+		}
+	}
+	merged, err := MergeAggregates(forSlot, committees)
+	if err != nil {
+		return 0, 0, total, err
+	}
+
+	attested := int(merged[index].Count())
+	if total == 0 {
+		return 0, attested, 0, nil
+	}
+	return float64(attested) / float64(total), attested, total, nil
+}
+
+// MissingAttesters returns, sorted ascending, the members of dutySlot's
+// committees whose aggregation bit is not set in any of attestations.
+func MissingAttesters(dutySlot phase0.Slot, attestations []*electra.Attestation, committees map[phase0.CommitteeIndex][]phase0.ValidatorIndex) ([]phase0.ValidatorIndex, error) {
+	forSlot := make([]*electra.Attestation, 0, len(attestations))
+	for _, attestation := range attestations {
+		if attestation.Data.Slot == dutySlot {
+			forSlot = append(forSlot, attestation)
+		}
+	}
+	merged, err := MergeAggregates(forSlot, committees)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []phase0.ValidatorIndex
+	for index, committee := range committees {
+		bits, ok := merged[index]
+		for position, validator := range committee {
+			if !ok || !bits.BitAt(uint64(position)) {
+				missing = append(missing, validator)
+			}
+		}
+	}
+	slices.Sort(missing)
+	return missing, nil
+}
+
+// EpochMissingAttesters returns, sorted ascending and deduplicated, every
+// validator missing from some duty slot of epoch, as WriteMissingAttesters
+// lists them. Slots whose attestations cannot be decoded are left out.
+// blocks must include the next epoch's, as WithNextEpochBlocks returns, or
+// every member of the last slot's committees is missing.
+func EpochMissingAttesters(epoch phase0.Epoch, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, committees Committees) []phase0.ValidatorIndex {
+	byDutySlot := GatherAttestationsByDutySlot(blocks)
+	var all []phase0.ValidatorIndex
+	for slot := EpochLowestSlot(epoch); slot <= EpochHighestSlot(epoch); slot++ {
+		missing, err := MissingAttesters(slot, attestationsOf(byDutySlot[slot]), committees[slot])
+		if err != nil {
+			continue
+		}
+		all = append(all, missing...)
+	}
+	slices.Sort(all)
+	return slices.Compact(all)
+}
+
+// WriteMissingAttesters writes, for every duty slot in epoch, the validators
+// that did not attest to it. If pubkeys is not nil each validator is followed
+// by its pubkey. blocks must include the next epoch's, as for
+// EpochMissingAttesters.
+func WriteMissingAttesters(w io.Writer, epoch phase0.Epoch, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, committees Committees, pubkeys map[phase0.ValidatorIndex]phase0.BLSPubKey) error {
+	byDutySlot := GatherAttestationsByDutySlot(blocks)
+	for slot := EpochLowestSlot(epoch); slot <= EpochHighestSlot(epoch); slot++ {
+		missing, err := MissingAttesters(slot, attestationsOf(byDutySlot[slot]), committees[slot])
+		if err != nil {
+			if _, err := fmt.Fprintf(w, "%d: error: %v\n", slot, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if pubkeys == nil {
+			if _, err := fmt.Fprintf(w, "%d: %d missing %v\n", slot, len(missing), missing); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%d: %d missing\n", slot, len(missing)); err != nil {
+			return err
+		}
+		for _, validator := range missing {
+			if _, err := fmt.Fprintf(w, "  %d %s\n", validator, formatPubkey(pubkeys, validator)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteParticipationTable writes the participation rate of every duty slot in
+// epoch to w. blocks must include the next epoch's, as for
+// EpochMissingAttesters, or the last slot rates close to nothing.
+func WriteParticipationTable(w io.Writer, epoch phase0.Epoch, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, committees Committees) error {
+	return writeParticipationTable(w, epoch, blocks, func(slot phase0.Slot, attestations []*electra.Attestation) (float64, int, int, error) {
+		return ParticipationRate(slot, attestations, committees[slot])
+	})
+}
+
+// WriteCommitteeParticipationTable is WriteParticipationTable for committee
+// index of each duty slot alone.
+func WriteCommitteeParticipationTable(w io.Writer, epoch phase0.Epoch, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, committees Committees, index phase0.CommitteeIndex) error {
+	return writeParticipationTable(w, epoch, blocks, func(slot phase0.Slot, attestations []*electra.Attestation) (float64, int, int, error) {
+		return CommitteeParticipationRate(slot, attestations, committees[slot], index)
+	})
+}
+
+// writeParticipationTable writes the participation rate of every duty slot in
+// epoch to w, as rate works it out from the slot's attestations.
+func writeParticipationTable(w io.Writer, epoch phase0.Epoch, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, rate func(slot phase0.Slot, attestations []*electra.Attestation) (float64, int, int, error)) error {
+	byDutySlot := GatherAttestationsByDutySlot(blocks)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "slot\tattested\tcommittee\trate\t")
+	for slot := EpochLowestSlot(epoch); slot <= EpochHighestSlot(epoch); slot++ {
+		rate, attested, total, err := rate(slot, attestationsOf(byDutySlot[slot]))
+		if err != nil {
+			fmt.Fprintf(tw, "%d\t-\t%d\terror: %v\t\n", slot, total, err)
+			continue
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%.2f%%\t\n", slot, attested, total, rate*100)
+	}
+	return tw.Flush()
+}
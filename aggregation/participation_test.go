@@ -0,0 +1,90 @@
+package aggregation
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"repro/internal/testutil"
+)
+
+func TestEpochMissingAttestersAcrossEpochBoundary(t *testing.T) {
+	defer func(previous uint64) { slotsPerEpoch = previous }(slotsPerEpoch)
+	slotsPerEpoch = 4
+	logger := log.Logger
+	log.Logger = zerolog.Nop()
+	defer func() { log.Logger = logger }()
+
+	sizes := map[phase0.CommitteeIndex]int{0: 2}
+	early := testutil.BuildAttestation(sizes, map[phase0.CommitteeIndex][]int{0: {0, 1}})
+	early.Data.Slot = 2
+	// The last slot of epoch 0 is attested to in the first block of epoch 1.
+	late := testutil.BuildAttestation(sizes, map[phase0.CommitteeIndex][]int{0: {1}})
+	late.Data.Slot = 3
+
+	ctx := context.Background()
+	client := testutil.NewFakeClient().
+		WithSlotsPerEpoch(4).
+		WithCommittee(2, 0, []phase0.ValidatorIndex{1, 2}).
+		WithCommittee(3, 0, []phase0.ValidatorIndex{3, 4}).
+		WithBlock(3, early).
+		WithBlock(4, late)
+	committees, err := GetBeaconCommitees(ctx, client, 0, 0)
+	if err != nil {
+		t.Fatalf("GetBeaconCommitees: %v", err)
+	}
+	blocks, err := ListEpochBlocksConcurrent(ctx, client, 0, 2)
+	if err != nil {
+		t.Fatalf("ListEpochBlocksConcurrent: %v", err)
+	}
+	if got, want := EpochMissingAttesters(0, blocks, committees), []phase0.ValidatorIndex{3, 4}; !slices.Equal(got, want) {
+		t.Errorf("epoch's own blocks: got %v missing, want %v", got, want)
+	}
+
+	blocks, err = WithNextEpochBlocks(ctx, client, 0, blocks, 2)
+	if err != nil {
+		t.Fatalf("WithNextEpochBlocks: %v", err)
+	}
+	if got, want := EpochMissingAttesters(0, blocks, committees), []phase0.ValidatorIndex{3}; !slices.Equal(got, want) {
+		t.Errorf("with the next epoch's blocks: got %v missing, want %v", got, want)
+	}
+}
+
+func TestParticipationRate(t *testing.T) {
+	committees := map[phase0.CommitteeIndex][]phase0.ValidatorIndex{
+		0: {10, 11, 12},
+		1: {20},
+		2: {},
+	}
+	sizes := map[phase0.CommitteeIndex]int{0: 3, 1: 1, 2: 0}
+	first := testutil.BuildAttestation(sizes, map[phase0.CommitteeIndex][]int{0: {0, 1}})
+	first.Data.Slot = 5
+	// Overlaps the first on validator 11, and covers the empty committee.
+	second := testutil.BuildAttestation(sizes, map[phase0.CommitteeIndex][]int{0: {1}, 2: nil})
+	second.Data.Slot = 5
+	other := testutil.BuildAttestation(sizes, map[phase0.CommitteeIndex][]int{1: {0}})
+	other.Data.Slot = 4
+
+	rate, attested, total, err := ParticipationRate(5, []*electra.Attestation{first, second, other}, committees)
+	if err != nil {
+		t.Fatalf("ParticipationRate: %v", err)
+	}
+	if attested != 2 || total != 4 || rate != 0.5 {
+		t.Errorf("got %d of %d at %v, want 2 of 4 at 0.5", attested, total, rate)
+	}
+
+	rate, attested, total, err = CommitteeParticipationRate(5, []*electra.Attestation{first, second}, committees, 2)
+	if err != nil || rate != 0 || attested != 0 || total != 0 {
+		t.Errorf("empty committee: got %v, %d of %d, %v, want 0 of 0", rate, attested, total, err)
+	}
+
+	rate, attested, total, err = ParticipationRate(5, nil, nil)
+	if err != nil || rate != 0 || attested != 0 || total != 0 {
+		t.Errorf("no committees: got %v, %d of %d, %v, want 0 of 0", rate, attested, total, err)
+	}
+}
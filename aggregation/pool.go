@@ -0,0 +1,261 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	eth2client "github.com/attestantio/go-eth2-client"
+	"github.com/attestantio/go-eth2-client/api"
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/prysmaticlabs/go-bitfield"
+	"github.com/rs/zerolog/log"
+)
+
+// CurrentSlot returns the slot the chain is at by the wall clock, worked out
+// from the beacon node's genesis time and SECONDS_PER_SLOT.
+func CurrentSlot(ctx context.Context, service BeaconClient) (phase0.Slot, error) {
+	genesis, err := fetchGenesis(ctx, service)
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := fetchSpec(ctx, service)
+	if err != nil {
+		return 0, err
+	}
+	secondsPerSlot, ok := data["SECONDS_PER_SLOT"].(time.Duration)
+	if !ok || secondsPerSlot <= 0 {
+		return 0, errors.New("spec has no usable SECONDS_PER_SLOT")
+	}
+
+	since := time.Since(genesis.GenesisTime)
+	if since < 0 {
+		return 0, nil
+	}
+	return phase0.Slot(since / secondsPerSlot), nil
+}
+
+// DEFAULT_SINGLE_ATTESTATION_WINDOW is how long CheckAttestationPool listens
+// for single attestations gossiped for the slot: the third of a slot by which
+// validators should have attested.
+const DEFAULT_SINGLE_ATTESTATION_WINDOW = 4 * time.Second
+
+// singleAttestationWindow is how long CheckAttestationPool listens for single
+// attestations.
+var singleAttestationWindow = DEFAULT_SINGLE_ATTESTATION_WINDOW
+
+// CheckAttestationPool fetches the attestations the beacon node holds in its
+// pool for slot and checks their aggregation bits against the committees for
+// the slots they attest to, catching malformed aggregates before any block
+// includes them. The pool only holds aggregates, so if the node provides
+// events the single attestations gossiped for slot over the next
+// DEFAULT_SINGLE_ATTESTATION_WINDOW are checked as well, with
+// CheckSingleAttestations. It returns the mismatches, all marked Pool, and the
+// number of attestations checked.
+func CheckAttestationPool(ctx context.Context, service BeaconClient, slot phase0.Slot) ([]Mismatch, int, error) {
+	provider, ok := service.(eth2client.AttestationPoolProvider)
+	if !ok {
+		return nil, 0, errors.New("beacon client does not provide the attestation pool")
+	}
+	gatherSingles := listenSingleAttestations(ctx, service, slot)
+	var attestations []*spec.VersionedAttestation
+	err := withRetry(ctx, retryAttempts, retryBaseDelay, func() error {
+		requestCtx, cancel := requestContext(ctx)
+		defer cancel()
+
+		resp, err := provider.AttestationPool(requestCtx, &api.AttestationPoolOpts{Slot: &slot})
+		if err != nil {
+			return err
+		}
+		attestations = resp.Data
+		return nil
+	})
+	if err != nil {
+		return nil, 0, contextError("fetching attestation pool", err)
+	}
+
+	// The slot filter should leave only attestations for slot, but the
+	// committees are fetched for whatever duty slots turn up.
+	data := make([]*phase0.AttestationData, 0, len(attestations))
+	var epochs []phase0.Epoch
+	for _, attestation := range attestations {
+		attestationData, err := attestation.Data()
+		if err != nil {
+			log.Error().Err(err).Uint64("slot", uint64(slot)).Msg("failed reading pool attestation data")
+			data = append(data, nil)
+			continue
+		}
+		data = append(data, attestationData)
+		epochs = append(epochs, SlotEpoch(attestationData.Slot))
+	}
+	singles := gatherSingles()
+	if len(singles) > 0 {
+		epochs = append(epochs, SlotEpoch(slot))
+	}
+	checked := len(attestations) + len(singles)
+	if len(epochs) == 0 {
+		return nil, checked, nil
+	}
+
+	committees, err := GetBeaconCommitees(ctx, service, slices.Min(epochs), slices.Max(epochs))
+	if err != nil {
+		// Attestations for the missing committees are reported as such.
+		log.Error().Err(err).Msg("failed fetching some beacon committees")
+	}
+	sizes := newCommitteeSizes(committees)
+
+	reports := make([]AttestationReport, 0, len(attestations))
+	for i, attestation := range attestations {
+		if data[i] == nil {
+			continue
+		}
+		report, err := checkAttestation(attestation, data[i], sizes)
+		if err != nil {
+			log.Error().Err(err).Uint64("slot", uint64(data[i].Slot)).Msg("failed reading pool attestation")
+			continue
+		}
+		reports = append(reports, report)
+	}
+
+	mismatches := reportMismatches(0, reports)
+	for i := range mismatches {
+		mismatches[i].Pool = true
+	}
+	mismatches = append(mismatches, CheckSingleAttestations(singles, committees)...)
+	return mismatches, checked, nil
+}
+
+// listenSingleAttestations subscribes to the single attestations the beacon
+// node gossips for slot. The function it returns waits out
+// singleAttestationWindow, or until ctx is done, and returns those received.
+// A node that does not provide events, or fails to subscribe, yields none.
+func listenSingleAttestations(ctx context.Context, service BeaconClient, slot phase0.Slot) func() []*electra.SingleAttestation {
+	none := func() []*electra.SingleAttestation { return nil }
+	provider, ok := service.(eth2client.EventsProvider)
+	if !ok {
+		return none
+	}
+
+	var mu sync.Mutex
+	var singles []*electra.SingleAttestation
+	listenCtx, cancel := context.WithTimeout(ctx, singleAttestationWindow)
+	err := provider.Events(listenCtx, &api.EventsOpts{
+		Topics: []string{"single_attestation"},
+		SingleAttestationHandler: func(_ context.Context, single *electra.SingleAttestation) {
+			if single.Data == nil || single.Data.Slot != slot {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			singles = append(singles, single)
+		},
+	})
+	if err != nil {
+		cancel()
+		log.Warn().Err(err).Msg("failed subscribing to single attestations, checking the pool alone")
+		return none
+	}
+	return func() []*electra.SingleAttestation {
+		<-listenCtx.Done()
+		cancel()
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(singles)
+	}
+}
+
+// ErrAttesterNotInCommittee is matched by an AttesterNotInCommitteeError.
+var ErrAttesterNotInCommittee = errors.New("attester not in committee")
+
+// AttesterNotInCommitteeError is a single attestation whose attester is not a
+// member of the committee it names.
+type AttesterNotInCommitteeError struct {
+	Slot     phase0.Slot
+	Index    phase0.CommitteeIndex
+	Attester phase0.ValidatorIndex
+}
+
+func (e *AttesterNotInCommitteeError) Error() string {
+	return fmt.Sprintf("validator %d is not in committee %d at slot %d", e.Attester, e.Index, e.Slot)
+}
+
+func (e *AttesterNotInCommitteeError) Is(target error) bool {
+	return target == ErrAttesterNotInCommittee
+}
+
+// NormalizeAttestation converts single, the Electra gossip form that names one
+// attester and one committee, into the aggregate form blocks and the pool
+// carry: the committee's bit set in the committee bits, and aggregation bits
+// as long as the committee with only the attester's position set. The result
+// goes through the same length and participation checks as any aggregate. It
+// returns an *UnknownCommitteeError if the committee does not exist at the
+// attestation's slot and an *AttesterNotInCommitteeError if the attester is
+// not in it.
+func NormalizeAttestation(single *electra.SingleAttestation, committees Committees) (*spec.VersionedAttestation, error) {
+	if single.Data == nil {
+		return nil, errors.New("single attestation has no data")
+	}
+	slot := single.Data.Slot
+	if !committees.Has(slot, single.CommitteeIndex) {
+		return nil, &UnknownCommitteeError{Slot: slot, Index: single.CommitteeIndex}
+	}
+	committee := committees.Validators(slot, single.CommitteeIndex)
+	position := slices.Index(committee, single.AttesterIndex)
+	if position < 0 {
+		return nil, &AttesterNotInCommitteeError{Slot: slot, Index: single.CommitteeIndex, Attester: single.AttesterIndex}
+	}
+
+	committeeBits := bitfield.NewBitvector64()
+	committeeBits.SetBitAt(uint64(single.CommitteeIndex), true)
+	aggregationBits := bitfield.NewBitlist(uint64(len(committee)))
+	aggregationBits.SetBitAt(uint64(position), true)
+	return &spec.VersionedAttestation{
+		Version: spec.DataVersionElectra,
+		Electra: &electra.Attestation{
+			AggregationBits: aggregationBits,
+			Data:            single.Data,
+			Signature:       single.Signature,
+			CommitteeBits:   committeeBits,
+		},
+	}, nil
+}
+
+// CheckSingleAttestations normalizes each of singles with NormalizeAttestation
+// and checks it as an aggregate against committees, returning the mismatches
+// marked Pool, as they are in no block. A single attestation that cannot be
+// normalized is reported as a mismatch carrying the reason.
+func CheckSingleAttestations(singles []*electra.SingleAttestation, committees Committees) []Mismatch {
+	sizes := newCommitteeSizes(committees)
+	var mismatches []Mismatch
+	for _, single := range singles {
+		attestation, err := NormalizeAttestation(single, committees)
+		if err != nil {
+			mismatch := Mismatch{
+				CommitteeIndices: []phase0.CommitteeIndex{single.CommitteeIndex},
+				Err:              err,
+				Pool:             true,
+			}
+			if single.Data != nil {
+				mismatch.DutySlot = single.Data.Slot
+			}
+			mismatches = append(mismatches, mismatch)
+			continue
+		}
+		report, err := checkAttestation(attestation, single.Data, sizes)
+		if err != nil {
+			log.Error().Err(err).Uint64("slot", uint64(single.Data.Slot)).Msg("failed reading single attestation")
+			continue
+		}
+		for _, mismatch := range reportMismatches(0, []AttestationReport{report}) {
+			mismatch.Pool = true
+			mismatches = append(mismatches, mismatch)
+		}
+	}
+	return mismatches
+}
@@ -0,0 +1,136 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+
+	"repro/internal/testutil"
+)
+
+func TestCheckAttestationPool(t *testing.T) {
+	defer func(previous time.Duration) { singleAttestationWindow = previous }(singleAttestationWindow)
+	singleAttestationWindow = time.Millisecond
+
+	client := testutil.NewFakeClient().
+		WithCommittee(40, 0, []phase0.ValidatorIndex{1, 2, 3}).
+		WithCommittee(40, 1, []phase0.ValidatorIndex{4, 5}).
+		WithPoolAttestations(
+			attestation(40, []uint64{0, 1}, 5),
+			attestation(40, []uint64{0, 1}, 4),
+			attestation(40, []uint64{2}, 3),
+			// Another slot's, so not fetched.
+			attestation(39, []uint64{0}, 100),
+		)
+
+	mismatches, checked, err := CheckAttestationPool(context.Background(), client, 40)
+	if err != nil {
+		t.Fatalf("CheckAttestationPool: %v", err)
+	}
+	if checked != 3 {
+		t.Errorf("checked %d attestations, want 3", checked)
+	}
+	if len(mismatches) != 2 {
+		t.Fatalf("got %d mismatches, want 2: %+v", len(mismatches), mismatches)
+	}
+	for _, mismatch := range mismatches {
+		if !mismatch.Pool || mismatch.DutySlot != 40 {
+			t.Errorf("unexpected mismatch %+v", mismatch)
+		}
+	}
+	if got := mismatches[0]; got.Computed != 5 || got.Actual != 4 || got.Err != nil {
+		t.Errorf("got mismatch %+v, want length 4 against 5", got)
+	}
+	if got := mismatches[1]; got.Err == nil {
+		t.Errorf("got mismatch %+v, want an unknown committee error", got)
+	}
+}
+
+func TestCheckAttestationPoolSingleAttestations(t *testing.T) {
+	defer func(previous time.Duration) { singleAttestationWindow = previous }(singleAttestationWindow)
+	singleAttestationWindow = 50 * time.Millisecond
+
+	single := func(slot phase0.Slot, index phase0.CommitteeIndex, attester phase0.ValidatorIndex) *electra.SingleAttestation {
+		return &electra.SingleAttestation{CommitteeIndex: index, AttesterIndex: attester, Data: &phase0.AttestationData{Slot: slot}}
+	}
+	client := testutil.NewFakeClient().
+		WithCommittee(40, 0, []phase0.ValidatorIndex{1, 2, 3}).
+		WithPoolAttestations(attestation(40, []uint64{0}, 3)).
+		WithSingleAttestations(
+			single(40, 0, 2),
+			single(40, 0, 4),
+			single(40, 3, 1),
+			// Another slot's, so left out.
+			single(39, 7, 1),
+		)
+
+	mismatches, checked, err := CheckAttestationPool(context.Background(), client, 40)
+	if err != nil {
+		t.Fatalf("CheckAttestationPool: %v", err)
+	}
+	if checked != 4 {
+		t.Errorf("checked %d attestations, want the aggregate and 3 single attestations", checked)
+	}
+	if len(mismatches) != 2 || !errors.Is(mismatches[0].Err, ErrAttesterNotInCommittee) || !errors.Is(mismatches[1].Err, ErrUnknownCommittee) {
+		t.Fatalf("got mismatches %+v, want the stranger and the unknown committee", mismatches)
+	}
+	for _, mismatch := range mismatches {
+		if !mismatch.Pool || mismatch.DutySlot != 40 {
+			t.Errorf("unexpected mismatch %+v", mismatch)
+		}
+	}
+}
+
+func TestCurrentSlot(t *testing.T) {
+	client := testutil.NewFakeClient().WithGenesisTime(time.Now().Add(-25 * time.Second))
+
+	slot, err := CurrentSlot(context.Background(), client)
+	if err != nil {
+		t.Fatalf("CurrentSlot: %v", err)
+	}
+	if slot != 2 {
+		t.Errorf("got slot %d, want 2", slot)
+	}
+}
+
+func TestNormalizeAttestation(t *testing.T) {
+	committees := Committees{1: {
+		0: {1, 2, 3},
+		2: {4, 5},
+	}}
+	single := &electra.SingleAttestation{
+		CommitteeIndex: 2,
+		AttesterIndex:  5,
+		Data:           &phase0.AttestationData{Slot: 1},
+	}
+
+	attestation, err := NormalizeAttestation(single, committees)
+	if err != nil {
+		t.Fatalf("NormalizeAttestation: %v", err)
+	}
+	if got := FormatBitlist(attestation.Electra.AggregationBits); got != "[1] len=2 set=1" {
+		t.Errorf("got aggregation bits %s", got)
+	}
+	validators, err := AttestingValidators(attestation.Electra, committees[1])
+	if err != nil || len(validators) != 1 || validators[0] != 5 {
+		t.Errorf("got attesters %v and error %v, want [5]", validators, err)
+	}
+
+	stranger := &electra.SingleAttestation{CommitteeIndex: 0, AttesterIndex: 5, Data: &phase0.AttestationData{Slot: 1}}
+	if _, err := NormalizeAttestation(stranger, committees); !errors.Is(err, ErrAttesterNotInCommittee) {
+		t.Errorf("got error %v, want %v", err, ErrAttesterNotInCommittee)
+	}
+	unknown := &electra.SingleAttestation{CommitteeIndex: 1, AttesterIndex: 5, Data: &phase0.AttestationData{Slot: 1}}
+	if _, err := NormalizeAttestation(unknown, committees); !errors.Is(err, ErrUnknownCommittee) {
+		t.Errorf("got error %v, want %v", err, ErrUnknownCommittee)
+	}
+
+	mismatches := CheckSingleAttestations([]*electra.SingleAttestation{single, stranger, unknown}, committees)
+	if len(mismatches) != 2 || !mismatches[0].Pool || !errors.Is(mismatches[0].Err, ErrAttesterNotInCommittee) || !errors.Is(mismatches[1].Err, ErrUnknownCommittee) {
+		t.Errorf("got mismatches %+v, want the stranger and the unknown committee", mismatches)
+	}
+}
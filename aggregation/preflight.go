@@ -0,0 +1,72 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	eth2client "github.com/attestantio/go-eth2-client"
+	"github.com/attestantio/go-eth2-client/api"
+	apiv1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog/log"
+)
+
+// DEFAULT_MAX_SYNC_DISTANCE is how many slots the beacon node's head may lag
+// behind the chain before PreflightCheck refuses to start.
+const DEFAULT_MAX_SYNC_DISTANCE = 64
+
+// ElectraForkEpoch returns ELECTRA_FORK_EPOCH from the beacon node's spec.
+func ElectraForkEpoch(ctx context.Context, service BeaconClient) (phase0.Epoch, error) {
+	data, err := fetchSpec(ctx, service)
+	if err != nil {
+		return 0, err
+	}
+	value, ok := data["ELECTRA_FORK_EPOCH"].(uint64)
+	if !ok {
+		return 0, errors.New("spec has no ELECTRA_FORK_EPOCH")
+	}
+	return phase0.Epoch(value), nil
+}
+
+// PreflightCheck makes sure the beacon node is synced before any analysis
+// starts, and warns if epoch predates the Electra fork. Its requests are
+// retried on transient failures so that a blip does not abort the run.
+func PreflightCheck(ctx context.Context, service BeaconClient, epoch phase0.Epoch) error {
+	provider, ok := service.(eth2client.NodeSyncingProvider)
+	if !ok {
+		return errors.New("beacon client does not provide sync status")
+	}
+
+	var resp *api.Response[*apiv1.SyncState]
+	err := withRetry(ctx, retryAttempts, retryBaseDelay, func() error {
+		requestCtx, cancel := requestContext(ctx)
+		defer cancel()
+
+		var err error
+		resp, err = provider.NodeSyncing(requestCtx, &api.NodeSyncingOpts{})
+		return err
+	})
+	if err != nil {
+		return contextError("fetching sync status", err)
+	}
+	if resp == nil || resp.Data == nil {
+		return errors.New("no sync status returned")
+	}
+	if resp.Data.IsSyncing {
+		return fmt.Errorf("beacon node is syncing (head slot %d, %d slots behind); wait for it to catch up", resp.Data.HeadSlot, resp.Data.SyncDistance)
+	}
+	if resp.Data.SyncDistance > DEFAULT_MAX_SYNC_DISTANCE {
+		return fmt.Errorf("beacon node head slot %d is %d slots behind the chain", resp.Data.HeadSlot, resp.Data.SyncDistance)
+	}
+
+	forkEpoch, err := ElectraForkEpoch(ctx, service)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed checking the Electra fork epoch")
+		return nil
+	}
+	if epoch < forkEpoch {
+		log.Warn().Uint64("epoch", uint64(epoch)).Uint64("electra_fork_epoch", uint64(forkEpoch)).Msg("requested epoch predates the Electra fork; only the aggregation length check supports earlier forks")
+	}
+	return nil
+}
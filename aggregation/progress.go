@@ -0,0 +1,66 @@
+package aggregation
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DEFAULT_PROGRESS_INTERVAL is how often a range scan reports progress.
+	DEFAULT_PROGRESS_INTERVAL = 10 * time.Second
+
+	// DEFAULT_PROGRESS_WINDOW is how many recent epochs the ETA is averaged
+	// over.
+	DEFAULT_PROGRESS_WINDOW = 10
+)
+
+// rangeProgress tracks a range scan and periodically logs how far it got.
+type rangeProgress struct {
+	total      int
+	done       int
+	mismatches int
+	recent     []time.Duration
+	started    time.Time
+	reported   time.Time
+}
+
+func newRangeProgress(total int) *rangeProgress {
+	now := time.Now()
+	return &rangeProgress{total: total, started: now, reported: now}
+}
+
+// epochDone records an epoch that took took to process, and logs progress if
+// DEFAULT_PROGRESS_INTERVAL has passed since the last report.
+func (p *rangeProgress) epochDone(took time.Duration, mismatches int) {
+	p.done++
+	p.mismatches += mismatches
+	p.recent = append(p.recent, took)
+	if len(p.recent) > DEFAULT_PROGRESS_WINDOW {
+		p.recent = p.recent[1:]
+	}
+
+	if time.Since(p.reported) < DEFAULT_PROGRESS_INTERVAL || p.done == p.total {
+		return
+	}
+	p.reported = time.Now()
+	log.Info().Dur("eta", p.eta()).Msgf("processed %d/%d epochs, %d mismatches so far", p.done, p.total, p.mismatches)
+}
+
+// epochSkipped records an epoch that needed no processing. It does not count
+// towards the ETA.
+func (p *rangeProgress) epochSkipped() {
+	p.done++
+}
+
+// eta estimates the time left from the average of the recent epochs.
+func (p *rangeProgress) eta() time.Duration {
+	if len(p.recent) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, took := range p.recent {
+		sum += took
+	}
+	return sum / time.Duration(len(p.recent)) * time.Duration(p.total-p.done)
+}
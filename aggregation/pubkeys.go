@@ -0,0 +1,127 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	eth2client "github.com/attestantio/go-eth2-client"
+	"github.com/attestantio/go-eth2-client/api"
+	apiv1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+)
+
+// DEFAULT_PUBKEY_BATCH_SIZE is how many validators PubkeyResolver looks up per
+// request.
+const DEFAULT_PUBKEY_BATCH_SIZE = 1000
+
+// PubkeyResolver looks up validator pubkeys by index, in batches, remembering
+// every pubkey it has seen. A validator's pubkey never changes once it has an
+// index, so the head state serves them all and the cache never goes stale. It
+// is safe for concurrent use.
+type PubkeyResolver struct {
+	provider eth2client.ValidatorsProvider
+
+	mu      sync.Mutex
+	pubkeys map[phase0.ValidatorIndex]phase0.BLSPubKey
+}
+
+// NewPubkeyResolver returns a resolver using service, which must provide
+// validators.
+func NewPubkeyResolver(service BeaconClient) (*PubkeyResolver, error) {
+	provider, ok := service.(eth2client.ValidatorsProvider)
+	if !ok {
+		return nil, errors.New("beacon client does not provide validators")
+	}
+	return &PubkeyResolver{
+		provider: provider,
+		pubkeys:  make(map[phase0.ValidatorIndex]phase0.BLSPubKey),
+	}, nil
+}
+
+// Resolve returns the pubkeys of validators, fetching those not yet cached
+// DEFAULT_PUBKEY_BATCH_SIZE at a time. Validators the beacon node does not
+// know are left out.
+func (r *PubkeyResolver) Resolve(ctx context.Context, validators []phase0.ValidatorIndex) (map[phase0.ValidatorIndex]phase0.BLSPubKey, error) {
+	r.mu.Lock()
+	var uncached []phase0.ValidatorIndex
+	for _, validator := range validators {
+		if _, ok := r.pubkeys[validator]; !ok {
+			uncached = append(uncached, validator)
+		}
+	}
+	r.mu.Unlock()
+	slices.Sort(uncached)
+	uncached = slices.Compact(uncached)
+
+	for batch := range slices.Chunk(uncached, DEFAULT_PUBKEY_BATCH_SIZE) {
+		var data map[phase0.ValidatorIndex]*apiv1.Validator
+		err := withRetry(ctx, retryAttempts, retryBaseDelay, func() error {
+			requestCtx, cancel := requestContext(ctx)
+			defer cancel()
+
+			resp, err := r.provider.Validators(requestCtx, &api.ValidatorsOpts{State: "head", Indices: batch})
+			if err != nil {
+				return err
+			}
+			data = resp.Data
+			return nil
+		})
+		if err != nil {
+			return nil, contextError(fmt.Sprintf("fetching %d validators", len(batch)), err)
+		}
+
+		r.mu.Lock()
+		for index, validator := range data {
+			if validator != nil && validator.Validator != nil {
+				r.pubkeys[index] = validator.Validator.PublicKey
+			}
+		}
+		r.mu.Unlock()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pubkeys := make(map[phase0.ValidatorIndex]phase0.BLSPubKey, len(validators))
+	for _, validator := range validators {
+		if pubkey, ok := r.pubkeys[validator]; ok {
+			pubkeys[validator] = pubkey
+		}
+	}
+	return pubkeys, nil
+}
+
+// AddAttesterPubkeys fills in the AttesterPubkeys of each report from its
+// Attesters, resolving them all in one go.
+func AddAttesterPubkeys(ctx context.Context, resolver *PubkeyResolver, reports []BlockAttestationReport) error {
+	var validators []phase0.ValidatorIndex
+	for _, report := range reports {
+		validators = append(validators, report.Attesters...)
+	}
+	pubkeys, err := resolver.Resolve(ctx, validators)
+	if err != nil {
+		return err
+	}
+	for i := range reports {
+		if len(reports[i].Attesters) == 0 {
+			continue
+		}
+		reports[i].AttesterPubkeys = make([]string, 0, len(reports[i].Attesters))
+		for _, validator := range reports[i].Attesters {
+			reports[i].AttesterPubkeys = append(reports[i].AttesterPubkeys, formatPubkey(pubkeys, validator))
+		}
+	}
+	return nil
+}
+
+// formatPubkey renders the pubkey of validator, or "unknown" if pubkeys does
+// not have it.
+func formatPubkey(pubkeys map[phase0.ValidatorIndex]phase0.BLSPubKey, validator phase0.ValidatorIndex) string {
+	pubkey, ok := pubkeys[validator]
+	if !ok {
+		return "unknown"
+	}
+	return pubkey.String()
+}
@@ -0,0 +1,72 @@
+package aggregation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+
+	"repro/internal/testutil"
+)
+
+func TestPubkeyResolverBatchesAndCaches(t *testing.T) {
+	client := testutil.NewFakeClient()
+	validators := make([]phase0.ValidatorIndex, 0, 2*DEFAULT_PUBKEY_BATCH_SIZE+1)
+	for index := range phase0.ValidatorIndex(2*DEFAULT_PUBKEY_BATCH_SIZE + 1) {
+		client.WithValidator(index, phase0.BLSPubKey{byte(index), byte(index >> 8)})
+		validators = append(validators, index)
+	}
+	resolver, err := NewPubkeyResolver(client)
+	if err != nil {
+		t.Fatalf("NewPubkeyResolver: %v", err)
+	}
+
+	pubkeys, err := resolver.Resolve(context.Background(), append(validators, 0, 1))
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if len(pubkeys) != len(validators) {
+		t.Errorf("got %d pubkeys, want %d", len(pubkeys), len(validators))
+	}
+	if got, want := pubkeys[1000], (phase0.BLSPubKey{0xe8, 0x03}); got != want {
+		t.Errorf("got pubkey %s for validator 1000, want %s", got, want)
+	}
+	if got := client.ValidatorRequests(); got != 3 {
+		t.Errorf("made %d requests, want 3", got)
+	}
+
+	// Validators already seen come from the cache; unknown ones are left out.
+	pubkeys, err = resolver.Resolve(context.Background(), []phase0.ValidatorIndex{5, 999999})
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if _, ok := pubkeys[999999]; ok || len(pubkeys) != 1 {
+		t.Errorf("got pubkeys %v, want only validator 5", pubkeys)
+	}
+	if got := client.ValidatorRequests(); got != 4 {
+		t.Errorf("made %d requests, want 4", got)
+	}
+}
+
+func TestAddAttesterPubkeys(t *testing.T) {
+	client := testutil.NewFakeClient().WithValidator(7, phase0.BLSPubKey{0x07})
+	resolver, err := NewPubkeyResolver(client)
+	if err != nil {
+		t.Fatalf("NewPubkeyResolver: %v", err)
+	}
+	reports := []BlockAttestationReport{
+		{Attesters: []phase0.ValidatorIndex{7, 8}},
+		{},
+	}
+
+	if err := AddAttesterPubkeys(context.Background(), resolver, reports); err != nil {
+		t.Fatalf("AddAttesterPubkeys: %v", err)
+	}
+	got := reports[0].AttesterPubkeys
+	if len(got) != 2 || got[0] != (phase0.BLSPubKey{0x07}).String() || got[1] != "unknown" {
+		t.Errorf("got pubkeys %v", got)
+	}
+	if reports[1].AttesterPubkeys != nil {
+		t.Errorf("got pubkeys %v for a slot without attesters", reports[1].AttesterPubkeys)
+	}
+}
@@ -0,0 +1,92 @@
+package aggregation
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	apiv1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog/log"
+)
+
+// LoadReplay reads every fixture written to dir by CaptureMismatches, so the
+// analysis can be rerun without a beacon node. The committees of all fixtures
+// are merged; a committee captured twice with different members is an error.
+func LoadReplay(dir string) (map[phase0.Slot]*spec.VersionedSignedBeaconBlock, Committees, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "block-*.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(paths) == 0 {
+		return nil, nil, fmt.Errorf("no fixtures in %s", dir)
+	}
+
+	blocks := make(map[phase0.Slot]*spec.VersionedSignedBeaconBlock, len(paths))
+	var list []*apiv1.BeaconCommittee
+	for _, path := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "block-"), ".json")
+		slot, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid fixture name %s: expected block-<slot>.json", path)
+		}
+		block, committees, err := LoadFixture(dir, phase0.Slot(slot))
+		if err != nil {
+			return nil, nil, err
+		}
+		if block.Message == nil || block.Message.Slot != phase0.Slot(slot) {
+			return nil, nil, fmt.Errorf("fixture %s does not hold the block for slot %d", path, slot)
+		}
+		blocks[phase0.Slot(slot)] = &spec.VersionedSignedBeaconBlock{
+			Version: spec.DataVersionElectra,
+			Electra: block,
+		}
+		list = append(list, committees...)
+	}
+
+	committees, err := committeesFromList(list)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fixtures in %s: %w", dir, err)
+	}
+	return blocks, committees, nil
+}
+
+// ReplayResults checks the attestations of blocks loaded by LoadReplay and
+// groups the blocks and mismatches into one result per epoch of block slots,
+// in epoch order, for WriteRangeSummary. A fixture captured from a range scan
+// holds committees for its block's duty slot, and one captured from
+// --block-id for every slot its mismatches attest to, so each block's
+// attestations are checked for whichever slots have captured committees.
+func ReplayResults(blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, committees Committees) []EpochResult {
+	byEpoch := make(map[phase0.Epoch]*EpochResult)
+	for slot := range blocks {
+		epoch := SlotEpoch(slot)
+		if byEpoch[epoch] == nil {
+			byEpoch[epoch] = &EpochResult{Epoch: epoch}
+		}
+		byEpoch[epoch].Blocks++
+	}
+	sizes := newCommitteeSizes(committees)
+	for _, slot := range slices.Sorted(maps.Keys(blocks)) {
+		blockSlot, reports, err := checkAttestations(blocks[slot], sizes, false)
+		if err != nil {
+			log.Error().Err(err).Uint64("slot", uint64(slot)).Msg("failed reading block")
+			continue
+		}
+		reports = slices.DeleteFunc(reports, func(report AttestationReport) bool {
+			return sizes[report.slot] == nil
+		})
+		result := byEpoch[SlotEpoch(slot)]
+		result.Mismatches = append(result.Mismatches, reportMismatches(blockSlot, reports)...)
+	}
+
+	results := make([]EpochResult, 0, len(byEpoch))
+	for _, epoch := range slices.Sorted(maps.Keys(byEpoch)) {
+		results = append(results, *byEpoch[epoch])
+	}
+	return results
+}
@@ -0,0 +1,130 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+
+	"repro/internal/testutil"
+)
+
+func TestLoadReplay(t *testing.T) {
+	ctx := context.Background()
+	client := testutil.NewFakeClient().
+		WithCommittee(3, 0, []phase0.ValidatorIndex{1, 2, 3}).
+		WithCommittee(3, 1, []phase0.ValidatorIndex{4, 5}).
+		WithCommittee(4, 0, []phase0.ValidatorIndex{8, 9}).
+		WithCommittee(40, 0, []phase0.ValidatorIndex{6, 7}).
+		WithSignedBlock(fullBlock(4, attestation(3, []uint64{0, 1}, 4))).
+		WithSignedBlock(fullBlock(5, attestation(4, []uint64{0}, 1))).
+		WithSignedBlock(fullBlock(41, attestation(40, []uint64{0}, 2), attestation(40, []uint64{0}, 3)))
+	blocks := make(map[phase0.Slot]*spec.VersionedSignedBeaconBlock)
+	for _, slot := range []phase0.Slot{4, 5, 41} {
+		block, err := GetBlock(ctx, client, slot)
+		if err != nil {
+			t.Fatalf("GetBlock: %v", err)
+		}
+		blocks[slot] = block
+	}
+	committees, err := GetBeaconCommitees(ctx, client, 0, 1)
+	if err != nil {
+		t.Fatalf("GetBeaconCommitees: %v", err)
+	}
+	dir := t.TempDir()
+	CaptureMismatches(ctx, client, dir, blocks, committees, FindAggregationMismatches(blocks, committees))
+
+	replayBlocks, replayCommittees, err := LoadReplay(dir)
+	if err != nil {
+		t.Fatalf("LoadReplay: %v", err)
+	}
+	if len(replayBlocks) != 3 {
+		t.Errorf("got %d blocks, want 3", len(replayBlocks))
+	}
+	for slot, want := range map[phase0.Slot]int{3: 5, 4: 2, 40: 2} {
+		if got := replayCommittees.Size(slot); got != want {
+			t.Errorf("slot %d: got committee size %d, want %d", slot, got, want)
+		}
+	}
+
+	results := ReplayResults(replayBlocks, replayCommittees)
+	if len(results) != 2 {
+		t.Fatalf("got %d epochs, want 2: %+v", len(results), results)
+	}
+	if results[0].Epoch != 0 || results[0].Blocks != 2 || len(results[0].Mismatches) != 2 {
+		t.Errorf("epoch 0: got %d blocks and %d mismatches, want 2 and 2", results[0].Blocks, len(results[0].Mismatches))
+	}
+	if results[1].Epoch != 1 || results[1].Blocks != 1 || len(results[1].Mismatches) != 1 || results[1].Mismatches[0].Direction() != "overshoot" {
+		t.Errorf("epoch 1: got %+v, want one block with one overshoot", results[1])
+	}
+}
+
+func TestReplayBlockCapture(t *testing.T) {
+	ctx := context.Background()
+	// A --block-id check covers every slot the block's attestations are for,
+	// and its fixture only holds the committees of the mismatching ones.
+	client := testutil.NewFakeClient().
+		WithCommittee(5, 0, []phase0.ValidatorIndex{1, 2, 3}).
+		WithCommittee(9, 0, []phase0.ValidatorIndex{4, 5}).
+		WithSignedBlock(fullBlock(10, attestation(5, []uint64{0}, 2), attestation(9, []uint64{0}, 2)))
+	block, err := GetBlock(ctx, client, 10)
+	if err != nil {
+		t.Fatalf("GetBlock: %v", err)
+	}
+	committees, err := GetBeaconCommitees(ctx, client, 0, 0)
+	if err != nil {
+		t.Fatalf("GetBeaconCommitees: %v", err)
+	}
+	mismatches, err := CheckBlock(block, committees)
+	if err != nil || len(mismatches) != 1 {
+		t.Fatalf("CheckBlock: got %d mismatches and %v, want 1", len(mismatches), err)
+	}
+	dir := t.TempDir()
+	CaptureMismatches(ctx, client, dir, map[phase0.Slot]*spec.VersionedSignedBeaconBlock{10: block}, committees, mismatches)
+
+	replayBlocks, replayCommittees, err := LoadReplay(dir)
+	if err != nil {
+		t.Fatalf("LoadReplay: %v", err)
+	}
+	results := ReplayResults(replayBlocks, replayCommittees)
+	if len(results) != 1 || len(results[0].Mismatches) != 1 {
+		t.Fatalf("got %+v, want one epoch with one mismatch", results)
+	}
+	if got := results[0].Mismatches[0]; got.DutySlot != 5 || got.Err != nil || got.Computed != 3 || got.Actual != 2 {
+		t.Errorf("got mismatch %+v, want slot 5 computed 3 actual 2", got)
+	}
+}
+
+func TestLoadReplayErrors(t *testing.T) {
+	if _, _, err := LoadReplay(t.TempDir()); err == nil {
+		t.Error("got no error for a directory without fixtures")
+	}
+
+	// The same committee captured with different members by two fixtures.
+	dir := t.TempDir()
+	for slot, validators := range map[phase0.Slot][]phase0.ValidatorIndex{4: {1, 2}, 5: {1, 3}} {
+		block := &spec.VersionedSignedBeaconBlock{
+			Version: spec.DataVersionElectra,
+			Electra: fullBlock(slot, attestation(3, []uint64{0}, 2)),
+		}
+		committees := Committees{3: {0: validators}}
+		if err := captureFixture(dir, slot, block, committees, []phase0.Slot{3}); err != nil {
+			t.Fatalf("captureFixture: %v", err)
+		}
+	}
+	if _, _, err := LoadReplay(dir); !errors.Is(err, ErrDuplicateCommittee) {
+		t.Errorf("got error %v, want %v", err, ErrDuplicateCommittee)
+	}
+
+	dir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "block-six.json"), []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := LoadReplay(dir); err == nil {
+		t.Error("got no error for a fixture not named by slot")
+	}
+}
@@ -0,0 +1,152 @@
+package aggregation
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog/log"
+)
+
+// BlockAttestationReport is the aggregation bits analysis of one slot of an
+// epoch, as emitted by --output json.
+type BlockAttestationReport struct {
+	BlockSlot               phase0.Slot         `json:"block_slot"`
+	DutySlot                phase0.Slot         `json:"duty_slot"`
+	Missed                  bool                `json:"missed"`
+	ExpectedCommitteeLength uint64              `json:"expected_committee_length"`
+	Attestations            []AttestationReport `json:"attestations"`
+	Mismatch                bool                `json:"mismatch"`
+	// Attesters is only filled in by AddAttesters.
+	Attesters []phase0.ValidatorIndex `json:"attesters,omitempty"`
+	// AttesterPubkeys is the pubkey of each of Attesters, or "unknown", and is
+	// only filled in by AddAttesterPubkeys.
+	AttesterPubkeys []string `json:"attester_pubkeys,omitempty"`
+}
+
+// BuildBlockReports produces a report for every slot of epoch, in slot order,
+// including missed slots.
+func BuildBlockReports(epoch phase0.Epoch, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, committees Committees) []BlockAttestationReport {
+	reports := make([]BlockAttestationReport, 0, slotsPerEpoch)
+	sizes := newCommitteeSizes(committees)
+	for slot := EpochLowestSlot(epoch); slot <= EpochHighestSlot(epoch); slot++ {
+		report := BlockAttestationReport{
+			BlockSlot:               slot,
+			DutySlot:                slot - 1,
+			ExpectedCommitteeLength: sizes.total(slot - 1),
+			Attestations:            []AttestationReport{},
+		}
+
+		block, ok := blocks[slot]
+		if !ok {
+			report.Missed = true
+			reports = append(reports, report)
+			continue
+		}
+
+		_, attestations, err := checkBlockAttestations(block, sizes)
+		if err != nil {
+			log.Error().Err(err).Uint64("slot", uint64(slot)).Msg("failed reading block")
+		}
+		for _, attestation := range attestations {
+			report.Attestations = append(report.Attestations, attestation)
+			report.Mismatch = report.Mismatch || attestation.Mismatch
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// AddAttesters fills in the Attesters of each report: the validators that
+// attested to its duty slot in any aggregate included in blocks, deduplicated
+// and sorted ascending.
+func AddAttesters(reports []BlockAttestationReport, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, committees Committees) {
+	byDutySlot := GatherAttestationsByDutySlot(blocks)
+	for i := range reports {
+		attesters, err := DutySlotAttesters(attestationsOf(byDutySlot[reports[i].DutySlot]), committees[reports[i].DutySlot])
+		if err != nil {
+			log.Error().Err(err).Uint64("slot", uint64(reports[i].DutySlot)).Msg("failed decoding attesters")
+			continue
+		}
+		reports[i].Attesters = attesters
+	}
+}
+
+// WriteJSONLReports writes each of reports to w as a JSON object on its own
+// line. If w has a Flush method it is called after every record so consumers
+// see results as they are produced.
+func WriteJSONLReports(w io.Writer, reports []BlockAttestationReport) error {
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(interface{ Flush() error })
+	for _, report := range reports {
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+		if flusher != nil {
+			if err := flusher.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteJSONReports writes reports to w as a single JSON array.
+func WriteJSONReports(w io.Writer, reports []BlockAttestationReport) error {
+	return json.NewEncoder(w).Encode(reports)
+}
+
+// WriteCSVReports writes one row per attestation in reports to w, with a
+// header row. Committee indices are joined into a single field.
+func WriteCSVReports(w io.Writer, epoch phase0.Epoch, reports []BlockAttestationReport) error {
+	return NewCSVReportWriter(w).Write(epoch, reports)
+}
+
+// CSVReportWriter writes the reports of several epochs as one CSV table, as
+// WriteCSVReports does for one, with the header row before the first.
+type CSVReportWriter struct {
+	cw          *csv.Writer
+	wroteHeader bool
+}
+
+// NewCSVReportWriter returns a CSVReportWriter writing to w.
+func NewCSVReportWriter(w io.Writer) *CSVReportWriter {
+	return &CSVReportWriter{cw: csv.NewWriter(w)}
+}
+
+// Write writes one row per attestation in reports, the reports of epoch, and
+// flushes them.
+func (c *CSVReportWriter) Write(epoch phase0.Epoch, reports []BlockAttestationReport) error {
+	if !c.wroteHeader {
+		if err := c.cw.Write([]string{"epoch", "block_slot", "duty_slot", "committee_indices", "computed_len", "actual_len", "mismatch", "attester_count"}); err != nil {
+			return err
+		}
+		c.wroteHeader = true
+	}
+	for _, report := range reports {
+		for _, attestation := range report.Attestations {
+			indices := make([]string, 0, len(attestation.CommitteeIndices))
+			for _, index := range attestation.CommitteeIndices {
+				indices = append(indices, strconv.FormatUint(uint64(index), 10))
+			}
+			if err := c.cw.Write([]string{
+				strconv.FormatUint(uint64(epoch), 10),
+				strconv.FormatUint(uint64(report.BlockSlot), 10),
+				strconv.FormatUint(uint64(report.DutySlot), 10),
+				strings.Join(indices, ","),
+				strconv.FormatUint(attestation.ExpectedLength, 10),
+				strconv.FormatUint(attestation.ActualLength, 10),
+				strconv.FormatBool(attestation.Mismatch),
+				strconv.FormatUint(attestation.AttesterCount, 10),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	c.cw.Flush()
+	return c.cw.Error()
+}
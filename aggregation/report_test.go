@@ -0,0 +1,40 @@
+package aggregation
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+)
+
+func TestCSVReportWriterAcrossEpochs(t *testing.T) {
+	reports := func(blockSlot phase0.Slot) []BlockAttestationReport {
+		return []BlockAttestationReport{{
+			BlockSlot: blockSlot,
+			DutySlot:  blockSlot - 1,
+			Attestations: []AttestationReport{
+				{CommitteeIndices: []phase0.CommitteeIndex{0, 3}, ExpectedLength: 5, ActualLength: 6, Mismatch: true, AttesterCount: 2},
+			},
+		}}
+	}
+
+	var buf bytes.Buffer
+	w := NewCSVReportWriter(&buf)
+	for epoch, blockSlot := range []phase0.Slot{1, 33} {
+		if err := w.Write(phase0.Epoch(epoch), reports(blockSlot)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("reading CSV: %v", err)
+	}
+	if len(rows) != 3 || rows[0][0] != "epoch" {
+		t.Fatalf("got rows %q, want a single header and one row per epoch", rows)
+	}
+	if got := rows[2]; got[0] != "1" || got[1] != "33" || got[3] != "0,3" || got[6] != "true" {
+		t.Errorf("got row %q for epoch 1", got)
+	}
+}
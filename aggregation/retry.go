@@ -0,0 +1,92 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/attestantio/go-eth2-client/api"
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	DEFAULT_MAX_ATTEMPTS = 3
+	DEFAULT_BASE_DELAY   = 500 * time.Millisecond
+)
+
+// retryAttempts and retryBaseDelay are the retry parameters used for every
+// beacon node request this package retries.
+var (
+	retryAttempts  = DEFAULT_MAX_ATTEMPTS
+	retryBaseDelay = DEFAULT_BASE_DELAY
+)
+
+// SetRetry sets how many times a beacon node request is attempted and the
+// delay before the first retry, which doubles with each further attempt.
+func SetRetry(maxAttempts int, baseDelay time.Duration) {
+	retryAttempts = maxAttempts
+	retryBaseDelay = baseDelay
+}
+
+// isTransient reports whether err is worth retrying: timeouts, dropped
+// connections and server-side (5xx/429) failures. A 404 means the slot was
+// missed and retrying will not change that.
+func isTransient(err error) bool {
+	var apiErr *api.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError || apiErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// withRetry calls fn until it succeeds, returns a non-transient error, or
+// maxAttempts is reached. The delay between attempts doubles from baseDelay,
+// plus up to the same amount again in jitter. Retrying stops as soon as ctx is
+// done.
+func withRetry(ctx context.Context, maxAttempts int, baseDelay time.Duration, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || !isTransient(err) || attempt >= maxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		delay := baseDelay << (attempt - 1)
+		if delay > 0 {
+			delay += rand.N(delay)
+		}
+		log.Debug().Err(err).Int("attempt", attempt).Dur("delay", delay).Msg("retrying after transient error")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// GetBlockWithRetry is GetBlock retried on transient errors.
+func GetBlockWithRetry(ctx context.Context, service BeaconClient, slot phase0.Slot, maxAttempts int, baseDelay time.Duration) (*spec.VersionedSignedBeaconBlock, error) {
+	var block *spec.VersionedSignedBeaconBlock
+	err := withRetry(ctx, maxAttempts, baseDelay, func() error {
+		var err error
+		block, err = GetBlock(ctx, service, slot)
+		return err
+	})
+	return block, err
+}
@@ -0,0 +1,93 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/attestantio/go-eth2-client/api"
+
+	"repro/internal/testutil"
+)
+
+func TestIsTransient(t *testing.T) {
+	status := func(code int) error {
+		return fmt.Errorf("fetching block: %w", &api.Error{Method: http.MethodGet, StatusCode: code})
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "500", err: status(http.StatusInternalServerError), want: true},
+		{name: "503", err: status(http.StatusServiceUnavailable), want: true},
+		{name: "429", err: status(http.StatusTooManyRequests), want: true},
+		{name: "404", err: status(http.StatusNotFound)},
+		{name: "400", err: status(http.StatusBadRequest)},
+		{name: "deadline", err: context.DeadlineExceeded, want: true},
+		{name: "connection reset", err: fmt.Errorf("read: %w", syscall.ECONNRESET), want: true},
+		{name: "cancelled", err: context.Canceled},
+		{name: "other", err: errors.New("boom")},
+	}
+	for _, tt := range tests {
+		if got := isTransient(tt.err); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetBlockWithRetryBacksOff(t *testing.T) {
+	client := testutil.NewFakeClient().WithBlock(1).WithBlockFailure(1, http.StatusInternalServerError)
+	start := time.Now()
+	if _, err := GetBlockWithRetry(context.Background(), client, 1, 3, 10*time.Millisecond); err == nil {
+		t.Fatal("GetBlockWithRetry: got no error, want the 500")
+	}
+	if got := client.BlockRequests(1); got != 3 {
+		t.Errorf("got %d requests, want 3", got)
+	}
+	// 10ms and then 20ms, before jitter.
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("retried after %v, want at least 30ms of backoff", elapsed)
+	}
+}
+
+func TestGetBlockWithRetryNotFound(t *testing.T) {
+	client := testutil.NewFakeClient().WithBlockFailure(1, http.StatusNotFound)
+	if _, err := GetBlockWithRetry(context.Background(), client, 1, 3, time.Millisecond); !errors.Is(err, ErrMissedSlot) {
+		t.Errorf("GetBlockWithRetry: got %v, want ErrMissedSlot", err)
+	}
+	if got := client.BlockRequests(1); got != 1 {
+		t.Errorf("got %d requests for a missed slot, want 1", got)
+	}
+}
+
+func TestWithRetryCancelled(t *testing.T) {
+	transient := &api.Error{StatusCode: http.StatusInternalServerError}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	if err := withRetry(ctx, 3, time.Millisecond, func() error { calls++; return transient }); err == nil {
+		t.Error("withRetry: got no error from a cancelled context")
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls with a cancelled context, want 1", calls)
+	}
+
+	// Cancelling during the backoff ends it rather than sleeping it out.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(10*time.Millisecond, cancel)
+	calls = 0
+	start := time.Now()
+	if err := withRetry(ctx, 3, time.Hour, func() error { calls++; return transient }); err == nil {
+		t.Error("withRetry: got no error after cancelling")
+	}
+	if calls != 1 || time.Since(start) > time.Minute {
+		t.Errorf("got %d calls after %v, want 1 cut short", calls, time.Since(start))
+	}
+}
@@ -0,0 +1,146 @@
+package aggregation
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	_ "modernc.org/sqlite"
+)
+
+const storeSchema = `
+CREATE TABLE IF NOT EXISTS epochs (
+	epoch INTEGER PRIMARY KEY
+);
+CREATE TABLE IF NOT EXISTS attestations (
+	epoch             INTEGER NOT NULL,
+	block_slot        INTEGER NOT NULL,
+	attestation_index INTEGER NOT NULL,
+	duty_slot         INTEGER NOT NULL,
+	committee_indices TEXT    NOT NULL,
+	computed_len      INTEGER NOT NULL,
+	actual_len        INTEGER NOT NULL,
+	is_mismatch       INTEGER NOT NULL,
+	PRIMARY KEY (block_slot, attestation_index)
+);
+CREATE INDEX IF NOT EXISTS attestations_epoch ON attestations (epoch);
+CREATE TABLE IF NOT EXISTS network (
+	genesis_validators_root TEXT NOT NULL
+);
+`
+
+// Store persists per-attestation results to SQLite so that anomalies can be
+// tracked across runs.
+type Store struct {
+	db *sql.DB
+}
+
+// OpenStore opens the SQLite database at path, creating it and its schema if
+// needed.
+func OpenStore(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(storeSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed creating schema in %s: %w", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// CheckNetwork records root as the genesis validators root of the network the
+// database holds results for, if none is recorded yet, and otherwise returns
+// a *NetworkMismatchError if it differs.
+func (s *Store) CheckNetwork(ctx context.Context, root phase0.Root) error {
+	var recorded string
+	err := s.db.QueryRowContext(ctx, `SELECT genesis_validators_root FROM network`).Scan(&recorded)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = s.db.ExecContext(ctx, `INSERT INTO network (genesis_validators_root) VALUES (?)`, fmt.Sprintf("%#x", root))
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	recordedRoot, err := parseRoot(recorded)
+	if err != nil {
+		return fmt.Errorf("invalid network in database: %w", err)
+	}
+	if recordedRoot != root {
+		return &NetworkMismatchError{What: "database", Recorded: recordedRoot, Current: root}
+	}
+	return nil
+}
+
+// HasEpoch reports whether epoch has already been saved.
+func (s *Store) HasEpoch(ctx context.Context, epoch phase0.Epoch) (bool, error) {
+	var count int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM epochs WHERE epoch = ?`, uint64(epoch)).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// SaveEpoch replaces the rows of epoch's blocks with a row per attestation in
+// reports and marks epoch as saved, all in one transaction, so that an
+// attestation reprocessing no longer finds does not linger.
+func (s *Store) SaveEpoch(ctx context.Context, epoch phase0.Epoch, reports []BlockAttestationReport) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM attestations WHERE block_slot >= ? AND block_slot < ?`,
+		uint64(EpochLowestSlot(epoch)), uint64(EpochHighestSlot(epoch))+1); err != nil {
+		return fmt.Errorf("failed clearing epoch %d: %w", epoch, err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+INSERT INTO attestations (epoch, block_slot, attestation_index, duty_slot, committee_indices, computed_len, actual_len, is_mismatch)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (block_slot, attestation_index) DO UPDATE SET
+	epoch = excluded.epoch,
+	duty_slot = excluded.duty_slot,
+	committee_indices = excluded.committee_indices,
+	computed_len = excluded.computed_len,
+	actual_len = excluded.actual_len,
+	is_mismatch = excluded.is_mismatch`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, report := range reports {
+		for i, attestation := range report.Attestations {
+			committeeIndices, err := json.Marshal(attestation.CommitteeIndices)
+			if err != nil {
+				return err
+			}
+			if _, err := stmt.ExecContext(ctx,
+				uint64(epoch),
+				uint64(report.BlockSlot),
+				i,
+				uint64(report.DutySlot),
+				string(committeeIndices),
+				attestation.ExpectedLength,
+				attestation.ActualLength,
+				attestation.Mismatch,
+			); err != nil {
+				return fmt.Errorf("failed saving attestation %d of slot %d: %w", i, report.BlockSlot, err)
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO epochs (epoch) VALUES (?) ON CONFLICT (epoch) DO NOTHING`, uint64(epoch)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
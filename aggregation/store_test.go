@@ -0,0 +1,40 @@
+package aggregation
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveEpochReplacesRows(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenStore(filepath.Join(t.TempDir(), "results.db"))
+	if err != nil {
+		t.Fatalf("OpenStore: %v", err)
+	}
+	defer store.Close()
+
+	save := func(count int) {
+		t.Helper()
+		report := BlockAttestationReport{BlockSlot: EpochLowestSlot(2) + 1, DutySlot: EpochLowestSlot(2)}
+		for i := 0; i < count; i++ {
+			report.Attestations = append(report.Attestations, AttestationReport{ExpectedLength: 4, ActualLength: 4})
+		}
+		if err := store.SaveEpoch(ctx, 2, []BlockAttestationReport{report}); err != nil {
+			t.Fatalf("SaveEpoch: %v", err)
+		}
+	}
+	save(3)
+	save(2)
+
+	var rows int
+	if err := store.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM attestations`).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 2 {
+		t.Errorf("got %d rows after saving 3 attestations and then 2, want 2", rows)
+	}
+	if saved, err := store.HasEpoch(ctx, 2); err != nil || !saved {
+		t.Errorf("HasEpoch: got %v and %v, want true", saved, err)
+	}
+}
@@ -0,0 +1,87 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// fetchedEpoch is an epoch's blocks and committees on their way from the
+// producer to the consumer of StreamEpochRange. withNext adds the next
+// epoch's blocks, where the epoch's late attestations are included.
+type fetchedEpoch struct {
+	epoch      phase0.Epoch
+	blocks     map[phase0.Slot]*spec.VersionedSignedBeaconBlock
+	withNext   map[phase0.Slot]*spec.VersionedSignedBeaconBlock
+	committees Committees
+}
+
+// StreamEpochRange runs the aggregation bits check over every epoch from start
+// to end inclusive and calls handler with the summary of each, in epoch
+// order, as soon as it has been analyzed. Unlike ProcessEpochRange it keeps
+// nothing once handler returns: a producer fetches the blocks and committees
+// of the next epoch whilst the current one is analyzed, so at most three
+// epochs of blocks are held however long the range is, counting the epoch
+// after each one that its summary needs for late inclusions. Mismatches are
+// logged but only counted in the summaries. Epochs whose state the beacon
+// node has pruned are logged and skipped.
+func StreamEpochRange(ctx context.Context, service BeaconClient, start phase0.Epoch, end phase0.Epoch, handler func(EpochSummary)) error {
+	if start > end {
+		return nil
+	}
+	g, ctx := errgroup.WithContext(ctx)
+	// Unbuffered, so the producer waits with one epoch in hand until the
+	// consumer has finished with the one before.
+	fetched := make(chan fetchedEpoch)
+
+	g.Go(func() error {
+		defer close(fetched)
+		cache := NewCommitteeCache(2)
+		lookahead := &blockLookahead{attestationsOnly: true}
+		for epoch := start; epoch <= end; epoch++ {
+			if err := ctx.Err(); err != nil {
+				return contextError(fmt.Sprintf("fetching epoch %d", epoch), err)
+			}
+			blocks, withNext, err := lookahead.listWithNext(ctx, service, epoch)
+			if err != nil {
+				return err
+			}
+			committees, err := cache.GetRange(ctx, service, PreviousEpoch(epoch), epoch)
+			if errors.Is(err, ErrStateUnavailable) {
+				log.Warn().Err(err).Uint64("epoch", uint64(epoch)).Msg("skipping epoch without state")
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("epoch %d: %w", epoch, err)
+			}
+
+			select {
+			case fetched <- fetchedEpoch{epoch: epoch, blocks: blocks, withNext: withNext, committees: committees}:
+			case <-ctx.Done():
+				return contextError(fmt.Sprintf("fetching epoch %d", epoch), ctx.Err())
+			}
+		}
+		return nil
+	})
+
+	g.Go(func() error {
+		for data := range fetched {
+			mismatches, err := FindEpochMismatches(ctx, service, data.epoch, data.withNext, data.committees)
+			if err != nil {
+				return err
+			}
+			RecordEpochMetrics(data.epoch, len(data.blocks), len(mismatches))
+			LogMismatches(mismatches)
+			LogCommitteeAnomalies(CheckCommitteeConsistency(data.epoch, data.committees))
+			handler(Summarize(data.epoch, data.withNext, data.committees, mismatches))
+		}
+		return nil
+	})
+
+	return g.Wait()
+}
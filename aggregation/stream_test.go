@@ -0,0 +1,68 @@
+package aggregation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"repro/internal/testutil"
+)
+
+func TestStreamEpochRange(t *testing.T) {
+	defer func(previous uint64) { slotsPerEpoch = previous }(slotsPerEpoch)
+	logger := log.Logger
+	log.Logger = zerolog.Nop()
+	defer func() { log.Logger = logger }()
+
+	ctx := context.Background()
+	client := testutil.NewFakeClient().
+		WithSlotsPerEpoch(4).
+		WithCommittee(1, 0, []phase0.ValidatorIndex{1, 2, 3}).
+		WithCommittee(5, 0, []phase0.ValidatorIndex{4, 5}).
+		WithCommittee(9, 0, []phase0.ValidatorIndex{6, 7}).
+		WithBlock(2, attestation(1, []uint64{0}, 4)).
+		WithBlock(6, attestation(5, []uint64{0}, 2)).
+		WithBlock(10, attestation(9, []uint64{0}, 1), attestation(9, []uint64{0}, 5))
+	LoadSpec(ctx, client)
+
+	var summaries []EpochSummary
+	if err := StreamEpochRange(ctx, client, 0, 2, func(summary EpochSummary) {
+		summaries = append(summaries, summary)
+	}); err != nil {
+		t.Fatalf("StreamEpochRange: %v", err)
+	}
+	if len(summaries) != 3 {
+		t.Fatalf("got %d summaries, want 3: %+v", len(summaries), summaries)
+	}
+	for i, want := range []int{1, 0, 2} {
+		if summaries[i].Epoch != phase0.Epoch(i) {
+			t.Errorf("summary %d is for epoch %d", i, summaries[i].Epoch)
+		}
+		if summaries[i].Mismatches != want {
+			t.Errorf("epoch %d: got %d mismatches, want %d", i, summaries[i].Mismatches, want)
+		}
+		if summaries[i].BlocksPresent != 1 {
+			t.Errorf("epoch %d: got %d blocks, want 1", i, summaries[i].BlocksPresent)
+		}
+	}
+}
+
+func TestStreamEpochRangeCanceled(t *testing.T) {
+	logger := log.Logger
+	log.Logger = zerolog.Nop()
+	defer func() { log.Logger = logger }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	called := false
+	err := StreamEpochRange(ctx, testutil.NewFakeClient(), 0, 10, func(EpochSummary) { called = true })
+	if err == nil {
+		t.Fatal("StreamEpochRange: expected an error for a canceled context")
+	}
+	if called {
+		t.Error("handler called for a canceled scan")
+	}
+}
@@ -0,0 +1,135 @@
+package aggregation
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+)
+
+// EpochSummary is a digest of one epoch's health.
+type EpochSummary struct {
+	Epoch         phase0.Epoch `json:"epoch"`
+	BlocksPresent int          `json:"blocks_present"`
+	BlocksMissed  int          `json:"blocks_missed"`
+	// MissedSlots are the slots without a block, sorted.
+	MissedSlots []phase0.Slot `json:"missed_slots"`
+	// Attestations, UniqueAttesters, Participation and the inclusion
+	// distances cover the Electra attestations for the epoch's duty slots.
+	Attestations    int `json:"attestations"`
+	UniqueAttesters int `json:"unique_attesters"`
+	// ActiveValidators is the number of validators with a duty in the epoch,
+	// and EpochParticipation the share of them that attested.
+	ActiveValidators   int     `json:"active_validators"`
+	EpochParticipation float64 `json:"epoch_participation"`
+	Participation      float64 `json:"participation"`
+	Mismatches         int     `json:"mismatches"`
+	MinInclusion       uint64  `json:"min_inclusion_distance"`
+	AvgInclusion       float64 `json:"avg_inclusion_distance"`
+	MaxInclusion       uint64  `json:"max_inclusion_distance"`
+}
+
+// Summarize digests epoch from its blocks, committees and the mismatches
+// found in them. blocks should include the next epoch's, as
+// WithNextEpochBlocks returns, or the attestations included late are missed
+// and the last duty slot looks all but empty. Participation is averaged over
+// the duty slots whose committees are known, while EpochParticipation counts
+// each validator once across the whole epoch.
+func Summarize(epoch phase0.Epoch, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, committees Committees, mismatches []Mismatch) EpochSummary {
+	summary := EpochSummary{
+		Epoch:      epoch,
+		Mismatches: len(mismatches),
+	}
+	summary.MissedSlots = MissedSlots(blocks, epoch)
+	summary.BlocksMissed = len(summary.MissedSlots)
+	summary.BlocksPresent = int(slotsPerEpoch) - summary.BlocksMissed
+
+	byDutySlot := GatherAttestationsByDutySlot(blocks)
+	rates, rated := 0.0, 0
+	distances, included := uint64(0), 0
+	for slot := EpochLowestSlot(epoch); slot <= EpochHighestSlot(epoch); slot++ {
+		attestations := byDutySlot[slot]
+		summary.Attestations += len(attestations)
+		for _, attestation := range attestations {
+			distance := InclusionDistance(slot, attestation.InclusionSlot)
+			if included == 0 || distance < summary.MinInclusion {
+				summary.MinInclusion = distance
+			}
+			summary.MaxInclusion = max(summary.MaxInclusion, distance)
+			distances += distance
+			included++
+		}
+
+		summary.ActiveValidators += committees.Size(slot)
+		if len(committees[slot]) == 0 {
+			continue
+		}
+		rate, _, _, err := ParticipationRate(slot, attestationsOf(attestations), committees[slot])
+		if err != nil {
+			continue
+		}
+		rates += rate
+		rated++
+	}
+
+	summary.UniqueAttesters = len(EpochUniqueAttesters(epoch, blocks, committees))
+	if summary.ActiveValidators > 0 {
+		summary.EpochParticipation = float64(summary.UniqueAttesters) / float64(summary.ActiveValidators)
+	}
+	if rated > 0 {
+		summary.Participation = rates / float64(rated)
+	}
+	if included > 0 {
+		summary.AvgInclusion = float64(distances) / float64(included)
+	}
+	return summary
+}
+
+// EpochUniqueAttesters returns every validator that attested to any duty slot
+// of epoch in the Electra attestations in blocks, deduplicated and sorted
+// ascending. As for Summarize, blocks should include the next epoch's.
+// Attestations that cannot be decoded against committees are skipped; they
+// show up as mismatches already.
+func EpochUniqueAttesters(epoch phase0.Epoch, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, committees Committees) []phase0.ValidatorIndex {
+	byDutySlot := GatherAttestationsByDutySlot(blocks)
+	attesters := make(map[phase0.ValidatorIndex]struct{})
+	for slot := EpochLowestSlot(epoch); slot <= EpochHighestSlot(epoch); slot++ {
+		for _, attestation := range byDutySlot[slot] {
+			validators, err := AttestingValidators(attestation.Attestation, committees[slot])
+			if err != nil {
+				continue
+			}
+			for _, validator := range validators {
+				attesters[validator] = struct{}{}
+			}
+		}
+	}
+	return slices.Sorted(maps.Keys(attesters))
+}
+
+// WriteEpochSummary writes summary to w as a compact block.
+func WriteEpochSummary(w io.Writer, summary EpochSummary) error {
+	missed := make([]int, 0, len(summary.MissedSlots))
+	for _, slot := range summary.MissedSlots {
+		missed = append(missed, int(slot))
+	}
+	_, err := fmt.Fprintf(w, `epoch %d
+  blocks:        %d present, %d missed
+  missed slots:  %s
+  attestations:  %d from %d unique attesters
+  attesters:     %d of %d active validators (%.2f%%)
+  participation: %.2f%%
+  mismatches:    %d
+  inclusion:     min %d, avg %.2f, max %d
+`, summary.Epoch, summary.BlocksPresent, summary.BlocksMissed,
+		formatIndices(missed),
+		summary.Attestations, summary.UniqueAttesters,
+		summary.UniqueAttesters, summary.ActiveValidators, summary.EpochParticipation*100,
+		summary.Participation*100,
+		summary.Mismatches,
+		summary.MinInclusion, summary.AvgInclusion, summary.MaxInclusion)
+	return err
+}
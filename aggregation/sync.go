@@ -0,0 +1,105 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+
+	eth2client "github.com/attestantio/go-eth2-client"
+	"github.com/attestantio/go-eth2-client/api"
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+)
+
+// DEFAULT_LOW_SYNC_PARTICIPATION is the sync committee participation below
+// which a block is listed as unusually low.
+const DEFAULT_LOW_SYNC_PARTICIPATION = 0.8
+
+// SyncCommitteeParticipation returns, for every block in blocks, the fraction
+// of the sync committee whose bit is set in the block's sync aggregate.
+// Blocks from before Altair carry no sync aggregate and are skipped. The
+// committee signing in a block is the one for the block's own period, and is
+// fetched once per period.
+func SyncCommitteeParticipation(ctx context.Context, service BeaconClient, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock) (map[phase0.Slot]float64, error) {
+	provider, ok := service.(eth2client.SyncCommitteesProvider)
+	if !ok {
+		return nil, errors.New("beacon client does not provide sync committees")
+	}
+
+	sizes := make(map[uint64]int)
+	result := make(map[phase0.Slot]float64, len(blocks))
+	for _, slot := range slices.Sorted(maps.Keys(blocks)) {
+		block := blocks[slot]
+		if block.Version < spec.DataVersionAltair {
+			continue
+		}
+		aggregate, err := block.SyncAggregate()
+		if err != nil {
+			return nil, fmt.Errorf("slot %d: %w", slot, err)
+		}
+
+		epoch := SlotEpoch(slot)
+		period := SyncCommitteePeriod(epoch, epochsPerSyncCommitteePeriod)
+		size, ok := sizes[period]
+		if !ok {
+			size, err = syncCommitteeSize(ctx, provider, slot, epoch)
+			if err != nil {
+				return nil, err
+			}
+			sizes[period] = size
+		}
+		if size == 0 {
+			continue
+		}
+		result[slot] = float64(aggregate.SyncCommitteeBits.Count()) / float64(size)
+	}
+	return result, nil
+}
+
+// syncCommitteeSize fetches the sync committee for epoch from the state at
+// slot and returns its size.
+func syncCommitteeSize(ctx context.Context, provider eth2client.SyncCommitteesProvider, slot phase0.Slot, epoch phase0.Epoch) (int, error) {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
+	resp, err := provider.SyncCommittee(ctx, &api.SyncCommitteeOpts{
+		State: fmt.Sprintf("%d", slot),
+		Epoch: &epoch,
+	})
+	if err != nil {
+		return 0, contextError(fmt.Sprintf("fetching sync committee for epoch %d", epoch), err)
+	}
+	if resp == nil || resp.Data == nil {
+		return 0, fmt.Errorf("no sync committee returned for epoch %d", epoch)
+	}
+	return len(resp.Data.Validators), nil
+}
+
+// WriteSyncParticipation writes the average sync committee participation to w,
+// followed by every block whose participation is below
+// DEFAULT_LOW_SYNC_PARTICIPATION.
+func WriteSyncParticipation(w io.Writer, participation map[phase0.Slot]float64) error {
+	if len(participation) == 0 {
+		_, err := fmt.Fprintln(w, "no blocks with sync aggregates")
+		return err
+	}
+
+	total := 0.0
+	for _, rate := range participation {
+		total += rate
+	}
+	if _, err := fmt.Fprintf(w, "average sync participation: %.2f%% over %d blocks\n", total/float64(len(participation))*100, len(participation)); err != nil {
+		return err
+	}
+	for _, slot := range slices.Sorted(maps.Keys(participation)) {
+		if rate := participation[slot]; rate < DEFAULT_LOW_SYNC_PARTICIPATION {
+			if _, err := fmt.Fprintf(w, "low sync participation at slot %d: %.2f%%\n", slot, rate*100); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package aggregation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/prysmaticlabs/go-bitfield"
+
+	"repro/internal/testutil"
+)
+
+func TestSyncCommitteeParticipationAtPeriodBoundary(t *testing.T) {
+	defer func(slots, epochs uint64) {
+		slotsPerEpoch, epochsPerSyncCommitteePeriod = slots, epochs
+	}(slotsPerEpoch, epochsPerSyncCommitteePeriod)
+
+	// Periods of two 8-slot epochs, so slot 15 is the last of period 0 and
+	// slot 16 the first of period 1. The committees differ in size, as they
+	// never would on a real chain, to tell which one was used.
+	members := func(n int) []phase0.ValidatorIndex {
+		validators := make([]phase0.ValidatorIndex, n)
+		for i := range validators {
+			validators[i] = phase0.ValidatorIndex(i)
+		}
+		return validators
+	}
+	bits := bitfield.NewBitvector512()
+	for i := range uint64(128) {
+		bits.SetBitAt(i, true)
+	}
+	client := testutil.NewFakeClient().
+		WithSlotsPerEpoch(8).
+		WithEpochsPerSyncCommitteePeriod(2).
+		WithSyncCommittee(0, members(512)).
+		WithSyncCommittee(1, members(256)).
+		WithBlock(14).WithSyncAggregate(14, bits).
+		WithBlock(15).WithSyncAggregate(15, bits).
+		WithBlock(16).WithSyncAggregate(16, bits)
+
+	ctx := context.Background()
+	LoadSpec(ctx, client)
+	if epochsPerSyncCommitteePeriod != 2 {
+		t.Fatalf("LoadSpec: got %d epochs per sync committee period, want 2", epochsPerSyncCommitteePeriod)
+	}
+	if got := SyncCommitteePeriod(SlotEpoch(15), 2); got != 0 {
+		t.Errorf("SyncCommitteePeriod at slot 15: got %d, want 0", got)
+	}
+	if got := SyncCommitteePeriod(SlotEpoch(16), 2); got != 1 {
+		t.Errorf("SyncCommitteePeriod at slot 16: got %d, want 1", got)
+	}
+
+	blocks, err := fetchBlocks(ctx, client, []phase0.Slot{14, 15, 16}, DEFAULT_BLOCK_WORKERS)
+	if err != nil {
+		t.Fatalf("fetchBlocks: %v", err)
+	}
+	participation, err := SyncCommitteeParticipation(ctx, client, blocks)
+	if err != nil {
+		t.Fatalf("SyncCommitteeParticipation: %v", err)
+	}
+	want := map[phase0.Slot]float64{14: 0.25, 15: 0.25, 16: 0.5}
+	for slot, rate := range want {
+		if participation[slot] != rate {
+			t.Errorf("slot %d: got participation %v, want %v", slot, participation[slot], rate)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package aggregation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog/log"
+)
+
+// VoteCorrectness holds one flag per attestation vote that matched the
+// canonical chain.
+type VoteCorrectness uint8
+
+const (
+	CorrectSource VoteCorrectness = 1 << iota
+	CorrectTarget
+	CorrectHead
+)
+
+// Has reports whether all of flags are set.
+func (v VoteCorrectness) Has(flags VoteCorrectness) bool {
+	return v&flags == flags
+}
+
+// CanonicalRoots returns the block root of every block in blocks, keyed by
+// slot.
+func CanonicalRoots(blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock) map[phase0.Slot]phase0.Root {
+	roots := make(map[phase0.Slot]phase0.Root, len(blocks))
+	for slot, block := range blocks {
+		root, err := block.Root()
+		if err != nil {
+			log.Error().Err(err).Uint64("slot", uint64(slot)).Msg("failed computing block root")
+			continue
+		}
+		roots[slot] = root
+	}
+	return roots
+}
+
+// rootIndex holds canonical roots in slot order, so that the root at any
+// slot is a binary search away.
+type rootIndex struct {
+	slots []phase0.Slot
+	roots []phase0.Root
+}
+
+func newRootIndex(canonicalRoots map[phase0.Slot]phase0.Root) rootIndex {
+	index := rootIndex{slots: slices.Sorted(maps.Keys(canonicalRoots))}
+	index.roots = make([]phase0.Root, len(index.slots))
+	for i, slot := range index.slots {
+		index.roots[i] = canonicalRoots[slot]
+	}
+	return index
+}
+
+// at returns the canonical root at slot, which for a missed slot is the root
+// of the latest block before it. It is false if no such block is known.
+func (r rootIndex) at(slot phase0.Slot) (phase0.Root, bool) {
+	i, found := slices.BinarySearch(r.slots, slot)
+	if found {
+		return r.roots[i], true
+	}
+	if i == 0 {
+		return phase0.Root{}, false
+	}
+	return r.roots[i-1], true
+}
+
+// ClassifyVote checks the source, target and head votes of attestation
+// against canonicalRoots. A vote whose canonical root is not in
+// canonicalRoots is not flagged as correct; VoteRoots gathers the roots the
+// votes of an epoch need.
+func ClassifyVote(attestation *electra.Attestation, canonicalRoots map[phase0.Slot]phase0.Root) VoteCorrectness {
+	return classifyVote(attestation, newRootIndex(canonicalRoots))
+}
+
+func classifyVote(attestation *electra.Attestation, roots rootIndex) VoteCorrectness {
+	var result VoteCorrectness
+	data := attestation.Data
+	if data == nil {
+		return result
+	}
+	if data.Source != nil {
+		if root, ok := roots.at(EpochLowestSlot(data.Source.Epoch)); ok && root == data.Source.Root {
+			result |= CorrectSource
+		}
+	}
+	if data.Target != nil {
+		if root, ok := roots.at(EpochLowestSlot(data.Target.Epoch)); ok && root == data.Target.Root {
+			result |= CorrectTarget
+		}
+	}
+	if root, ok := roots.at(data.Slot); ok && root == data.BeaconBlockRoot {
+		result |= CorrectHead
+	}
+	return result
+}
+
+// VoteRoots returns the canonical roots needed to classify the votes for
+// epoch's duty slots: those of blocks, which should hold epoch's blocks and
+// the next epoch's as WithNextEpochBlocks returns, and those of the blocks of
+// every checkpoint epoch the votes name. The source checkpoint is the
+// justified one, often two or more epochs back. A checkpoint's root is that
+// of the block at its epoch's first slot or, if that slot was missed, the
+// latest before it, so earlier epochs are listed back to the first with a
+// block. If an epoch cannot be listed, the roots gathered so far are returned
+// with the error; votes for the checkpoints missing then go uncounted.
+func VoteRoots(ctx context.Context, service BeaconClient, epoch phase0.Epoch, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, workers int) (map[phase0.Slot]phase0.Root, error) {
+	roots := CanonicalRoots(blocks)
+	listed := map[phase0.Epoch]bool{epoch: true, epoch + 1: true}
+	checkpoints := map[phase0.Epoch]bool{epoch: true}
+	for slot, attestations := range GatherAttestationsByDutySlot(blocks) {
+		if SlotEpoch(slot) != epoch {
+			continue
+		}
+		for _, attestation := range attestations {
+			data := attestation.Attestation.Data
+			if data != nil && data.Source != nil {
+				checkpoints[data.Source.Epoch] = true
+			}
+			if data != nil && data.Target != nil {
+				checkpoints[data.Target.Epoch] = true
+			}
+		}
+	}
+
+	for _, checkpoint := range slices.Sorted(maps.Keys(checkpoints)) {
+		for e := checkpoint; ; e-- {
+			if !listed[e] {
+				epochBlocks, err := ListEpochBlocksConcurrent(ctx, service, e, workers)
+				if err != nil {
+					return roots, fmt.Errorf("listing blocks of epoch %d: %w", e, err)
+				}
+				maps.Copy(roots, CanonicalRoots(epochBlocks))
+				listed[e] = true
+			}
+			if hasRootBetween(roots, EpochLowestSlot(e), EpochLowestSlot(checkpoint)) || e == 0 {
+				break
+			}
+		}
+	}
+	return roots, nil
+}
+
+// hasRootBetween reports whether roots holds a root for a slot from first to
+// last inclusive.
+func hasRootBetween(roots map[phase0.Slot]phase0.Root, first phase0.Slot, last phase0.Slot) bool {
+	for slot := first; slot <= last; slot++ {
+		if _, ok := roots[slot]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// VoteSummary counts correct votes across the attestations for an epoch's
+// duty slots.
+type VoteSummary struct {
+	Epoch        phase0.Epoch `json:"epoch"`
+	Attestations int          `json:"attestations"`
+	Source       int          `json:"correct_source"`
+	Target       int          `json:"correct_target"`
+	Head         int          `json:"correct_head"`
+}
+
+// SummarizeVotes classifies every attestation in blocks whose duty slot falls
+// in epoch. blocks should include the next epoch's, as WithNextEpochBlocks
+// returns, or the attestations included late are missed; canonicalRoots
+// should come from VoteRoots.
+func SummarizeVotes(epoch phase0.Epoch, blocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock, canonicalRoots map[phase0.Slot]phase0.Root) VoteSummary {
+	summary := VoteSummary{Epoch: epoch}
+	roots := newRootIndex(canonicalRoots)
+	for slot, attestations := range GatherAttestationsByDutySlot(blocks) {
+		if slot < EpochLowestSlot(epoch) || slot > EpochHighestSlot(epoch) {
+			continue
+		}
+		for _, attestation := range attestations {
+			vote := classifyVote(attestation.Attestation, roots)
+			summary.Attestations++
+			if vote.Has(CorrectSource) {
+				summary.Source++
+			}
+			if vote.Has(CorrectTarget) {
+				summary.Target++
+			}
+			if vote.Has(CorrectHead) {
+				summary.Head++
+			}
+		}
+	}
+	return summary
+}
+
+// WriteVoteSummary writes summary to w as percentages of its attestations.
+func WriteVoteSummary(w io.Writer, summary VoteSummary) error {
+	percent := func(count int) float64 {
+		if summary.Attestations == 0 {
+			return 0
+		}
+		return float64(count) / float64(summary.Attestations) * 100
+	}
+	_, err := fmt.Fprintf(w, "epoch %d: %d attestations, source %.2f%%, target %.2f%%, head %.2f%%\n",
+		summary.Epoch, summary.Attestations, percent(summary.Source), percent(summary.Target), percent(summary.Head))
+	return err
+}
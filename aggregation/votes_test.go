@@ -0,0 +1,74 @@
+package aggregation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+
+	"repro/internal/testutil"
+)
+
+func TestVoteRoots(t *testing.T) {
+	defer func(previous uint64) { slotsPerEpoch = previous }(slotsPerEpoch)
+	ctx := context.Background()
+
+	// The source checkpoint is epoch 1, whose first slot was missed, so its
+	// root is that of the block at slot 1.
+	sourceBlock, headBlock := fullBlock(1), fullBlock(12)
+	sourceRoot, err := sourceBlock.Message.HashTreeRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	headRoot, err := headBlock.Message.HashTreeRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vote := attestation(12, []uint64{0}, 2)
+	vote.Data.BeaconBlockRoot = headRoot
+	vote.Data.Source = &phase0.Checkpoint{Epoch: 1, Root: sourceRoot}
+	vote.Data.Target = &phase0.Checkpoint{Epoch: 3, Root: headRoot}
+
+	client := testutil.NewFakeClient().
+		WithSlotsPerEpoch(4).
+		WithSignedBlock(sourceBlock).
+		WithSignedBlock(headBlock).
+		WithSignedBlock(fullBlock(13, vote))
+	LoadSpec(ctx, client)
+
+	blocks, err := ListEpochBlocksConcurrent(ctx, client, 3, DEFAULT_BLOCK_WORKERS)
+	if err != nil {
+		t.Fatalf("ListEpochBlocksConcurrent: %v", err)
+	}
+	blocks, err = WithNextEpochBlocks(ctx, client, 3, blocks, DEFAULT_BLOCK_WORKERS)
+	if err != nil {
+		t.Fatalf("WithNextEpochBlocks: %v", err)
+	}
+	roots, err := VoteRoots(ctx, client, 3, blocks, DEFAULT_BLOCK_WORKERS)
+	if err != nil {
+		t.Fatalf("VoteRoots: %v", err)
+	}
+	if roots[1] != sourceRoot {
+		t.Errorf("got root %#x at slot 1, want %#x", roots[1], sourceRoot)
+	}
+	if got := client.BlockRequests(8); got != 0 {
+		t.Errorf("got %d requests for epoch 2, which no vote names, want 0", got)
+	}
+
+	summary := SummarizeVotes(3, blocks, roots)
+	if summary.Attestations != 1 || summary.Source != 1 || summary.Target != 1 || summary.Head != 1 {
+		t.Errorf("got %+v, want one attestation with every vote correct", summary)
+	}
+}
+
+func TestRootIndex(t *testing.T) {
+	roots := newRootIndex(map[phase0.Slot]phase0.Root{3: {3}, 7: {7}})
+	for slot, want := range map[phase0.Slot]phase0.Root{3: {3}, 5: {3}, 7: {7}, 100: {7}} {
+		if got, ok := roots.at(slot); !ok || got != want {
+			t.Errorf("slot %d: got %#x, %v, want %#x", slot, got, ok, want)
+		}
+	}
+	if _, ok := roots.at(2); ok {
+		t.Error("got a root before the first known block")
+	}
+}
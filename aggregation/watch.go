@@ -0,0 +1,186 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	eth2client "github.com/attestantio/go-eth2-client"
+	"github.com/attestantio/go-eth2-client/api"
+	apiv1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog/log"
+)
+
+// DEFAULT_WATCH_STALL_TIMEOUT is how long Watch waits without any event
+// before it drops the subscription and subscribes again.
+const DEFAULT_WATCH_STALL_TIMEOUT = 2 * time.Minute
+
+// Watch subscribes to the beacon node's block and head events and checks the
+// attestations for each new block's duty slot as it arrives, logging any
+// mismatches and counting them in the metrics. Single attestations gossiped
+// in the meantime are checked the same way, if the node serves them.
+// Committees are fetched on demand and cached. The event stream reconnects by
+// itself when it drops; if it goes quiet for DEFAULT_WATCH_STALL_TIMEOUT,
+// Watch subscribes again. It returns once ctx is done.
+func Watch(ctx context.Context, service BeaconClient) error {
+	provider, ok := service.(eth2client.EventsProvider)
+	if !ok {
+		return errors.New("beacon client does not provide events")
+	}
+
+	blocks := make(chan *apiv1.BlockEvent, 64)
+	alive := make(chan struct{}, 1)
+	opts := &api.EventsOpts{
+		Topics: []string{"block", "head"},
+		BlockHandler: func(_ context.Context, event *apiv1.BlockEvent) {
+			select {
+			case blocks <- event:
+			default:
+				log.Warn().Uint64("slot", uint64(event.Slot)).Msg("falling behind, dropping block event")
+			}
+		},
+		HeadHandler: func(_ context.Context, _ *apiv1.HeadEvent) {
+			select {
+			case alive <- struct{}{}:
+			default:
+			}
+		},
+	}
+	// Single attestations get a subscription of their own, so that a node
+	// that does not serve them still has its blocks watched.
+	singles := make(chan *electra.SingleAttestation, 1024)
+	singleOpts := &api.EventsOpts{
+		Topics: []string{"single_attestation"},
+		SingleAttestationHandler: func(_ context.Context, single *electra.SingleAttestation) {
+			select {
+			case singles <- single:
+			default:
+				// Thousands arrive every slot; dropping some under load is
+				// expected.
+			}
+		},
+	}
+
+	subscribe := func() (context.CancelFunc, error) {
+		subscriptionCtx, cancel := context.WithCancel(ctx)
+		if err := provider.Events(subscriptionCtx, opts); err != nil {
+			cancel()
+			return nil, contextError("subscribing to events", err)
+		}
+		if err := provider.Events(subscriptionCtx, singleOpts); err != nil {
+			log.Warn().Err(err).Msg("failed subscribing to single attestations, watching blocks alone")
+		}
+		log.Info().Msg("watching for new blocks")
+		return cancel, nil
+	}
+	unsubscribe, err := subscribe()
+	if err != nil {
+		return err
+	}
+	defer func() { unsubscribe() }()
+
+	cache := NewCommitteeCache(3)
+	var metrics watchMetrics
+	stalled := time.NewTimer(DEFAULT_WATCH_STALL_TIMEOUT)
+	defer stalled.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-alive:
+			stalled.Reset(DEFAULT_WATCH_STALL_TIMEOUT)
+		case event := <-blocks:
+			stalled.Reset(DEFAULT_WATCH_STALL_TIMEOUT)
+			metrics.blockSeen(event.Slot)
+			checkWatchedBlock(ctx, service, cache, event)
+		case single := <-singles:
+			checkWatchedSingle(ctx, service, cache, single)
+		case <-stalled.C:
+			log.Warn().Dur("after", DEFAULT_WATCH_STALL_TIMEOUT).Msg("no events received, subscribing again")
+			unsubscribe()
+			if unsubscribe, err = subscribe(); err != nil {
+				return err
+			}
+			stalled.Reset(DEFAULT_WATCH_STALL_TIMEOUT)
+		}
+	}
+}
+
+// watchMetrics feeds the metrics a range scan records with RecordEpochMetrics
+// from the blocks seen by Watch, which arrive one at a time. An epoch's
+// missed slots are counted, and the epoch marked processed, once a block from
+// a later epoch arrives. The epoch Watch started in is left out, as the
+// blocks before the subscription were never seen.
+type watchMetrics struct {
+	epoch   phase0.Epoch
+	slots   map[phase0.Slot]struct{}
+	partial bool
+}
+
+// blockSeen records a block event for slot.
+func (m *watchMetrics) blockSeen(slot phase0.Slot) {
+	epoch := SlotEpoch(slot)
+	switch {
+	case m.slots == nil:
+		m.epoch, m.slots, m.partial = epoch, make(map[phase0.Slot]struct{}), true
+	case epoch > m.epoch:
+		if !m.partial {
+			recordEpochBlockMetrics(m.epoch, len(m.slots))
+		}
+		m.epoch, m.slots, m.partial = epoch, make(map[phase0.Slot]struct{}), false
+	case epoch < m.epoch:
+		// A late event for an epoch already recorded.
+		return
+	}
+	// Competing blocks for a slot during a reorg count once.
+	m.slots[slot] = struct{}{}
+}
+
+// checkWatchedBlock fetches the block announced by event and checks the
+// attestations for its duty slot, counting its mismatches in the metrics
+// straight away.
+func checkWatchedBlock(ctx context.Context, service BeaconClient, cache *CommitteeCache, event *apiv1.BlockEvent) {
+	block, err := GetBlockByRoot(ctx, service, event.Block)
+	if err != nil {
+		log.Error().Err(err).Uint64("slot", uint64(event.Slot)).Msg("failed fetching block")
+		return
+	}
+
+	// The duty slot is the slot before the block, which may be in the
+	// previous epoch.
+	epoch := SlotEpoch(event.Slot)
+	committees, err := cache.GetRange(ctx, service, PreviousEpoch(epoch), epoch)
+	if err != nil {
+		log.Error().Err(err).Uint64("slot", uint64(event.Slot)).Msg("failed fetching committees")
+		return
+	}
+
+	mismatches := FindAggregationMismatches(map[phase0.Slot]*spec.VersionedSignedBeaconBlock{event.Slot: block}, committees)
+	LogMismatches(mismatches)
+	if event.Slot > 0 {
+		recordMismatchMetrics(SlotEpoch(event.Slot-1), len(mismatches))
+	}
+	log.Info().Uint64("slot", uint64(event.Slot)).Int("mismatches", len(mismatches)).Msg("checked block")
+}
+
+// checkWatchedSingle checks single, gossiped while watching, against the
+// committees of its slot, counting a mismatch in the metrics straight away.
+func checkWatchedSingle(ctx context.Context, service BeaconClient, cache *CommitteeCache, single *electra.SingleAttestation) []Mismatch {
+	if single.Data == nil {
+		return nil
+	}
+	epoch := SlotEpoch(single.Data.Slot)
+	committees, err := cache.Get(ctx, service, epoch)
+	if err != nil {
+		log.Error().Err(err).Uint64("slot", uint64(single.Data.Slot)).Msg("failed fetching committees")
+		return nil
+	}
+
+	mismatches := CheckSingleAttestations([]*electra.SingleAttestation{single}, committees)
+	LogMismatches(mismatches)
+	recordMismatchMetrics(epoch, len(mismatches))
+	return mismatches
+}
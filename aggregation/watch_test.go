@@ -0,0 +1,66 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"repro/internal/testutil"
+)
+
+func TestWatchMetrics(t *testing.T) {
+	defer func(previous uint64) { slotsPerEpoch = previous }(slotsPerEpoch)
+	slotsPerEpoch = 4
+	value := func() (float64, float64) {
+		var missed, last dto.Metric
+		if err := missedSlots.Write(&missed); err != nil {
+			t.Fatalf("reading missed slots: %v", err)
+		}
+		if err := lastProcessedEpoch.Write(&last); err != nil {
+			t.Fatalf("reading last processed epoch: %v", err)
+		}
+		return missed.GetCounter().GetValue(), last.GetGauge().GetValue()
+	}
+	missedBefore, _ := value()
+
+	var metrics watchMetrics
+	// Started watching part way through epoch 1, then missed slot 10 in
+	// epoch 2 and saw slot 9 twice, as in a reorg.
+	for _, slot := range []phase0.Slot{6, 7, 8, 9, 9, 11, 7, 12} {
+		metrics.blockSeen(slot)
+	}
+	missed, last := value()
+	if missed-missedBefore != 1 {
+		t.Errorf("counted %v missed slots, want epoch 2's one", missed-missedBefore)
+	}
+	if last != 2 {
+		t.Errorf("got last processed epoch %v, want 2", last)
+	}
+}
+
+func TestCheckWatchedSingle(t *testing.T) {
+	logger := log.Logger
+	log.Logger = zerolog.Nop()
+	defer func() { log.Logger = logger }()
+
+	client := testutil.NewFakeClient().WithCommittee(40, 0, []phase0.ValidatorIndex{1, 2, 3})
+	cache := NewCommitteeCache(3)
+	member := &electra.SingleAttestation{CommitteeIndex: 0, AttesterIndex: 3, Data: &phase0.AttestationData{Slot: 40}}
+	if mismatches := checkWatchedSingle(context.Background(), client, cache, member); len(mismatches) != 0 {
+		t.Errorf("got mismatches %+v for a committee member", mismatches)
+	}
+	stranger := &electra.SingleAttestation{CommitteeIndex: 0, AttesterIndex: 9, Data: &phase0.AttestationData{Slot: 40}}
+	mismatches := checkWatchedSingle(context.Background(), client, cache, stranger)
+	if len(mismatches) != 1 || !errors.Is(mismatches[0].Err, ErrAttesterNotInCommittee) {
+		t.Errorf("got mismatches %+v, want the stranger", mismatches)
+	}
+	if got := client.CommitteeRequests(SlotEpoch(40)); got != 1 {
+		t.Errorf("got %d committee requests, want 1 shared by both", got)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	eth2http "github.com/attestantio/go-eth2-client/http"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog/log"
+
+	"repro/aggregation"
+)
+
+// compareNodes fetches epoch's blocks from service and from the beacon node at
+// cfg.compareURL and prints every slot where the two disagree.
+func compareNodes(ctx context.Context, cfg *config, service aggregation.BeaconClient, epoch phase0.Epoch) error {
+	httpService, err := eth2http.New(ctx, beaconParameters(cfg, cfg.compareURL)...)
+	if err != nil {
+		return fmt.Errorf("failed creating service for %s: %w", cfg.compareURL, err)
+	}
+	other, ok := httpService.(aggregation.BeaconClient)
+	if !ok {
+		return fmt.Errorf("beacon client for %s does not implement the providers the analysis needs", cfg.compareURL)
+	}
+	if err := aggregation.PreflightCheck(ctx, other, epoch); err != nil {
+		return fmt.Errorf("beacon node %s is not ready: %w", cfg.compareURL, err)
+	}
+
+	// A block that failed to fetch must not pass for a missed slot, or a
+	// flaky node looks like a diverging one.
+	blocks, failed, err := aggregation.ListEpochBlocksWithFailures(ctx, service, epoch, cfg.workers)
+	if err != nil {
+		return fmt.Errorf("failed fetching blocks from %s: %w", cfg.beaconURL, err)
+	}
+	otherBlocks, otherFailed, err := aggregation.ListEpochBlocksWithFailures(ctx, other, epoch, cfg.workers)
+	if err != nil {
+		return fmt.Errorf("failed fetching blocks from %s: %w", cfg.compareURL, err)
+	}
+
+	diffs := aggregation.CompareEpochBlocks(epoch, blocks, otherBlocks, failed, otherFailed)
+	unfetched := 0
+	for _, diff := range diffs {
+		if diff.FetchFailed {
+			unfetched++
+		}
+	}
+	log.Info().Uint64("epoch", uint64(epoch)).Int("slots_differing", len(diffs)-unfetched).Int("slots_unfetched", unfetched).Msg("compared beacon nodes")
+	return aggregation.WriteSlotDiffs(os.Stdout, epoch, cfg.beaconURL, cfg.compareURL, diffs)
+}
@@ -0,0 +1,627 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net/textproto"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog"
+
+	"repro/aggregation"
+)
+
+type config struct {
+	beaconURL string
+	// compareURL, if set, is a second beacon node whose blocks for the epoch
+	// are diffed against beaconURL's instead of running the analysis.
+	compareURL string
+	// headers are sent with every beacon node request, including the
+	// Authorization header built from --bearer-token.
+	headers map[string]string
+	// tlsConfig, if set, replaces the default TLS configuration of beacon
+	// node connections; tlsSkipVerify records that it skips verification.
+	tlsConfig     *tls.Config
+	tlsSkipVerify bool
+	epoch         phase0.Epoch
+	// epochSet is false when --epoch was omitted and the latest finalized
+	// epoch should be used instead.
+	epochSet bool
+	// startEpoch and endEpoch bound a range scan when rangeSet is true.
+	startEpoch phase0.Epoch
+	endEpoch   phase0.Epoch
+	rangeSet   bool
+	startSet   bool
+	// lastEpochs requests a range scan of the most recent finalized epochs.
+	lastEpochs uint64
+	// requestTimeout bounds each beacon node request and overallTimeout, if
+	// set, the whole run.
+	requestTimeout time.Duration
+	overallTimeout time.Duration
+	// epochBudget bounds the time a range scan spends on each epoch.
+	epochBudget time.Duration
+	// failFast stops at the first mismatch and explains it in full.
+	failFast bool
+	// stream writes each epoch's summary of a range scan as soon as it has
+	// been analyzed, keeping nothing from earlier epochs.
+	stream bool
+	// maxEpochs caps the size of a range scan unless allowLarge is set.
+	maxEpochs  uint64
+	allowLarge bool
+	// maxAttempts and baseDelay control how beacon node requests are retried.
+	maxAttempts int
+	baseDelay   time.Duration
+	// dumpSlot, when dumpSet, is a duty slot whose raw attestations are
+	// printed instead of running any analysis.
+	dumpSlot phase0.Slot
+	dumpSet  bool
+	// committeeIndex, when committeeIndexSet, narrows the analysis down to
+	// the attestations covering that committee.
+	committeeIndex    phase0.CommitteeIndex
+	committeeIndexSet bool
+	// blockID names a single block whose attestations are checked instead of
+	// a whole epoch, e.g. head or a slot.
+	blockID string
+	// watch checks new blocks as the beacon node announces them, until
+	// interrupted.
+	watch bool
+	// pool checks the attestations in the beacon node's pool for the current
+	// slot instead of any block.
+	pool bool
+	// committeesOnly prints the committees of the epoch instead of running any
+	// analysis.
+	committeesOnly bool
+	// dryRun validates the configuration against the beacon node and exits
+	// without analyzing anything.
+	dryRun bool
+	// workers is the number of block or committee requests in flight at once.
+	workers int
+	// headersFirst fetches an epoch's block headers before its blocks, so
+	// that missed slots cost no block request.
+	headersFirst bool
+	// maxRPS caps beacon node requests per second; 0 means unlimited.
+	maxRPS float64
+	output string
+	// includeValidators adds the attesting validator indices to each slot's
+	// json or jsonl record.
+	includeValidators bool
+	// resolvePubkeys adds the pubkeys of the validators listed by
+	// --include-validators or --report missing.
+	resolvePubkeys bool
+	// outputFile receives json, jsonl or csv output instead of stdout.
+	outputFile string
+	report     string
+	// metricsAddr is the listen address for Prometheus metrics, if any.
+	metricsAddr string
+	// dbPath is the SQLite database results are saved to, if any.
+	dbPath string
+	// cacheDir keeps the committees of finalized epochs across runs, and
+	// refreshCache replaces what is in it instead of reading it.
+	cacheDir     string
+	refreshCache bool
+	// force reprocesses epochs already in the database.
+	force bool
+	// checkpointFile records the last epoch processed by a range scan, and
+	// resume starts the scan after it.
+	checkpointFile string
+	resume         bool
+	// committeeSource is where the analysis takes committees from:
+	// "committees" for BeaconCommittees, or "duties" to rebuild them from
+	// attester duties and cross-check them against BeaconCommittees.
+	committeeSource string
+	// committeeEpochs, if set, replaces the epoch before and the analyzed
+	// epoch as the epochs whose committees are fetched.
+	committeeEpochs []phase0.Epoch
+	// captureDir receives the block and committees JSON of every block with a
+	// mismatch, for use as test fixtures.
+	captureDir string
+	// replayDir holds fixtures written by --capture-dir to analyze instead of
+	// asking a beacon node.
+	replayDir string
+	// logLevel is the minimum level logged; --quiet sets it to error.
+	logLevel zerolog.Level
+	// logFormat is console or json. It defaults to console when logging to a
+	// terminal.
+	logFormat string
+}
+
+// envFlags are the environment variables that can stand in for flags, for
+// deployments where flags are awkward to pass. A flag given on the command
+// line takes precedence over its variable, which takes precedence over the
+// default.
+var envFlags = []struct {
+	env  string
+	flag string
+}{
+	{"BEACON_URL", "beacon-url"},
+	{"EPOCH", "epoch"},
+	{"START_EPOCH", "start-epoch"},
+	{"END_EPOCH", "end-epoch"},
+	{"TIMEOUT", "request-timeout"},
+	{"BEARER_TOKEN", "bearer-token"},
+}
+
+// epochFlags are the flags that pick what to analyze. If any is given on the
+// command line, EPOCH, START_EPOCH and END_EPOCH are ignored, so that they
+// cannot clash with it.
+var epochFlags = []string{"epoch", "start-epoch", "end-epoch", "epochs", "dump-slot", "block-id", "watch", "pool", "replay-dir"}
+
+// applyEnv sets each flag of envFlags not given on the command line from its
+// environment variable, if that is set, parsing it as the flag would be.
+func applyEnv(fs *flag.FlagSet) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	// --timeout is an alias for --request-timeout.
+	given["request-timeout"] = given["request-timeout"] || given["timeout"]
+	epochGiven := slices.ContainsFunc(epochFlags, func(name string) bool { return given[name] })
+
+	for _, binding := range envFlags {
+		value, ok := os.LookupEnv(binding.env)
+		if !ok || value == "" || given[binding.flag] {
+			continue
+		}
+		if epochGiven && slices.Contains(epochFlags, binding.flag) {
+			continue
+		}
+		if err := fs.Set(binding.flag, value); err != nil {
+			return fmt.Errorf("invalid %s %q: %w", binding.env, value, err)
+		}
+	}
+	return nil
+}
+
+// DEFAULT_MAX_EPOCHS is the largest range scan run without --allow-large.
+const DEFAULT_MAX_EPOCHS = 1000
+
+// checkRangeSize refuses a range scan of epochs epochs if that is more than
+// --max-epochs and --allow-large was not given.
+func checkRangeSize(cfg *config, epochs uint64) error {
+	if cfg.allowLarge || epochs <= cfg.maxEpochs {
+		return nil
+	}
+	return fmt.Errorf("range scan of %d epochs exceeds --max-epochs %d; pass --allow-large to run it anyway", epochs, cfg.maxEpochs)
+}
+
+// splitBeaconURLs validates --beacon-url, which holds one URL or two separated
+// by a comma, and moves the second, if any, to compareURL.
+func splitBeaconURLs(cfg *config) error {
+	urls := strings.Split(cfg.beaconURL, ",")
+	if len(urls) > 2 {
+		return errors.New("--beacon-url takes at most two URLs")
+	}
+	for _, beaconURL := range urls {
+		u, err := url.Parse(beaconURL)
+		if err != nil {
+			return fmt.Errorf("invalid --beacon-url: %w", err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid --beacon-url %q: expected scheme and host", beaconURL)
+		}
+	}
+	cfg.beaconURL = urls[0]
+	if len(urls) == 2 {
+		cfg.compareURL = urls[1]
+	}
+	return nil
+}
+
+func parseConfig(name string, args []string) (*config, error) {
+	cfg := &config{}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&cfg.beaconURL, "beacon-url", "", "beacon node API URL, e.g. http://localhost:5052; give two separated by a comma to compare their blocks for an epoch")
+	epoch := fs.Uint64("epoch", 0, "epoch to analyze (default: latest finalized epoch)")
+	startEpoch := fs.Uint64("start-epoch", 0, "first epoch of a range to analyze (requires --end-epoch)")
+	endEpoch := fs.Uint64("end-epoch", 0, "last epoch of a range to analyze (requires --start-epoch)")
+	fs.Uint64Var(&cfg.lastEpochs, "epochs", 0, "analyze the last N finalized epochs")
+	var headers headerFlag
+	fs.Var(&headers, "header", `extra HTTP header for beacon node requests, as "Key: Value"; may be repeated`)
+	bearerToken := fs.String("bearer-token", "", "send this token as an Authorization: Bearer header with beacon node requests")
+	fs.BoolVar(&cfg.tlsSkipVerify, "tls-skip-verify", false, "do not verify the beacon node's TLS certificate; for testing only")
+	caCert := fs.String("ca-cert", "", "PEM file of CA certificates to trust for the beacon node's TLS certificate, in addition to the system's")
+	fs.DurationVar(&cfg.requestTimeout, "request-timeout", aggregation.DEFAULT_REQUEST_TIMEOUT, "timeout for each beacon node request")
+	fs.DurationVar(&cfg.requestTimeout, "timeout", aggregation.DEFAULT_REQUEST_TIMEOUT, "deprecated alias for --request-timeout")
+	fs.DurationVar(&cfg.overallTimeout, "overall-timeout", 0, "timeout for the whole run (default: none)")
+	fs.IntVar(&cfg.maxAttempts, "max-attempts", aggregation.DEFAULT_MAX_ATTEMPTS, "attempts per beacon node request before giving up on transient errors")
+	fs.DurationVar(&cfg.baseDelay, "base-delay", aggregation.DEFAULT_BASE_DELAY, "delay before the first retry of a beacon node request, doubling with each retry")
+	fs.BoolVar(&cfg.failFast, "fail-fast", false, "stop at the first aggregation bits mismatch and print everything known about that attestation")
+	fs.BoolVar(&cfg.stream, "stream", false, "in a range scan, print each epoch's summary as soon as it is analyzed, in constant memory; with --output jsonl, as one JSON record per line")
+	fs.Uint64Var(&cfg.maxEpochs, "max-epochs", DEFAULT_MAX_EPOCHS, "refuse range scans of more epochs than this unless --allow-large is given")
+	fs.BoolVar(&cfg.allowLarge, "allow-large", false, "allow range scans of more than --max-epochs epochs")
+	fs.DurationVar(&cfg.epochBudget, "epoch-budget", 0, "in a range scan, skip any epoch that takes longer than this (default: none)")
+	committeeIndex := fs.Uint64("committee-index", 0, "only analyze attestations covering this committee, with lengths and participation for it alone")
+	dumpSlot := fs.Uint64("dump-slot", 0, "print the raw attestations for this duty slot and its committees as JSON")
+	fs.StringVar(&cfg.blockID, "block-id", "", "check the attestations of a single block: head, finalized, justified, genesis, a slot or a 0x-prefixed root")
+	fs.BoolVar(&cfg.watch, "watch", false, "check each new block as it arrives, until interrupted")
+	fs.BoolVar(&cfg.pool, "pool", false, "check the attestations in the node's attestation pool for the current slot, before any block includes them")
+	fs.BoolVar(&cfg.committeesOnly, "committees-only", false, "print the committees of the epoch and their sizes, as a table or with --output json, without fetching blocks")
+	fs.BoolVar(&cfg.dryRun, "dry-run", false, "check the node can serve the requested epochs and print what would be processed, without fetching blocks")
+	fs.IntVar(&cfg.workers, "workers", aggregation.DEFAULT_BLOCK_WORKERS, "number of block or committee requests in flight at once")
+	fs.Float64Var(&cfg.maxRPS, "max-rps", aggregation.DEFAULT_MAX_RPS, "maximum beacon node requests per second; 0 means unlimited")
+	fs.BoolVar(&cfg.headersFirst, "headers-first", false, "find an epoch's missed slots from block headers before fetching its blocks, saving a block request for each missed slot")
+	fs.StringVar(&cfg.output, "output", "text", "output format: text, json, jsonl (one record per line, streamed during range scans) or csv")
+	fs.BoolVar(&cfg.includeValidators, "include-validators", false, "list the attesting validators of each slot in json and jsonl output")
+	fs.BoolVar(&cfg.resolvePubkeys, "resolve-pubkeys", false, "add validator pubkeys to --include-validators output and the missing attesters report")
+	fs.StringVar(&cfg.outputFile, "output-file", "", "write json, jsonl or csv output to this file instead of stdout")
+	fs.StringVar(&cfg.metricsAddr, "metrics-addr", "", "serve Prometheus metrics on this address, e.g. :9090")
+	fs.StringVar(&cfg.cacheDir, "cache-dir", "", "keep the committees of finalized epochs in this directory across runs, instead of fetching them again")
+	fs.BoolVar(&cfg.refreshCache, "refresh-cache", false, "fetch committees again and replace those in --cache-dir")
+	fs.StringVar(&cfg.dbPath, "db", "", "save results to this SQLite database, skipping epochs already in it")
+	fs.BoolVar(&cfg.force, "force", false, "reprocess epochs already in the --db database")
+	fs.StringVar(&cfg.checkpointFile, "checkpoint-file", "", "record the last fully processed epoch of a range scan in this file")
+	fs.BoolVar(&cfg.resume, "resume", false, "start a range scan after the epoch in --checkpoint-file; --start-epoch may then be omitted")
+	fs.StringVar(&cfg.committeeSource, "committee-source", "committees", "where to take committees from: committees, or duties to rebuild them from attester duties and cross-check them against the committees endpoint")
+	fs.StringVar(&cfg.captureDir, "capture-dir", "", "write the block and committees JSON of every block with a mismatch to this directory, as test fixtures")
+	fs.StringVar(&cfg.replayDir, "replay-dir", "", "analyze the fixtures written by --capture-dir to this directory, without a beacon node")
+	fs.StringVar(&cfg.report, "report", "", "print a report instead of the mismatch check: participation, votes, inclusion, sync, packing, missing or coverage")
+	committeeEpochs := fs.String("committee-epochs", "", "fetch committees for these epochs instead of the analyzed epoch and the one before, as a comma-separated list of epochs and ranges such as 9-10,12")
+	logLevel := fs.String("log-level", "info", "log level: trace, debug, info, warn or error")
+	quiet := fs.Bool("quiet", false, "only log errors; same as --log-level error")
+	fs.StringVar(&cfg.logFormat, "log-format", "", "log format: console or json (default: console when stderr is a terminal)")
+	for _, binding := range envFlags {
+		f := fs.Lookup(binding.flag)
+		f.Usage += fmt.Sprintf(" (env %s)", binding.env)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", name)
+		fs.PrintDefaults()
+		fmt.Fprintln(fs.Output(), "\nFlags take precedence over the environment variables named above, which take precedence over the defaults.")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if err := applyEnv(fs); err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	cfg.epoch = phase0.Epoch(*epoch)
+	cfg.epochSet = set["epoch"]
+	cfg.startEpoch = phase0.Epoch(*startEpoch)
+	cfg.endEpoch = phase0.Epoch(*endEpoch)
+	cfg.rangeSet = set["start-epoch"] || set["end-epoch"]
+	cfg.startSet = set["start-epoch"]
+	cfg.dumpSlot = phase0.Slot(*dumpSlot)
+	cfg.dumpSet = set["dump-slot"]
+	cfg.committeeIndex = phase0.CommitteeIndex(*committeeIndex)
+	cfg.committeeIndexSet = set["committee-index"]
+
+	if cfg.beaconURL == "" && cfg.replayDir == "" {
+		return nil, errors.New("--beacon-url or BEACON_URL is required")
+	}
+	if cfg.beaconURL != "" {
+		if err := splitBeaconURLs(cfg); err != nil {
+			return nil, err
+		}
+	}
+	requestHeaders, err := parseHeaders(headers, *bearerToken)
+	if err != nil {
+		return nil, err
+	}
+	cfg.headers = requestHeaders
+	cfg.tlsConfig, err = loadTLSConfig(cfg.tlsSkipVerify, *caCert)
+	if err != nil {
+		return nil, err
+	}
+	if set["timeout"] && set["request-timeout"] {
+		return nil, errors.New("--timeout is an alias for --request-timeout; give only one")
+	}
+	if cfg.requestTimeout <= 0 {
+		return nil, errors.New("--request-timeout must be positive")
+	}
+	if cfg.overallTimeout < 0 {
+		return nil, errors.New("--overall-timeout must not be negative")
+	}
+	if cfg.maxAttempts < 1 {
+		return nil, errors.New("--max-attempts must be at least 1")
+	}
+	if cfg.baseDelay < 0 {
+		return nil, errors.New("--base-delay must not be negative")
+	}
+	if cfg.epochBudget < 0 {
+		return nil, errors.New("--epoch-budget must not be negative")
+	}
+	if set["epoch-budget"] && !cfg.rangeSet && !set["epochs"] {
+		return nil, errors.New("--epoch-budget only applies to range scans with --start-epoch/--end-epoch or --epochs")
+	}
+	if cfg.stream {
+		if !cfg.rangeSet && !set["epochs"] {
+			return nil, errors.New("--stream only applies to range scans with --start-epoch/--end-epoch or --epochs")
+		}
+		if cfg.dbPath != "" || cfg.checkpointFile != "" || cfg.captureDir != "" || cfg.epochBudget > 0 || cfg.outputFile != "" || cfg.includeValidators {
+			return nil, errors.New("--stream cannot be combined with --db, --checkpoint-file, --capture-dir, --epoch-budget, --output-file or --include-validators")
+		}
+		if cfg.output != "text" && cfg.output != "jsonl" {
+			return nil, errors.New("--stream supports --output text or jsonl")
+		}
+	}
+	if cfg.maxEpochs < 1 {
+		return nil, errors.New("--max-epochs must be at least 1")
+	}
+	if set["epochs"] {
+		if err := checkRangeSize(cfg, cfg.lastEpochs); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.failFast {
+		if cfg.watch || cfg.stream || cfg.dumpSet || cfg.committeesOnly || cfg.compareURL != "" || cfg.report != "" || cfg.dryRun || cfg.output != "text" {
+			return nil, errors.New("--fail-fast only applies to the mismatch check with text output and cannot be combined with --watch, --stream, --dump-slot, --committees-only, a second --beacon-url, --report or --dry-run")
+		}
+	}
+	if cfg.refreshCache && cfg.cacheDir == "" {
+		return nil, errors.New("--refresh-cache requires --cache-dir")
+	}
+	if cfg.resume {
+		if cfg.checkpointFile == "" {
+			return nil, errors.New("--resume requires --checkpoint-file")
+		}
+		if !set["end-epoch"] {
+			return nil, errors.New("--resume requires --end-epoch")
+		}
+	}
+	if cfg.rangeSet {
+		if !set["end-epoch"] || (!set["start-epoch"] && !cfg.resume) {
+			return nil, errors.New("--start-epoch and --end-epoch must be given together")
+		}
+		if cfg.startSet && cfg.startEpoch > cfg.endEpoch {
+			return nil, fmt.Errorf("--start-epoch %d is after --end-epoch %d", cfg.startEpoch, cfg.endEpoch)
+		}
+		if cfg.startSet {
+			if err := checkRangeSize(cfg, uint64(cfg.endEpoch-cfg.startEpoch)+1); err != nil {
+				return nil, err
+			}
+		}
+	}
+	modes := 0
+	for _, given := range []bool{set["epoch"], cfg.rangeSet, set["epochs"], cfg.dumpSet, set["block-id"], cfg.watch, cfg.pool, cfg.replayDir != ""} {
+		if given {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return nil, errors.New("only one of --epoch, --start-epoch/--end-epoch, --epochs, --dump-slot, --block-id, --watch, --pool and --replay-dir may be given")
+	}
+	if cfg.replayDir != "" {
+		if cfg.report != "" || cfg.dbPath != "" || cfg.dryRun || cfg.captureDir != "" || cfg.output != "text" {
+			return nil, errors.New("--replay-dir cannot be combined with --report, --db, --dry-run, --capture-dir or --output")
+		}
+	}
+	if cfg.compareURL != "" {
+		if modes > 0 && !set["epoch"] {
+			return nil, errors.New("comparing two beacon nodes only works for a single --epoch")
+		}
+		if cfg.report != "" || cfg.output != "text" || cfg.dbPath != "" || cfg.dryRun {
+			return nil, errors.New("comparing two beacon nodes cannot be combined with --report, --output, --db or --dry-run")
+		}
+	}
+	if cfg.committeesOnly {
+		if modes > 0 && !set["epoch"] {
+			return nil, errors.New("--committees-only only works for a single --epoch")
+		}
+		if cfg.compareURL != "" || cfg.report != "" || cfg.dbPath != "" || cfg.dryRun {
+			return nil, errors.New("--committees-only cannot be combined with a second --beacon-url, --report, --db or --dry-run")
+		}
+		if cfg.output != "text" && cfg.output != "json" {
+			return nil, errors.New("--committees-only supports --output text or json")
+		}
+	}
+	if cfg.committeeIndexSet {
+		if modes > 0 && !set["epoch"] {
+			return nil, errors.New("--committee-index only works for a single --epoch")
+		}
+		if *committeeIndex >= 64 {
+			return nil, fmt.Errorf("--committee-index %d is out of range: a slot has at most 64 committees", *committeeIndex)
+		}
+		if cfg.compareURL != "" || cfg.committeesOnly || (cfg.report != "" && cfg.report != "participation") {
+			return nil, errors.New("--committee-index cannot be combined with a second --beacon-url, --committees-only or a --report other than participation")
+		}
+	}
+	switch cfg.committeeSource {
+	case "committees":
+	case "duties":
+		if modes > 0 && !set["epoch"] {
+			return nil, errors.New("--committee-source duties only works for a single --epoch")
+		}
+		if cfg.compareURL != "" {
+			return nil, errors.New("--committee-source duties cannot be combined with a second --beacon-url")
+		}
+	default:
+		return nil, fmt.Errorf("invalid --committee-source %q: expected committees or duties", cfg.committeeSource)
+	}
+	if set["committee-epochs"] {
+		if modes > 0 && !set["epoch"] {
+			return nil, errors.New("--committee-epochs only works for a single --epoch")
+		}
+		if cfg.compareURL != "" || cfg.committeesOnly || cfg.committeeSource == "duties" {
+			return nil, errors.New("--committee-epochs cannot be combined with a second --beacon-url, --committees-only or --committee-source duties")
+		}
+		epochs, err := parseEpochList(*committeeEpochs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --committee-epochs %q: %w", *committeeEpochs, err)
+		}
+		cfg.committeeEpochs = epochs
+	}
+	if cfg.captureDir != "" {
+		if cfg.dumpSet || cfg.watch || cfg.pool || cfg.compareURL != "" || cfg.committeesOnly || cfg.report != "" || cfg.dryRun {
+			return nil, errors.New("--capture-dir only applies to the mismatch check and cannot be combined with --dump-slot, --watch, --pool, a second --beacon-url, --committees-only, --report or --dry-run")
+		}
+		if !cfg.rangeSet && !set["epochs"] && !set["block-id"] && cfg.output != "text" {
+			return nil, errors.New("--capture-dir requires --output text for a single epoch")
+		}
+	}
+	if set["block-id"] && cfg.blockID == "" {
+		return nil, errors.New("--block-id must not be empty")
+	}
+	if set["epochs"] && cfg.lastEpochs == 0 {
+		return nil, errors.New("--epochs must be at least 1")
+	}
+	if cfg.workers < 1 {
+		return nil, errors.New("--workers must be at least 1")
+	}
+	if cfg.maxRPS < 0 {
+		return nil, errors.New("--max-rps must not be negative")
+	}
+	switch cfg.output {
+	case "text", "json", "jsonl", "csv":
+	default:
+		return nil, fmt.Errorf("invalid --output %q: expected text, json, jsonl or csv", cfg.output)
+	}
+	if cfg.includeValidators && cfg.output != "json" && cfg.output != "jsonl" {
+		return nil, errors.New("--include-validators requires --output json or jsonl")
+	}
+	if cfg.resolvePubkeys && !cfg.includeValidators && cfg.report != "missing" {
+		return nil, errors.New("--resolve-pubkeys requires --include-validators or --report missing")
+	}
+	if cfg.outputFile != "" && cfg.output == "text" {
+		return nil, errors.New("--output-file requires --output json, jsonl or csv")
+	}
+	switch *logLevel {
+	case "trace", "debug", "info", "warn", "error":
+		cfg.logLevel, _ = zerolog.ParseLevel(*logLevel)
+	default:
+		return nil, fmt.Errorf("invalid --log-level %q: expected trace, debug, info, warn or error", *logLevel)
+	}
+	if *quiet {
+		if set["log-level"] {
+			return nil, errors.New("--quiet and --log-level cannot be used together")
+		}
+		cfg.logLevel = zerolog.ErrorLevel
+	}
+	switch cfg.logFormat {
+	case "":
+		cfg.logFormat = "json"
+		if isTerminal(os.Stderr) {
+			cfg.logFormat = "console"
+		}
+	case "console", "json":
+	default:
+		return nil, fmt.Errorf("invalid --log-format %q: expected console or json", cfg.logFormat)
+	}
+	switch cfg.report {
+	case "", "participation", "votes", "inclusion", "sync", "packing", "missing", "coverage":
+	default:
+		return nil, fmt.Errorf("invalid --report %q: expected participation, votes, inclusion, sync, packing, missing or coverage", cfg.report)
+	}
+
+	return cfg, nil
+}
+
+// headerFlag collects the values of a repeated --header.
+type headerFlag []string
+
+func (h *headerFlag) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlag) Set(value string) error {
+	*h = append(*h, value)
+	return nil
+}
+
+// parseHeaders turns "Key: Value" headers into a map keyed by canonical header
+// name, adding an Authorization header for bearerToken if it is set. A header
+// given twice is refused rather than one silently winning.
+func parseHeaders(headers []string, bearerToken string) (map[string]string, error) {
+	if len(headers) == 0 && bearerToken == "" {
+		return nil, nil
+	}
+	result := make(map[string]string, len(headers)+1)
+	for _, header := range headers {
+		key, value, ok := strings.Cut(header, ":")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		// Name only the key, as the value may well be a secret. Without a
+		// colon there is no telling the two apart, so neither is named.
+		if !ok {
+			return nil, errors.New(`invalid --header: expected "Key: Value"`)
+		}
+		if !validHeaderName(key) {
+			return nil, fmt.Errorf(`invalid --header %q: expected "Key: Value"`, key)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("invalid --header %q: the value must not contain a line break", key)
+		}
+		key = textproto.CanonicalMIMEHeaderKey(key)
+		if _, ok := result[key]; ok {
+			return nil, fmt.Errorf("--header %s is given more than once", key)
+		}
+		result[key] = value
+	}
+	if bearerToken != "" {
+		if _, ok := result["Authorization"]; ok {
+			return nil, errors.New("--bearer-token cannot be combined with an Authorization --header")
+		}
+		if strings.ContainsAny(bearerToken, "\r\n ") {
+			return nil, errors.New("invalid --bearer-token: it must not contain spaces or line breaks")
+		}
+		result["Authorization"] = "Bearer " + bearerToken
+	}
+	return result, nil
+}
+
+// validHeaderName reports whether name is a non-empty HTTP token, as header
+// names must be.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseEpochList parses a comma-separated list of epochs and inclusive ranges
+// of them, such as 9-10,12, into sorted distinct epochs.
+func parseEpochList(value string) ([]phase0.Epoch, error) {
+	var epochs []phase0.Epoch
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.ParseUint(first, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an epoch or range", part)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.ParseUint(last, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not an epoch or range", part)
+			}
+			if start > end {
+				return nil, fmt.Errorf("range %q ends before it starts", part)
+			}
+		}
+		if end-start >= DEFAULT_MAX_EPOCHS {
+			return nil, fmt.Errorf("range %q holds more than %d epochs", part, DEFAULT_MAX_EPOCHS)
+		}
+		for epoch := start; epoch <= end; epoch++ {
+			epochs = append(epochs, phase0.Epoch(epoch))
+		}
+	}
+	slices.Sort(epochs)
+	return slices.Compact(epochs), nil
+}
+
+// isTerminal reports whether f is a character device such as a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+)
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name        string
+		headers     []string
+		bearerToken string
+		want        map[string]string
+		wantErr     string
+	}{
+		{name: "none"},
+		{
+			name:    "canonical names and trimmed values",
+			headers: []string{"x-api-key:  secret ", "Accept: application/json"},
+			want:    map[string]string{"X-Api-Key": "secret", "Accept": "application/json"},
+		},
+		{
+			name:    "value containing a colon",
+			headers: []string{"X-Forwarded-For: [::1]:80"},
+			want:    map[string]string{"X-Forwarded-For": "[::1]:80"},
+		},
+		{
+			name:        "bearer token",
+			headers:     []string{"X-Api-Key: secret"},
+			bearerToken: "token",
+			want:        map[string]string{"X-Api-Key": "secret", "Authorization": "Bearer token"},
+		},
+		{name: "no colon", headers: []string{"X-Api-Key secret"}, wantErr: "expected"},
+		{name: "empty name", headers: []string{": secret"}, wantErr: "expected"},
+		{name: "space in name", headers: []string{"X Api Key: secret"}, wantErr: "expected"},
+		{name: "line break in value", headers: []string{"X-Api-Key: a\r\nHost: evil"}, wantErr: "line break"},
+		{name: "repeated", headers: []string{"X-Api-Key: a", "x-api-key: b"}, wantErr: "more than once"},
+		{name: "bearer token with authorization header", headers: []string{"Authorization: Basic abc"}, bearerToken: "token", wantErr: "cannot be combined"},
+		{name: "bearer token with a space", bearerToken: "a b", wantErr: "must not contain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHeaders(tt.headers, tt.bearerToken)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+				}
+				if strings.Contains(err.Error(), "secret") {
+					t.Errorf("error %q reveals the header value", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseHeaders: %v", err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidHeaderName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"X-Api-Key", true},
+		{"x_api.key~1", true},
+		{"!#$%&'*+-.^_`|~", true},
+		{"", false},
+		{"X Api", false},
+		{"X-Api:", false},
+		{"X-Ápi", false},
+		{"X-Api\n", false},
+	}
+	for _, tt := range tests {
+		if got := validHeaderName(tt.name); got != tt.want {
+			t.Errorf("validHeaderName(%q): got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		check   func(*config) bool
+		wantErr string
+	}{
+		{
+			name:  "environment fills in a missing flag",
+			env:   map[string]string{"BEACON_URL": "http://env:5052", "EPOCH": "5"},
+			check: func(cfg *config) bool { return cfg.beaconURL == "http://env:5052" && cfg.epochSet && cfg.epoch == 5 },
+		},
+		{
+			name: "flag takes precedence over the environment",
+			env:  map[string]string{"BEACON_URL": "http://env:5052", "TIMEOUT": "2s"},
+			args: []string{"--beacon-url", "http://flag:5052", "--timeout", "5s"},
+			check: func(cfg *config) bool {
+				return cfg.beaconURL == "http://flag:5052" && cfg.requestTimeout == 5*time.Second
+			},
+		},
+		{
+			name:  "empty variable is ignored",
+			env:   map[string]string{"TIMEOUT": ""},
+			args:  []string{"--beacon-url", "http://flag:5052"},
+			check: func(cfg *config) bool { return cfg.requestTimeout == time.Minute },
+		},
+		{
+			name:  "epoch flag on the command line overrides every epoch variable",
+			env:   map[string]string{"EPOCH": "5", "START_EPOCH": "7"},
+			args:  []string{"--beacon-url", "http://flag:5052", "--start-epoch", "1", "--end-epoch", "2"},
+			check: func(cfg *config) bool { return !cfg.epochSet && cfg.startEpoch == 1 && cfg.endEpoch == 2 },
+		},
+		{
+			name:    "unparseable variable",
+			env:     map[string]string{"EPOCH": "latest"},
+			args:    []string{"--beacon-url", "http://flag:5052"},
+			wantErr: `invalid EPOCH "latest"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, binding := range envFlags {
+				t.Setenv(binding.env, tt.env[binding.env])
+			}
+			cfg, err := parseConfig("repro", tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseConfig: %v", err)
+			}
+			if !tt.check(cfg) {
+				t.Errorf("unexpected config %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestParseEpochList(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []phase0.Epoch
+		wantErr string
+	}{
+		{value: "12", want: []phase0.Epoch{12}},
+		{value: "9-10,12", want: []phase0.Epoch{9, 10, 12}},
+		{value: " 12 , 9-10 ", want: []phase0.Epoch{9, 10, 12}},
+		{value: "3-5,4,5-6", want: []phase0.Epoch{3, 4, 5, 6}},
+		{value: "7-7", want: []phase0.Epoch{7}},
+		{value: "", wantErr: "not an epoch"},
+		{value: "9,", wantErr: "not an epoch"},
+		{value: "a-3", wantErr: "not an epoch"},
+		{value: "3-", wantErr: "not an epoch"},
+		{value: "-3", wantErr: "not an epoch"},
+		{value: "5-3", wantErr: "ends before it starts"},
+		{value: "0-1000", wantErr: "more than 1000 epochs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseEpochList(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v and %v, want an error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseEpochList: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckRangeSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config
+		epochs  uint64
+		wantErr bool
+	}{
+		{name: "within the limit", cfg: config{maxEpochs: 10}, epochs: 9},
+		{name: "at the limit", cfg: config{maxEpochs: 10}, epochs: 10},
+		{name: "over the limit", cfg: config{maxEpochs: 10}, epochs: 11, wantErr: true},
+		{name: "over the limit with --allow-large", cfg: config{maxEpochs: 10, allowLarge: true}, epochs: 11},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRangeSize(&tt.cfg, tt.epochs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "--allow-large") {
+				t.Errorf("error %q does not mention --allow-large", err)
+			}
+		})
+	}
+}
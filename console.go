@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"regexp"
+
+	"github.com/rs/zerolog"
+)
+
+// mismatchLengths matches the computed and actual lengths at the end of the
+// messages aggregation.LogMismatches writes.
+var mismatchLengths = regexp.MustCompile(`computed=(\d+) actual=(\d+)$`)
+
+// ANSI escapes for highlighting mismatches. The console writer already makes
+// error messages bold, so the highlight starts by switching that off to leave
+// only the lengths bold.
+const (
+	ansiRed       = "\x1b[22;31m"
+	ansiBold      = "\x1b[1m"
+	ansiNotBold   = "\x1b[22m"
+	ansiResetFore = "\x1b[39m"
+)
+
+// consoleWriter returns the console log writer. Unless color is disabled,
+// because stderr is not a terminal or NO_COLOR is set, mismatch lines are
+// rendered in red with their computed and actual lengths in bold, so they
+// stand out in a long interactive run; every other line keeps the default
+// colors.
+func consoleWriter() zerolog.ConsoleWriter {
+	writer := zerolog.ConsoleWriter{Out: os.Stderr}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok || !isTerminal(os.Stderr) {
+		writer.NoColor = true
+		return writer
+	}
+	writer.FormatPrepare = func(event map[string]any) error {
+		if event[zerolog.LevelFieldName] != zerolog.LevelErrorValue {
+			return nil
+		}
+		message, ok := event[zerolog.MessageFieldName].(string)
+		if ok && mismatchLengths.MatchString(message) {
+			event[zerolog.MessageFieldName] = highlightMismatch(message)
+		}
+		return nil
+	}
+	return writer
+}
+
+// highlightMismatch colors message red and bolds its computed and actual
+// lengths.
+func highlightMismatch(message string) string {
+	bolded := mismatchLengths.ReplaceAllString(message, "computed="+ansiBold+"$1"+ansiNotBold+" actual="+ansiBold+"$2"+ansiNotBold)
+	return ansiRed + bolded + ansiResetFore
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/rs/zerolog/log"
+
+	"repro/aggregation"
+)
+
+// dryRun checks that the beacon node can serve every epoch from start to end
+// and prints what would be processed, without fetching any blocks.
+func dryRun(ctx context.Context, cfg *config, service aggregation.BeaconClient, start phase0.Epoch, end phase0.Epoch) error {
+	finalized, err := aggregation.LatestFinalizedEpoch(ctx, service)
+	if err != nil {
+		return fmt.Errorf("failed fetching latest finalized epoch: %w", err)
+	}
+	head, err := aggregation.HeadEpoch(ctx, service)
+	if err != nil {
+		return fmt.Errorf("failed fetching head epoch: %w", err)
+	}
+	if end > head {
+		return fmt.Errorf("epoch %d is in the future: the node's head is in epoch %d", end, head)
+	}
+	if end > finalized {
+		log.Warn().Uint64("epoch", uint64(end)).Uint64("finalized", uint64(finalized)).Msg("requested epochs are not finalized yet and may still be reorged")
+	}
+
+	// Committees need the state of the epoch before start, which is the
+	// first thing a node that prunes history will be missing.
+	oldest := aggregation.PreviousEpoch(start)
+	if _, err := aggregation.GetBeaconCommitees(ctx, service, oldest, oldest); err != nil {
+		return fmt.Errorf("epoch %d is outside the node's available history: %w", oldest, err)
+	}
+
+	mode := "mismatch check"
+	if cfg.report != "" {
+		mode = cfg.report + " report"
+	}
+	fmt.Fprintf(os.Stdout, "would run the %s over epochs %d to %d (%d epochs, slots %d to %d); finalized epoch is %d\n",
+		mode, start, end, end-start+1, aggregation.EpochLowestSlot(start), aggregation.EpochHighestSlot(end), finalized)
+	return nil
+}
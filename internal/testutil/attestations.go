@@ -0,0 +1,56 @@
+package testutil
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/prysmaticlabs/go-bitfield"
+)
+
+// BuildAttestation returns an Electra attestation laid out as the spec
+// requires: a committee bit set for every committee in attesters, and
+// aggregation bits that concatenate those committees' bitlists in ascending
+// committee index order, sized from committeeSizes, with each listed position
+// set. A committee may be listed with no attesters to cover it without any
+// bit set. Its data is for slot 0 with empty checkpoints; set Data.Slot as
+// needed.
+//
+// It panics if attesters names a committee missing from committeeSizes or a
+// position outside its committee, since that is a mistake in the test.
+func BuildAttestation(committeeSizes map[phase0.CommitteeIndex]int, attesters map[phase0.CommitteeIndex][]int) *electra.Attestation {
+	committeeBits := bitfield.NewBitvector64()
+	length := 0
+	for index := range attesters {
+		size, ok := committeeSizes[index]
+		if !ok {
+			panic(fmt.Sprintf("BuildAttestation: committee %d has no size", index))
+		}
+		committeeBits.SetBitAt(uint64(index), true)
+		length += size
+	}
+
+	aggregationBits := bitfield.NewBitlist(uint64(length))
+	offset := 0
+	for _, index := range slices.Sorted(maps.Keys(attesters)) {
+		size := committeeSizes[index]
+		for _, position := range attesters[index] {
+			if position < 0 || position >= size {
+				panic(fmt.Sprintf("BuildAttestation: position %d is outside committee %d of size %d", position, index, size))
+			}
+			aggregationBits.SetBitAt(uint64(offset+position), true)
+		}
+		offset += size
+	}
+
+	return &electra.Attestation{
+		AggregationBits: aggregationBits,
+		Data: &phase0.AttestationData{
+			Source: &phase0.Checkpoint{},
+			Target: &phase0.Checkpoint{},
+		},
+		CommitteeBits: committeeBits,
+	}
+}
@@ -0,0 +1,539 @@
+// Package testutil provides an in-memory beacon node and builders of test data.
+package testutil
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/attestantio/go-eth2-client/api"
+	apiv1 "github.com/attestantio/go-eth2-client/api/v1"
+	"github.com/attestantio/go-eth2-client/spec"
+	"github.com/attestantio/go-eth2-client/spec/altair"
+	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec/phase0"
+	"github.com/prysmaticlabs/go-bitfield"
+)
+
+// FakeClient serves blocks, committees, attester duties, sync committees,
+// validators, the attestation pool, single attestation events and chain
+// configuration from memory.
+// Slots without a block are reported as missed with a 404, as a beacon node
+// would. Build it with NewFakeClient and the With* helpers before use.
+type FakeClient struct {
+	mu                           sync.RWMutex
+	slotsPerEpoch                uint64
+	maxCommitteesPerSlot         uint64
+	epochsPerSyncCommitteePeriod uint64
+	finalizedEpoch               phase0.Epoch
+	genesisTime                  time.Time
+	genesisValidatorsRoot        phase0.Root
+	latency                      time.Duration
+	blockLatency                 map[phase0.Slot]time.Duration
+	blocks                       map[phase0.Slot]*spec.VersionedSignedBeaconBlock
+	committees                   []*apiv1.BeaconCommittee
+	syncCommittees               map[uint64][]phase0.ValidatorIndex
+	pool                         []*electra.Attestation
+	singles                      []*electra.SingleAttestation
+	pubkeys                      map[phase0.ValidatorIndex]phase0.BLSPubKey
+	blockFailures                map[phase0.Slot]int
+	validatorRequests            int
+
+	// requestsMu guards the request counts, which are updated by readers of
+	// everything else.
+	requestsMu        sync.Mutex
+	blockRequests     map[phase0.Slot]int
+	committeeRequests map[phase0.Epoch]int
+}
+
+// NewFakeClient returns an empty fake with mainnet's 32 slots per epoch, 64
+// committees per slot at most, 256 epochs per sync committee period and 12
+// seconds per slot, and genesis at the Unix epoch.
+func NewFakeClient() *FakeClient {
+	return &FakeClient{
+		slotsPerEpoch:                32,
+		maxCommitteesPerSlot:         64,
+		genesisTime:                  time.Unix(0, 0),
+		epochsPerSyncCommitteePeriod: 256,
+		blockLatency:                 make(map[phase0.Slot]time.Duration),
+		blocks:                       make(map[phase0.Slot]*spec.VersionedSignedBeaconBlock),
+		syncCommittees:               make(map[uint64][]phase0.ValidatorIndex),
+		pubkeys:                      make(map[phase0.ValidatorIndex]phase0.BLSPubKey),
+		blockFailures:                make(map[phase0.Slot]int),
+		blockRequests:                make(map[phase0.Slot]int),
+		committeeRequests:            make(map[phase0.Epoch]int),
+	}
+}
+
+// WithSlotsPerEpoch sets the SLOTS_PER_EPOCH reported by Spec and used to
+// group committees into epochs.
+func (f *FakeClient) WithSlotsPerEpoch(slotsPerEpoch uint64) *FakeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.slotsPerEpoch = slotsPerEpoch
+	return f
+}
+
+// WithMaxCommitteesPerSlot sets the MAX_COMMITTEES_PER_SLOT reported by Spec.
+func (f *FakeClient) WithMaxCommitteesPerSlot(maxCommittees uint64) *FakeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.maxCommitteesPerSlot = maxCommittees
+	return f
+}
+
+// WithEpochsPerSyncCommitteePeriod sets the EPOCHS_PER_SYNC_COMMITTEE_PERIOD
+// reported by Spec and used to pick the sync committee for an epoch.
+func (f *FakeClient) WithEpochsPerSyncCommitteePeriod(epochs uint64) *FakeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.epochsPerSyncCommitteePeriod = epochs
+	return f
+}
+
+// WithFinalizedEpoch sets the epoch reported as finalized by Finality.
+func (f *FakeClient) WithFinalizedEpoch(epoch phase0.Epoch) *FakeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.finalizedEpoch = epoch
+	return f
+}
+
+// WithGenesisTime sets the genesis time reported by Genesis.
+func (f *FakeClient) WithGenesisTime(genesisTime time.Time) *FakeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.genesisTime = genesisTime
+	return f
+}
+
+// WithGenesisValidatorsRoot sets the genesis validators root reported by
+// Genesis, which identifies the fake's network.
+func (f *FakeClient) WithGenesisValidatorsRoot(root phase0.Root) *FakeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.genesisValidatorsRoot = root
+	return f
+}
+
+// WithLatency makes every block request take at least latency, standing in
+// for the round trip to a real beacon node.
+func (f *FakeClient) WithLatency(latency time.Duration) *FakeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latency = latency
+	return f
+}
+
+// WithBlockLatency makes requests for the block at slot take at least
+// latency, as if that block were slow to serve; zero clears it.
+func (f *FakeClient) WithBlockLatency(slot phase0.Slot, latency time.Duration) *FakeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blockLatency[slot] = latency
+	return f
+}
+
+// WithBlock adds an Electra block at slot carrying attestations and an empty
+// sync aggregate.
+func (f *FakeClient) WithBlock(slot phase0.Slot, attestations ...*electra.Attestation) *FakeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blocks[slot] = &spec.VersionedSignedBeaconBlock{
+		Version: spec.DataVersionElectra,
+		Electra: &electra.SignedBeaconBlock{
+			Message: &electra.BeaconBlock{
+				Slot: slot,
+				Body: &electra.BeaconBlockBody{
+					Attestations: attestations,
+					SyncAggregate: &altair.SyncAggregate{
+						SyncCommitteeBits: bitfield.NewBitvector512(),
+					},
+				},
+			},
+		},
+	}
+	return f
+}
+
+// WithBlockFailure makes every request for the block at slot fail with HTTP
+// status statusCode, such as a 500 from an overloaded node, whether or not
+// there is a block.
+func (f *FakeClient) WithBlockFailure(slot phase0.Slot, statusCode int) *FakeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blockFailures[slot] = statusCode
+	return f
+}
+
+// WithSignedBlock adds block as it is, such as one loaded from a captured
+// fixture.
+func (f *FakeClient) WithSignedBlock(block *electra.SignedBeaconBlock) *FakeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blocks[block.Message.Slot] = &spec.VersionedSignedBeaconBlock{
+		Version: spec.DataVersionElectra,
+		Electra: block,
+	}
+	return f
+}
+
+// WithSyncAggregate sets the sync committee bits of the block added at slot.
+func (f *FakeClient) WithSyncAggregate(slot phase0.Slot, bits bitfield.Bitvector512) *FakeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blocks[slot].Electra.Message.Body.SyncAggregate.SyncCommitteeBits = bits
+	return f
+}
+
+// WithSyncCommittee sets the members of the sync committee for period.
+func (f *FakeClient) WithSyncCommittee(period uint64, validators []phase0.ValidatorIndex) *FakeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.syncCommittees[period] = validators
+	return f
+}
+
+// WithCommittee adds the committee index for slot. Adding the same slot and
+// index again makes BeaconCommittees report it twice, as a buggy node might.
+func (f *FakeClient) WithCommittee(slot phase0.Slot, index phase0.CommitteeIndex, validators []phase0.ValidatorIndex) *FakeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.committees = append(f.committees, &apiv1.BeaconCommittee{
+		Slot:       slot,
+		Index:      index,
+		Validators: validators,
+	})
+	return f
+}
+
+// WithBeaconCommittees adds committees as they are, such as those loaded from a
+// captured fixture.
+func (f *FakeClient) WithBeaconCommittees(committees ...*apiv1.BeaconCommittee) *FakeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.committees = append(f.committees, committees...)
+	return f
+}
+
+// WithPoolAttestations adds attestations to the attestation pool.
+func (f *FakeClient) WithPoolAttestations(attestations ...*electra.Attestation) *FakeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pool = append(f.pool, attestations...)
+	return f
+}
+
+// WithSingleAttestations adds single attestations to be delivered to every
+// single_attestation event subscription.
+func (f *FakeClient) WithSingleAttestations(singles ...*electra.SingleAttestation) *FakeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.singles = append(f.singles, singles...)
+	return f
+}
+
+// WithValidator adds a validator with pubkey at index.
+func (f *FakeClient) WithValidator(index phase0.ValidatorIndex, pubkey phase0.BLSPubKey) *FakeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pubkeys[index] = pubkey
+	return f
+}
+
+// BlockRequests returns how many times SignedBeaconBlock has been called for
+// slot.
+func (f *FakeClient) BlockRequests(slot phase0.Slot) int {
+	f.requestsMu.Lock()
+	defer f.requestsMu.Unlock()
+	return f.blockRequests[slot]
+}
+
+// CommitteeRequests returns how many times BeaconCommittees has been called
+// for epoch.
+func (f *FakeClient) CommitteeRequests(epoch phase0.Epoch) int {
+	f.requestsMu.Lock()
+	defer f.requestsMu.Unlock()
+	return f.committeeRequests[epoch]
+}
+
+// ValidatorRequests returns how many times Validators has been called.
+func (f *FakeClient) ValidatorRequests() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.validatorRequests
+}
+
+func notFound(endpoint string) error {
+	return &api.Error{
+		Method:     http.MethodGet,
+		Endpoint:   endpoint,
+		StatusCode: http.StatusNotFound,
+	}
+}
+
+// SignedBeaconBlock implements eth2client.SignedBeaconBlockProvider for
+// numeric slot block IDs.
+func (f *FakeClient) SignedBeaconBlock(ctx context.Context, opts *api.SignedBeaconBlockOpts) (*api.Response[*spec.VersionedSignedBeaconBlock], error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	slot, err := strconv.ParseUint(opts.Block, 10, 64)
+	latency := max(f.latency, f.blockLatency[phase0.Slot(slot)])
+	if latency > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(latency):
+		}
+	}
+	if err != nil {
+		return nil, notFound("/eth/v2/beacon/blocks/" + opts.Block)
+	}
+	f.requestsMu.Lock()
+	f.blockRequests[phase0.Slot(slot)]++
+	f.requestsMu.Unlock()
+	if statusCode, ok := f.blockFailures[phase0.Slot(slot)]; ok {
+		return nil, &api.Error{
+			Method:     http.MethodGet,
+			Endpoint:   "/eth/v2/beacon/blocks/" + opts.Block,
+			StatusCode: statusCode,
+		}
+	}
+	block, ok := f.blocks[phase0.Slot(slot)]
+	if !ok {
+		return nil, notFound("/eth/v2/beacon/blocks/" + opts.Block)
+	}
+	return &api.Response[*spec.VersionedSignedBeaconBlock]{Data: block}, nil
+}
+
+// BeaconBlockHeader implements eth2client.BeaconBlockHeadersProvider for
+// numeric slot block IDs.
+func (f *FakeClient) BeaconBlockHeader(ctx context.Context, opts *api.BeaconBlockHeaderOpts) (*api.Response[*apiv1.BeaconBlockHeader], error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	slot, err := strconv.ParseUint(opts.Block, 10, 64)
+	if err != nil {
+		return nil, notFound("/eth/v1/beacon/headers/" + opts.Block)
+	}
+	if _, ok := f.blocks[phase0.Slot(slot)]; !ok {
+		return nil, notFound("/eth/v1/beacon/headers/" + opts.Block)
+	}
+	return &api.Response[*apiv1.BeaconBlockHeader]{
+		Data: &apiv1.BeaconBlockHeader{
+			Canonical: true,
+			Header: &phase0.SignedBeaconBlockHeader{
+				Message: &phase0.BeaconBlockHeader{Slot: phase0.Slot(slot)},
+			},
+		},
+	}, nil
+}
+
+// BeaconCommittees implements eth2client.BeaconCommitteesProvider, returning
+// the committees for opts.Epoch.
+func (f *FakeClient) BeaconCommittees(ctx context.Context, opts *api.BeaconCommitteesOpts) (*api.Response[[]*apiv1.BeaconCommittee], error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if opts.Epoch != nil {
+		f.requestsMu.Lock()
+		f.committeeRequests[*opts.Epoch]++
+		f.requestsMu.Unlock()
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var data []*apiv1.BeaconCommittee
+	for _, committee := range f.committees {
+		if opts.Epoch != nil && uint64(committee.Slot)/f.slotsPerEpoch != uint64(*opts.Epoch) {
+			continue
+		}
+		data = append(data, committee)
+	}
+	return &api.Response[[]*apiv1.BeaconCommittee]{Data: data}, nil
+}
+
+// SyncCommittee implements eth2client.SyncCommitteesProvider, returning the
+// sync committee for the period of opts.Epoch.
+func (f *FakeClient) SyncCommittee(ctx context.Context, opts *api.SyncCommitteeOpts) (*api.Response[*apiv1.SyncCommittee], error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if opts.Epoch == nil {
+		return nil, notFound("/eth/v1/beacon/states/" + opts.State + "/sync_committees")
+	}
+	validators, ok := f.syncCommittees[uint64(*opts.Epoch)/f.epochsPerSyncCommitteePeriod]
+	if !ok {
+		return nil, notFound("/eth/v1/beacon/states/" + opts.State + "/sync_committees")
+	}
+	return &api.Response[*apiv1.SyncCommittee]{
+		Data: &apiv1.SyncCommittee{Validators: validators},
+	}, nil
+}
+
+// Spec implements eth2client.SpecProvider.
+func (f *FakeClient) Spec(ctx context.Context, _ *api.SpecOpts) (*api.Response[map[string]any], error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return &api.Response[map[string]any]{
+		Data: map[string]any{
+			"SLOTS_PER_EPOCH":                  f.slotsPerEpoch,
+			"EPOCHS_PER_SYNC_COMMITTEE_PERIOD": f.epochsPerSyncCommitteePeriod,
+			"SECONDS_PER_SLOT":                 12 * time.Second,
+			"TARGET_COMMITTEE_SIZE":            uint64(128),
+			"MAX_COMMITTEES_PER_SLOT":          f.maxCommitteesPerSlot,
+		},
+	}, nil
+}
+
+// Finality implements eth2client.FinalityProvider.
+func (f *FakeClient) Finality(ctx context.Context, _ *api.FinalityOpts) (*api.Response[*apiv1.Finality], error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return &api.Response[*apiv1.Finality]{
+		Data: &apiv1.Finality{
+			Finalized: &phase0.Checkpoint{Epoch: f.finalizedEpoch},
+		},
+	}, nil
+}
+
+// Genesis implements eth2client.GenesisProvider.
+func (f *FakeClient) Genesis(ctx context.Context, _ *api.GenesisOpts) (*api.Response[*apiv1.Genesis], error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return &api.Response[*apiv1.Genesis]{
+		Data: &apiv1.Genesis{GenesisTime: f.genesisTime, GenesisValidatorsRoot: f.genesisValidatorsRoot},
+	}, nil
+}
+
+// AttestationPool implements eth2client.AttestationPoolProvider, returning the
+// pool attestations for opts.Slot, or all of them if it is nil. The committee
+// index filter is ignored.
+func (f *FakeClient) AttestationPool(ctx context.Context, opts *api.AttestationPoolOpts) (*api.Response[[]*spec.VersionedAttestation], error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var data []*spec.VersionedAttestation
+	for _, attestation := range f.pool {
+		if opts.Slot != nil && attestation.Data.Slot != *opts.Slot {
+			continue
+		}
+		data = append(data, &spec.VersionedAttestation{
+			Version: spec.DataVersionElectra,
+			Electra: attestation,
+		})
+	}
+	return &api.Response[[]*spec.VersionedAttestation]{Data: data}, nil
+}
+
+// Events implements eth2client.EventsProvider for the single_attestation
+// topic, delivering the single attestations added, in order, from another
+// goroutine as a beacon node's event stream would. Other topics get no events.
+func (f *FakeClient) Events(ctx context.Context, opts *api.EventsOpts) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !slices.Contains(opts.Topics, "single_attestation") || opts.SingleAttestationHandler == nil {
+		return nil
+	}
+
+	f.mu.RLock()
+	singles := slices.Clone(f.singles)
+	f.mu.RUnlock()
+	go func() {
+		for _, single := range singles {
+			if ctx.Err() != nil {
+				return
+			}
+			opts.SingleAttestationHandler(ctx, single)
+		}
+	}()
+	return nil
+}
+
+// AttesterDuties implements eth2client.AttesterDutiesProvider, deriving the
+// duties of opts.Indices for opts.Epoch from the committees added.
+func (f *FakeClient) AttesterDuties(ctx context.Context, opts *api.AttesterDutiesOpts) (*api.Response[[]*apiv1.AttesterDuty], error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	committeesAtSlot := make(map[phase0.Slot]uint64)
+	for _, committee := range f.committees {
+		committeesAtSlot[committee.Slot]++
+	}
+	var data []*apiv1.AttesterDuty
+	for _, committee := range f.committees {
+		if uint64(committee.Slot)/f.slotsPerEpoch != uint64(opts.Epoch) {
+			continue
+		}
+		for position, validator := range committee.Validators {
+			if !slices.Contains(opts.Indices, validator) {
+				continue
+			}
+			data = append(data, &apiv1.AttesterDuty{
+				Slot:                    committee.Slot,
+				ValidatorIndex:          validator,
+				CommitteeIndex:          committee.Index,
+				CommitteeLength:         uint64(len(committee.Validators)),
+				CommitteesAtSlot:        committeesAtSlot[committee.Slot],
+				ValidatorCommitteeIndex: uint64(position),
+			})
+		}
+	}
+	return &api.Response[[]*apiv1.AttesterDuty]{Data: data}, nil
+}
+
+// Validators implements eth2client.ValidatorsProvider, returning the
+// validators among opts.Indices, whatever the state. Only their index and
+// pubkey are filled in.
+func (f *FakeClient) Validators(ctx context.Context, opts *api.ValidatorsOpts) (*api.Response[map[phase0.ValidatorIndex]*apiv1.Validator], error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.validatorRequests++
+	data := make(map[phase0.ValidatorIndex]*apiv1.Validator, len(opts.Indices))
+	for _, index := range opts.Indices {
+		if pubkey, ok := f.pubkeys[index]; ok {
+			data[index] = &apiv1.Validator{
+				Index:     index,
+				Validator: &phase0.Validator{PublicKey: pubkey},
+			}
+		}
+	}
+	return &api.Response[map[phase0.ValidatorIndex]*apiv1.Validator]{Data: data}, nil
+}
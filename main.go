@@ -2,149 +2,825 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"time"
+	"os"
+	"os/signal"
+	"syscall"
 
-	eth2client "github.com/attestantio/go-eth2-client"
-	"github.com/attestantio/go-eth2-client/api"
 	eth2http "github.com/attestantio/go-eth2-client/http"
-	"github.com/attestantio/go-eth2-client/spec/electra"
+	"github.com/attestantio/go-eth2-client/spec"
 	"github.com/attestantio/go-eth2-client/spec/phase0"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-)
 
-const (
-	SLOTS_PER_EPOCH = 32
+	"repro/aggregation"
 )
 
-func EpochLowestSlot(epoch phase0.Epoch) phase0.Slot {
-	return phase0.Slot(epoch * SLOTS_PER_EPOCH)
-}
+// resumeStart picks the first epoch of a resumed range scan: the epoch after
+// the checkpoint, unless --start-epoch was given explicitly, in which case it
+// is honoured even if that means reprocessing epochs.
+func resumeStart(cfg *config) (phase0.Epoch, error) {
+	checkpoint, ok, err := aggregation.ReadCheckpoint(cfg.checkpointFile)
+	if err != nil {
+		return 0, err
+	}
 
-func EpochHighestSlot(epoch phase0.Epoch) phase0.Slot {
-	return phase0.Slot(((epoch + 1) * SLOTS_PER_EPOCH) - 1)
+	switch {
+	case !ok && !cfg.startSet:
+		return 0, fmt.Errorf("no checkpoint in %s and no --start-epoch given", cfg.checkpointFile)
+	case !ok:
+		log.Info().Str("file", cfg.checkpointFile).Msg("no checkpoint yet, starting from --start-epoch")
+		return cfg.startEpoch, nil
+	case cfg.startSet:
+		if cfg.startEpoch <= checkpoint {
+			log.Warn().Uint64("start_epoch", uint64(cfg.startEpoch)).Uint64("checkpoint", uint64(checkpoint)).Msg("--start-epoch is at or before the checkpoint; epochs up to the checkpoint will be processed again")
+		}
+		return cfg.startEpoch, nil
+	default:
+		log.Info().Uint64("checkpoint", uint64(checkpoint)).Msg("resuming after checkpoint")
+		return checkpoint + 1, nil
+	}
 }
 
-func GetBlock(service eth2client.Service, slot phase0.Slot) (*electra.SignedBeaconBlock, error) {
-	provider := service.(eth2client.SignedBeaconBlockProvider)
+// exitInterrupted closes store, prints msg and exits with the conventional
+// status for a process stopped by SIGINT.
+func exitInterrupted(store *aggregation.Store, msg string) {
+	if store != nil {
+		store.Close()
+	}
+	fmt.Fprintln(os.Stderr, msg)
+	os.Exit(130)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Minute*1))
-	defer cancel()
+// writeCommittees writes layouts as JSON to --output-file, or to stdout if none
+// was given.
+func writeCommittees(cfg *config, layouts []aggregation.SlotCommitteeLayout) error {
+	out, err := openOutput(cfg)
+	if err != nil {
+		return err
+	}
+	return out.finish(aggregation.WriteCommitteesJSON(out, layouts))
+}
 
-	resp, err := provider.SignedBeaconBlock(ctx, &api.SignedBeaconBlockOpts{
-		Block: fmt.Sprintf("%v", slot),
-	})
+// writeReports writes reports in the --output format to --output-file, or to
+// stdout if none was given.
+func writeReports(cfg *config, epoch phase0.Epoch, reports []aggregation.BlockAttestationReport) error {
+	out, err := openOutput(cfg)
+	if err != nil {
+		return err
+	}
+	switch cfg.output {
+	case "csv":
+		err = aggregation.WriteCSVReports(out, epoch, reports)
+	case "jsonl":
+		err = aggregation.WriteJSONLReports(out, reports)
+	default:
+		err = aggregation.WriteJSONReports(out, reports)
+	}
+	return out.finish(err)
+}
 
+// committeesFromDuties rebuilds the committees of start to end from attester
+// duties, logs where they disagree with committees, as fetched from
+// BeaconCommittees, and returns the rebuilt ones.
+func committeesFromDuties(ctx context.Context, service aggregation.BeaconClient, start phase0.Epoch, end phase0.Epoch, committees aggregation.Committees) (aggregation.Committees, error) {
+	duties, err := aggregation.GetDutyCommittees(ctx, service, start, end, committees)
 	if err != nil {
 		return nil, err
 	}
+	disagreements := aggregation.CompareCommittees(committees, duties)
+	aggregation.LogCommitteeDisagreements(disagreements, "committees", "duties")
+	log.Info().Uint64("start_epoch", uint64(start)).Uint64("end_epoch", uint64(end)).Int("disagreements", len(disagreements)).Msg("cross-checked committees against attester duties")
+	return duties, nil
+}
 
-	if resp == nil {
-		// Missed slot
-		return nil, nil
+// beaconParameters are the options for connecting to the beacon node at
+// address, which carry the --header and --bearer-token headers and the TLS
+// configuration from --tls-skip-verify or --ca-cert.
+func beaconParameters(cfg *config, address string) []eth2http.Parameter {
+	parameters := []eth2http.Parameter{
+		eth2http.WithAddress(address),
+		eth2http.WithTimeout(cfg.requestTimeout),
+	}
+	if len(cfg.headers) > 0 {
+		parameters = append(parameters, eth2http.WithExtraHeaders(cfg.headers))
 	}
+	if cfg.tlsConfig != nil {
+		parameters = append(parameters, eth2http.WithHTTPClient(beaconHTTPClient(cfg.tlsConfig, cfg.requestTimeout)))
+	}
+	return parameters
+}
 
-	return resp.Data.Electra, err
+// openCommitteeCache sets up --cache-dir for the network with genesis
+// validators root root, caching the committees of epochs finalized as of now.
+func openCommitteeCache(ctx context.Context, cfg *config, service aggregation.BeaconClient, root phase0.Root) error {
+	finalized, err := aggregation.LatestFinalizedEpoch(ctx, service)
+	if err != nil {
+		return err
+	}
+	cache, err := aggregation.OpenCommitteeDiskCache(cfg.cacheDir, root, finalized, cfg.refreshCache)
+	if err != nil {
+		return err
+	}
+	aggregation.SetCommitteeDiskCache(cache)
+	return nil
 }
 
-func ListEpochBlocks(service eth2client.Service, epoch phase0.Epoch) (map[phase0.Slot]*electra.SignedBeaconBlock, error) {
-	result := make(map[phase0.Slot]*electra.SignedBeaconBlock, SLOTS_PER_EPOCH)
-	low := EpochLowestSlot(epoch)
-	high := EpochHighestSlot(epoch)
-	for slot := low; slot <= high; slot++ {
-		block, err := GetBlock(service, phase0.Slot(slot))
+// firstMismatch cuts mismatches down to the first one for --fail-fast, so only
+// it is logged and counted.
+func firstMismatch(cfg *config, mismatches []aggregation.Mismatch) []aggregation.Mismatch {
+	if cfg.failFast && len(mismatches) > 1 {
+		return mismatches[:1]
+	}
+	return mismatches
+}
 
+// explainMismatch prints the full detail of mismatch for --fail-fast. If
+// committees does not hold those of its duty slot, they are fetched, and if
+// service is nil, as when replaying, it does without.
+func explainMismatch(ctx context.Context, service aggregation.BeaconClient, mismatch aggregation.Mismatch, committees aggregation.Committees) {
+	if len(committees[mismatch.DutySlot]) == 0 && service != nil {
+		epoch := aggregation.SlotEpoch(mismatch.DutySlot)
+		var err error
+		committees, err = aggregation.GetBeaconCommitees(ctx, service, epoch, epoch)
 		if err != nil {
-			log.Error().Err(err)
-			continue
+			log.Error().Err(err).Msg("failed fetching the committees of the mismatch")
 		}
+	}
+	if err := aggregation.WriteMismatchDetail(os.Stdout, mismatch, committees); err != nil {
+		log.Error().Err(err).Msg("failed writing mismatch detail")
+	}
+}
 
-		if block == nil {
-			// Missed slot
-			continue
+// replay runs the mismatch check over the fixtures in dir, logging each
+// mismatch and printing a per-epoch summary, without a beacon node. With
+// --fail-fast it explains the first mismatch instead of summarizing.
+func replay(cfg *config) error {
+	blocks, committees, err := aggregation.LoadReplay(cfg.replayDir)
+	if err != nil {
+		return err
+	}
+	results := aggregation.ReplayResults(blocks, committees)
+	for _, result := range results {
+		mismatches := firstMismatch(cfg, result.Mismatches)
+		aggregation.LogMismatches(mismatches)
+		recordMismatches(len(mismatches))
+		if cfg.failFast && len(mismatches) > 0 {
+			explainMismatch(context.Background(), nil, mismatches[0], committees)
+			return nil
+		}
+	}
+	return aggregation.WriteRangeSummary(os.Stdout, results)
+}
+
+// streamRange checks the epochs from start to end with
+// aggregation.StreamEpochRange, printing each epoch's summary to stdout as
+// soon as it is done, as text or, with --output jsonl, as a JSON record.
+func streamRange(ctx context.Context, cfg *config, service aggregation.BeaconClient, start phase0.Epoch, end phase0.Epoch) error {
+	encoder := json.NewEncoder(os.Stdout)
+	var writeErr error
+	err := aggregation.StreamEpochRange(ctx, service, start, end, func(summary aggregation.EpochSummary) {
+		recordMismatches(summary.Mismatches)
+		if writeErr != nil {
+			return
 		}
+		if cfg.output == "jsonl" {
+			writeErr = encoder.Encode(summary)
+		} else {
+			writeErr = aggregation.WriteEpochSummary(os.Stdout, summary)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return writeErr
+}
+
+// electraOnlyReport reports whether report only understands Electra blocks and
+// would come out empty for earlier epochs.
+func electraOnlyReport(report string) bool {
+	switch report {
+	case "participation", "votes", "inclusion", "packing", "missing", "coverage":
+		return true
+	}
+	return false
+}
+
+// Exit codes, so that monitoring can tell a run that found mismatches from
+// one that could not complete at all. log.Fatal exits with EXIT_FATAL.
+const (
+	EXIT_FATAL      = 1
+	EXIT_MISMATCHES = 2
+)
+
+// outcome records, for the exit code, whether the run checked for mismatches
+// at all and how many it found.
+var outcome struct {
+	ran        bool
+	mismatches int
+}
+
+// recordMismatches counts mismatches found by a mismatch check towards the
+// exit code.
+func recordMismatches(mismatches int) {
+	outcome.ran = true
+	outcome.mismatches += mismatches
+}
 
-		result[slot] = block
+func main() {
+	run()
+	if !outcome.ran {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "found %d aggregation bits mismatches\n", outcome.mismatches)
+	if outcome.mismatches > 0 {
+		os.Exit(EXIT_MISMATCHES)
 	}
-	return result, nil
 }
 
-func GetBeaconCommitees(ctx context.Context, service eth2client.Service, start phase0.Epoch, end phase0.Epoch) (map[phase0.Slot]map[phase0.CommitteeIndex][]phase0.ValidatorIndex, error) {
-	provider := service.(eth2client.BeaconCommitteesProvider)
+// run does all of main's work, so that its deferred cleanup has happened by
+// the time main picks the exit code.
+func run() {
+	cfg, err := parseConfig(os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		// Not 2, as flag would, which means mismatches were found.
+		os.Exit(EXIT_FATAL)
+	}
+	zerolog.SetGlobalLevel(cfg.logLevel)
+	if cfg.logFormat == "console" {
+		log.Logger = log.Output(consoleWriter())
+	}
+
+	if cfg.tlsSkipVerify {
+		log.Warn().Msg("TLS CERTIFICATE VERIFICATION IS DISABLED: anyone between here and the beacon node can read and alter its responses; use --ca-cert outside of testing")
+	}
+	aggregation.SetRequestTimeout(cfg.requestTimeout)
+	if cfg.overallTimeout > 0 && cfg.requestTimeout >= cfg.overallTimeout {
+		log.Warn().Dur("request_timeout", cfg.requestTimeout).Dur("overall_timeout", cfg.overallTimeout).Msg("--request-timeout is not shorter than --overall-timeout, so a single slow request can use up the whole run")
+	}
+	aggregation.SetMaxRequestsPerSecond(cfg.maxRPS)
+	aggregation.SetRetry(cfg.maxAttempts, cfg.baseDelay)
+	aggregation.SetWorkers(cfg.workers)
+	aggregation.SetHeadersFirst(cfg.headersFirst)
+
+	if cfg.replayDir != "" {
+		if err := replay(cfg); err != nil {
+			log.Fatal().Err(err).Msg("failed replaying fixtures")
+		}
+		return
+	}
+
+	// The first SIGINT or SIGTERM cancels ctx so in-flight work can wind down;
+	// a second one kills the process as usual.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	go func(ctx context.Context) {
+		<-ctx.Done()
+		cancel()
+	}(ctx)
+	if cfg.overallTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, cfg.overallTimeout)
+		defer cancelTimeout()
+	}
+	httpService, err := eth2http.New(ctx, beaconParameters(cfg, cfg.beaconURL)...)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed creating service")
+	}
+	service, ok := httpService.(aggregation.BeaconClient)
+	if !ok {
+		log.Fatal().Msg("beacon client does not implement the providers the analysis needs")
+	}
+
+	if cfg.metricsAddr != "" {
+		if err := StartMetricsServer(ctx, cfg.metricsAddr); err != nil {
+			log.Fatal().Err(err).Msg("failed registering metrics")
+		}
+	}
+	aggregation.LoadSpec(ctx, service)
 
-	result := make(map[phase0.Slot]map[phase0.CommitteeIndex][]phase0.ValidatorIndex)
-	for epoch := start; epoch <= end; epoch++ {
-		resp, err := provider.BeaconCommittees(ctx, &api.BeaconCommitteesOpts{
-			State: fmt.Sprintf("%d", EpochLowestSlot(epoch)),
-			Epoch: &epoch,
-		})
+	// The network is only needed to keep saved data apart, so failing to
+	// identify it is fatal only when there is some.
+	root, rootErr := aggregation.GenesisValidatorsRoot(ctx, service)
+	if rootErr != nil {
+		log.Warn().Err(rootErr).Msg("failed identifying the beacon node's network")
+	} else {
+		log.Info().Str("network", aggregation.NetworkName(root)).Str("genesis_validators_root", fmt.Sprintf("%#x", root)).Msg("identified network")
+	}
+	if (cfg.cacheDir != "" || cfg.dbPath != "") && rootErr != nil {
+		log.Fatal().Err(rootErr).Msg("cannot check --cache-dir or --db against the beacon node's network")
+	}
+
+	if cfg.cacheDir != "" {
+		if err := openCommitteeCache(ctx, cfg, service, root); err != nil {
+			log.Fatal().Err(err).Str("dir", cfg.cacheDir).Msg("failed opening committee cache")
+		}
+	}
+
+	var store *aggregation.Store
+	if cfg.dbPath != "" {
+		store, err = aggregation.OpenStore(cfg.dbPath)
 		if err != nil {
-			return nil, err
+			log.Fatal().Err(err).Msg("failed opening database")
 		}
+		defer store.Close()
+		if err := store.CheckNetwork(ctx, root); err != nil {
+			store.Close()
+			log.Fatal().Err(err).Str("db", cfg.dbPath).Msg("refusing to use database")
+		}
+	}
+
+	var pubkeys *aggregation.PubkeyResolver
+	if cfg.resolvePubkeys {
+		pubkeys, err = aggregation.NewPubkeyResolver(service)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed setting up pubkey resolution")
+		}
+	}
+
+	if cfg.dumpSet {
+		dump, err := aggregation.DumpSlot(ctx, service, cfg.dumpSlot)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed fetching attestations")
+		}
+		if err := aggregation.WriteSlotDump(os.Stdout, dump); err != nil {
+			log.Fatal().Err(err).Msg("failed writing dump")
+		}
+		return
+	}
 
-		for _, committee := range resp.Data {
-			if _, ok := result[committee.Slot]; !ok {
-				result[committee.Slot] = make(map[phase0.CommitteeIndex][]phase0.ValidatorIndex)
+	if cfg.watch {
+		if err := aggregation.Watch(ctx, service); err != nil {
+			log.Fatal().Err(err).Msg("failed watching for blocks")
+		}
+		return
+	}
+
+	if cfg.pool {
+		slot, err := aggregation.CurrentSlot(ctx, service)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed working out the current slot")
+		}
+		mismatches, checked, err := aggregation.CheckAttestationPool(ctx, service, slot)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed checking attestation pool")
+		}
+		mismatches = firstMismatch(cfg, mismatches)
+		aggregation.LogMismatches(mismatches)
+		recordMismatches(len(mismatches))
+		if cfg.failFast && len(mismatches) > 0 {
+			explainMismatch(ctx, service, mismatches[0], nil)
+			return
+		}
+		log.Info().Uint64("slot", uint64(slot)).Int("attestations", checked).Int("mismatches", len(mismatches)).Msg("checked attestation pool")
+		return
+	}
+
+	if cfg.blockID != "" {
+		block, err := aggregation.GetBlockByID(ctx, service, cfg.blockID)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed fetching block")
+		}
+		slot, err := block.Slot()
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed reading block slot")
+		}
+		// The block can include attestations from as far back as the
+		// previous epoch.
+		epoch := aggregation.SlotEpoch(slot)
+		committees, err := aggregation.GetBeaconCommitees(ctx, service, aggregation.PreviousEpoch(epoch), epoch)
+		if err != nil {
+			log.Error().Err(err).Msg("failed fetching some beacon committees")
+		}
+		mismatches, err := aggregation.CheckBlock(block, committees)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed checking block")
+		}
+		mismatches = firstMismatch(cfg, mismatches)
+		aggregation.LogMismatches(mismatches)
+		recordMismatches(len(mismatches))
+		if cfg.captureDir != "" {
+			aggregation.CaptureMismatches(ctx, service, cfg.captureDir, map[phase0.Slot]*spec.VersionedSignedBeaconBlock{slot: block}, committees, mismatches)
+		}
+		if cfg.failFast && len(mismatches) > 0 {
+			explainMismatch(ctx, service, mismatches[0], committees)
+			return
+		}
+		log.Info().Str("block_id", cfg.blockID).Uint64("slot", uint64(slot)).Int("mismatches", len(mismatches)).Msg("checked block")
+		return
+	}
+
+	if cfg.rangeSet || cfg.lastEpochs > 0 {
+		start, end := cfg.startEpoch, cfg.endEpoch
+		if cfg.lastEpochs > 0 {
+			end, err = aggregation.LatestFinalizedEpoch(ctx, service)
+			if err != nil {
+				log.Fatal().Err(err).Msg("failed fetching latest finalized epoch")
 			}
-			if _, ok := result[committee.Slot][committee.Index]; ok {
-				fmt.Printf("result[committee.Slot][committee.Index]: %v\n", result[committee.Slot][committee.Index])
+			if uint64(end)+1 < cfg.lastEpochs {
+				log.Fatal().Uint64("finalized", uint64(end)).Uint64("epochs", cfg.lastEpochs).Msg("fewer finalized epochs than requested")
 			}
-			result[committee.Slot][committee.Index] = committee.Validators
+			start = end + 1 - phase0.Epoch(cfg.lastEpochs)
 		}
+
+		if cfg.resume {
+			start, err = resumeStart(cfg)
+			if err != nil {
+				log.Fatal().Err(err).Msg("failed resuming from checkpoint")
+			}
+			if start > end {
+				log.Info().Uint64("end_epoch", uint64(end)).Msg("checkpoint is at or past --end-epoch, nothing to do")
+				return
+			}
+			if err := checkRangeSize(cfg, uint64(end-start)+1); err != nil {
+				log.Fatal().Err(err).Msg("refusing to resume range scan")
+			}
+		}
+
+		if err := aggregation.PreflightCheck(ctx, service, start); err != nil {
+			log.Fatal().Err(err).Msg("beacon node is not ready")
+		}
+		if cfg.dryRun {
+			if err := dryRun(ctx, cfg, service, start, end); err != nil {
+				log.Fatal().Err(err).Msg("dry run failed")
+			}
+			return
+		}
+		if cfg.stream {
+			if err := streamRange(ctx, cfg, service, start, end); err != nil {
+				if ctx.Err() != nil {
+					exitInterrupted(store, "interrupted during streaming range scan")
+				}
+				log.Fatal().Err(err).Msg("failed streaming epoch range")
+			}
+			return
+		}
+		opts := aggregation.RangeOptions{
+			Store:            store,
+			Force:            cfg.force,
+			CheckpointFile:   cfg.checkpointFile,
+			IncludeAttesters: cfg.includeValidators,
+			Pubkeys:          pubkeys,
+			EpochBudget:      cfg.epochBudget,
+			CaptureDir:       cfg.captureDir,
+			StopOnMismatch:   cfg.failFast,
+			// Progress lines are for people watching a terminal.
+			Progress: cfg.logFormat == "console" && cfg.logLevel <= zerolog.InfoLevel,
+		}
+		summaryOut := os.Stdout
+		var (
+			out *output
+			// all collects the reports for --output json, a single array.
+			all []aggregation.BlockAttestationReport
+		)
+		if cfg.output != "text" {
+			out, err = openOutput(cfg)
+			if err != nil {
+				log.Fatal().Err(err).Msg("failed opening output file")
+			}
+			switch cfg.output {
+			case "jsonl":
+				opts.Reports = func(_ phase0.Epoch, reports []aggregation.BlockAttestationReport) error {
+					return aggregation.WriteJSONLReports(out, reports)
+				}
+			case "csv":
+				csvOut := aggregation.NewCSVReportWriter(out)
+				opts.Reports = csvOut.Write
+			default:
+				opts.Reports = func(_ phase0.Epoch, reports []aggregation.BlockAttestationReport) error {
+					all = append(all, reports...)
+					return nil
+				}
+			}
+			if out.path == "" {
+				// Keep the record stream on stdout parseable.
+				summaryOut = os.Stderr
+			}
+		}
+		results, err := aggregation.ProcessEpochRange(ctx, service, start, end, opts)
+		if out != nil {
+			// Only replace --output-file with a complete scan.
+			if ctx.Err() != nil && err == nil {
+				err = ctx.Err()
+			}
+			var writeErr error
+			if cfg.output == "json" && err == nil {
+				writeErr = aggregation.WriteJSONReports(out, all)
+			}
+			if finishErr := out.finish(errors.Join(err, writeErr)); err == nil && finishErr != nil {
+				log.Fatal().Err(finishErr).Msg("failed writing output file")
+			}
+		}
+		if err := aggregation.WriteRangeSummary(summaryOut, results); err != nil {
+			log.Error().Err(err).Msg("failed writing summary")
+		}
+		if ctx.Err() != nil {
+			if len(results) == 0 {
+				exitInterrupted(store, "interrupted before processing any epoch")
+			}
+			exitInterrupted(store, fmt.Sprintf("interrupted after processing epoch %d", results[len(results)-1].Epoch))
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed processing epoch range")
+		}
+		for _, result := range results {
+			recordMismatches(len(result.Mismatches))
+		}
+		if cfg.failFast && len(results) > 0 {
+			if last := results[len(results)-1]; len(last.Mismatches) > 0 {
+				explainMismatch(ctx, service, last.Mismatches[0], nil)
+			}
+		}
+		return
 	}
 
-	return result, nil
-}
+	epoch := cfg.epoch
+	if !cfg.epochSet {
+		epoch, err = aggregation.LatestFinalizedEpoch(ctx, service)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed fetching latest finalized epoch")
+		}
+		if epoch == 0 {
+			// Nothing has been finalized yet, most likely because the node is
+			// still syncing. Analyzing genesis would be meaningless.
+			log.Fatal().Msg("beacon node reports no finalized epoch; is it synced? Pass --epoch to analyze a specific epoch")
+		}
+		log.Info().Uint64("epoch", uint64(epoch)).Msg("using latest finalized epoch")
+	}
+	if err := aggregation.PreflightCheck(ctx, service, epoch); err != nil {
+		log.Fatal().Err(err).Msg("beacon node is not ready")
+	}
+	if cfg.compareURL != "" {
+		if err := compareNodes(ctx, cfg, service, epoch); err != nil {
+			log.Fatal().Err(err).Msg("failed comparing beacon nodes")
+		}
+		return
+	}
+	if cfg.committeesOnly {
+		committees, err := aggregation.GetBeaconCommitees(ctx, service, epoch, epoch)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed fetching beacon committees")
+		}
+		if cfg.committeeSource == "duties" {
+			committees, err = committeesFromDuties(ctx, service, epoch, epoch, committees)
+			if err != nil {
+				log.Fatal().Err(err).Msg("failed rebuilding committees from attester duties")
+			}
+		}
+		aggregation.LogCommitteeAnomalies(aggregation.CheckCommitteeConsistency(epoch, committees))
+		layouts := aggregation.CommitteeLayouts(epoch, committees)
+		if cfg.output == "json" {
+			err = writeCommittees(cfg, layouts)
+		} else {
+			err = aggregation.WriteCommitteeTable(os.Stdout, layouts)
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed writing committees")
+		}
+		return
+	}
+	if electraOnlyReport(cfg.report) {
+		forkEpoch, err := aggregation.ElectraForkEpoch(ctx, service)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed fetching the Electra fork epoch")
+		}
+		if epoch < forkEpoch {
+			log.Fatal().Uint64("epoch", uint64(epoch)).Uint64("electra_fork_epoch", uint64(forkEpoch)).Msgf("--report %s only analyzes Electra attestations; pick an epoch at or after the fork, or drop --report for the mismatch check, which supports all forks", cfg.report)
+		}
+	}
+	if cfg.dryRun {
+		if err := dryRun(ctx, cfg, service, epoch, epoch); err != nil {
+			log.Fatal().Err(err).Msg("dry run failed")
+		}
+		return
+	}
 
-func main() {
-	beacon_api_url := "..."       // Put your beacon node URL (http) here
-	epoch := phase0.Epoch(...) // Grab the latest finalized epoch number e.g. from https://beaconcha.in/
-	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	if store != nil && !cfg.force {
+		stored, err := store.HasEpoch(ctx, epoch)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed querying database")
+		}
+		if stored {
+			log.Info().Uint64("epoch", uint64(epoch)).Msg("epoch already stored, pass --force to reprocess")
+			return
+		}
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Minute*1))
-	defer cancel()
-	service, err := eth2http.New(ctx, eth2http.WithAddress(beacon_api_url), eth2http.WithTimeout(time.Minute))
+	var epochBlocks map[phase0.Slot]*spec.VersionedSignedBeaconBlock
+	epochBlocks, err = aggregation.ListEpochBlocksConcurrent(ctx, service, phase0.Epoch(epoch), cfg.workers)
+	if ctx.Err() != nil {
+		exitInterrupted(store, fmt.Sprintf("interrupted while processing epoch %d", epoch))
+	}
 	if err != nil {
-		log.Fatal().Msg("failed creating service")
+		log.Fatal().Err(err).Msg("failed listing epoch blocks")
 	}
 
-	var epochBlocks map[phase0.Slot]*electra.SignedBeaconBlock
-	epochBlocks, err = ListEpochBlocks(service, phase0.Epoch(epoch))
+	var committees aggregation.Committees
+	committeeLogLevel := zerolog.DebugLevel
+	if cfg.committeeEpochs != nil {
+		committees, err = aggregation.GetCommitteesForEpochs(ctx, service, cfg.committeeEpochs)
+		// The point of choosing the epochs is seeing where lookups land.
+		committeeLogLevel = zerolog.InfoLevel
+	} else {
+		committees, err = aggregation.GetBeaconCommitees(ctx, service, aggregation.PreviousEpoch(epoch), epoch)
+	}
 	if err != nil {
-		log.Fatal().Msg("failed listing epoch blocks")
+		// Carry on with what was fetched; slots without committees will
+		// show up as mismatches.
+		log.Error().Err(err).Msg("failed fetching some beacon committees")
+	}
+	aggregation.LogCommitteeEpochs(epoch, committees, committeeLogLevel)
+	if cfg.committeeSource == "duties" {
+		committees, err = committeesFromDuties(ctx, service, aggregation.PreviousEpoch(epoch), epoch, committees)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed rebuilding committees from attester duties")
+		}
 	}
 
-	committees := make(map[phase0.Slot]map[phase0.CommitteeIndex][]phase0.ValidatorIndex)
-	committees, err = GetBeaconCommitees(ctx, service, phase0.Epoch(epoch-1), phase0.Epoch(epoch))
+	if store != nil {
+		if err := store.SaveEpoch(ctx, epoch, aggregation.BuildBlockReports(epoch, epochBlocks, committees)); err != nil {
+			log.Error().Err(err).Msg("failed saving results")
+		}
+	}
 
-	fmt.Printf("EpochLowestSlot(epoch): %v\n", EpochLowestSlot(epoch))
-	fmt.Printf("EpochHighestSlot(epoch): %v\n", EpochHighestSlot(epoch))
+	if cfg.report == "participation" {
+		// Late attestations for this epoch are included in the next one.
+		blocks, err := aggregation.WithNextEpochBlocks(ctx, service, epoch, epochBlocks, cfg.workers)
+		if err != nil {
+			log.Error().Err(err).Msg("failed listing next epoch blocks")
+		}
+		if cfg.committeeIndexSet {
+			err = aggregation.WriteCommitteeParticipationTable(os.Stdout, epoch, blocks, committees, cfg.committeeIndex)
+		} else {
+			err = aggregation.WriteParticipationTable(os.Stdout, epoch, blocks, committees)
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed writing participation report")
+		}
+		return
+	}
 
-	for _, block := range epochBlocks {
-		blockSlot := block.Message.Slot
-		// Attestations for a slot duty appear on the following blocks.
-		dutySlot := blockSlot - 1
+	if cfg.report == "votes" {
+		// Late attestations for this epoch are included in the next one.
+		blocks, err := aggregation.WithNextEpochBlocks(ctx, service, epoch, epochBlocks, cfg.workers)
+		if err != nil {
+			log.Error().Err(err).Msg("failed listing next epoch blocks")
+		}
+		roots, err := aggregation.VoteRoots(ctx, service, epoch, blocks, cfg.workers)
+		if err != nil {
+			log.Error().Err(err).Msg("failed listing checkpoint blocks; their votes are not counted as correct")
+		}
+		if err := aggregation.WriteVoteSummary(os.Stdout, aggregation.SummarizeVotes(epoch, blocks, roots)); err != nil {
+			log.Fatal().Err(err).Msg("failed writing vote report")
+		}
+		return
+	}
 
-		// Committee is known for slot so calculate the expected length here.
-		expectedCommitteeLength := 0
-		for _, validators := range committees[dutySlot] {
-			expectedCommitteeLength += len(validators)
+	if cfg.report == "inclusion" {
+		// Late attestations for this epoch are included in the next one.
+		blocks, err := aggregation.WithNextEpochBlocks(ctx, service, epoch, epochBlocks, cfg.workers)
+		if err != nil {
+			log.Error().Err(err).Msg("failed listing next epoch blocks")
 		}
+		if err := aggregation.WriteInclusionHistogram(os.Stdout, aggregation.BuildInclusionHistogram(epoch, blocks)); err != nil {
+			log.Fatal().Err(err).Msg("failed writing inclusion report")
+		}
+		return
+	}
 
-		for _, attestation := range block.Message.Body.Attestations {
-			// Only include attestations that match the duty slot.
-			if attestation.Data.Slot == dutySlot {
-				committeesLen := 0
-				for _, committeeIndex := range attestation.CommitteeBits.BitIndices() {
-					committeesLen += len(committees[attestation.Data.Slot][phase0.CommitteeIndex(committeeIndex)])
-				}
-				if attestation.AggregationBits.Len() != uint64(committeesLen) {
-					log.Error().Msgf("length mismatch (attestation.slot=%v block.slot=%v): computed=%v actual=%v", attestation.Data.Slot, block.Message.Slot, committeesLen, attestation.AggregationBits.Len())
+	if cfg.report == "coverage" {
+		// Late aggregates for this epoch are included in the next one.
+		blocks, err := aggregation.WithNextEpochBlocks(ctx, service, epoch, epochBlocks, cfg.workers)
+		if err != nil {
+			log.Error().Err(err).Msg("failed listing next epoch blocks")
+		}
+		if err := aggregation.WriteCoverageReport(os.Stdout, epoch, blocks, committees); err != nil {
+			log.Fatal().Err(err).Msg("failed writing coverage report")
+		}
+		return
+	}
+
+	if cfg.report == "sync" {
+		participation, err := aggregation.SyncCommitteeParticipation(ctx, service, epochBlocks)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed computing sync committee participation")
+		}
+		if err := aggregation.WriteSyncParticipation(os.Stdout, participation); err != nil {
+			log.Fatal().Err(err).Msg("failed writing sync report")
+		}
+		return
+	}
+
+	if cfg.report == "missing" {
+		// The last slot's attestations are all included in the next epoch.
+		blocks, err := aggregation.WithNextEpochBlocks(ctx, service, epoch, epochBlocks, cfg.workers)
+		if err != nil {
+			log.Error().Err(err).Msg("failed listing next epoch blocks")
+		}
+		var resolved map[phase0.ValidatorIndex]phase0.BLSPubKey
+		if pubkeys != nil {
+			resolved, err = pubkeys.Resolve(ctx, aggregation.EpochMissingAttesters(epoch, blocks, committees))
+			if err != nil {
+				log.Fatal().Err(err).Msg("failed resolving pubkeys")
+			}
+		}
+		if err := aggregation.WriteMissingAttesters(os.Stdout, epoch, blocks, committees, resolved); err != nil {
+			log.Fatal().Err(err).Msg("failed writing missing attesters report")
+		}
+		return
+	}
+
+	if cfg.report == "packing" {
+		if err := aggregation.WritePackingTable(os.Stdout, epochBlocks, committees); err != nil {
+			log.Fatal().Err(err).Msg("failed writing packing report")
+		}
+		return
+	}
+
+	if cfg.output != "text" {
+		reports := aggregation.BuildBlockReports(epoch, epochBlocks, committees)
+		if cfg.committeeIndexSet {
+			aggregation.FilterReportsByCommittee(reports, committees, cfg.committeeIndex)
+		}
+		if cfg.includeValidators {
+			aggregation.AddAttesters(reports, epochBlocks, committees)
+			if pubkeys != nil {
+				if err := aggregation.AddAttesterPubkeys(ctx, pubkeys, reports); err != nil {
+					log.Fatal().Err(err).Msg("failed resolving pubkeys")
 				}
 			}
+		}
+		if err := writeReports(cfg, epoch, reports); err != nil {
+			log.Fatal().Err(err).Msg("failed writing report")
+		}
+		return
+	}
+
+	if cfg.logLevel <= zerolog.InfoLevel {
+		fmt.Printf("EpochLowestSlot(epoch): %v\n", aggregation.EpochLowestSlot(epoch))
+		fmt.Printf("EpochHighestSlot(epoch): %v\n", aggregation.EpochHighestSlot(epoch))
+	}
+
+	// The summary counts the attestations included late, in the next epoch.
+	withNext, err := aggregation.WithNextEpochBlocks(ctx, service, epoch, epochBlocks, cfg.workers)
+	if err != nil {
+		log.Error().Err(err).Msg("failed listing next epoch blocks")
+	}
+	mismatches, err := aggregation.FindEpochMismatches(ctx, service, epoch, withNext, committees)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed checking epoch")
+	}
+	aggregation.RecordEpochMetrics(epoch, len(epochBlocks), len(mismatches))
+	if cfg.committeeIndexSet {
+		mismatches = aggregation.FilterMismatchesByCommittee(mismatches, cfg.committeeIndex)
+	}
+	mismatches = firstMismatch(cfg, mismatches)
+	aggregation.LogMismatches(mismatches)
+	recordMismatches(len(mismatches))
+	if cfg.captureDir != "" {
+		aggregation.CaptureMismatches(ctx, service, cfg.captureDir, epochBlocks, committees, mismatches)
+	}
+	if cfg.failFast && len(mismatches) > 0 {
+		explainMismatch(ctx, service, mismatches[0], committees)
+		return
+	}
+	aggregation.LogDoubleVotes(epoch, epochBlocks, committees)
+	aggregation.LogDistinctAttestationData(epoch, epochBlocks)
+	aggregation.LogCommitteeAnomalies(aggregation.CheckCommitteeConsistency(epoch, committees))
 
+	for _, block := range aggregation.SortedBlocks(epochBlocks) {
+		blockSlot, err := block.Slot()
+		if err != nil {
+			log.Error().Err(err).Msg("failed reading block slot")
+			continue
 		}
-		log.Info().Msgf("dutySlot: %d, blockSlot: %d, committeeLength: %d", dutySlot, blockSlot, expectedCommitteeLength)
+		// Attestations for a slot duty appear on the following blocks.
+		dutySlot := blockSlot - 1
+
+		committeeLength := committees.Size(dutySlot)
+		if cfg.committeeIndexSet {
+			committeeLength = committees.CommitteeSize(dutySlot, cfg.committeeIndex)
+		}
+		log.Debug().Msgf("dutySlot: %d, blockSlot: %d, committeeLength: %d", dutySlot, blockSlot, committeeLength)
+	}
+
+	if cfg.committeeIndexSet {
+		reports := aggregation.BuildBlockReports(epoch, epochBlocks, committees)
+		aggregation.FilterReportsByCommittee(reports, committees, cfg.committeeIndex)
+		fmt.Printf("committee %d:\n", cfg.committeeIndex)
+		if err := aggregation.WriteCommitteeSegments(os.Stdout, reports); err != nil {
+			log.Error().Err(err).Msg("failed writing committee segments")
+		}
+	}
+
+	if err := aggregation.WriteEpochSummary(os.Stdout, aggregation.Summarize(epoch, withNext, committees, mismatches)); err != nil {
+		log.Error().Err(err).Msg("failed writing summary")
 	}
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// output is where json, jsonl and csv results are written: stdout, or a
+// temporary file next to --output-file that replaces it once all results have
+// been written, so that a failed run never leaves a partial file in place of
+// a previous good one.
+type output struct {
+	*os.File
+	// path is --output-file, or empty for stdout.
+	path string
+}
+
+// openOutput opens the output for cfg: a temporary file for --output-file, or
+// stdout if none was given. The caller must call finish on it.
+func openOutput(cfg *config) (*output, error) {
+	if cfg.outputFile == "" {
+		return &output{File: os.Stdout}, nil
+	}
+	file, err := os.CreateTemp(filepath.Dir(cfg.outputFile), "."+filepath.Base(cfg.outputFile)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	// CreateTemp makes the file private to the user; match os.Create.
+	if err := file.Chmod(0o644); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &output{File: file, path: cfg.outputFile}, nil
+}
+
+// finish completes the output given the error, if any, from writing to it. On
+// success the temporary file is synced and renamed over --output-file;
+// otherwise it is removed and the previous file left alone. It returns err,
+// or the error finishing the output.
+func (o *output) finish(err error) error {
+	if o.path == "" {
+		return err
+	}
+	if err != nil {
+		o.Close()
+		os.Remove(o.Name())
+		return err
+	}
+	if err := errors.Join(o.Sync(), o.Close()); err != nil {
+		os.Remove(o.Name())
+		return err
+	}
+	if err := os.Rename(o.Name(), o.path); err != nil {
+		os.Remove(o.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// loadTLSConfig builds the TLS configuration for beacon node connections from
+// --tls-skip-verify and --ca-cert, or returns nil if neither is given so the
+// system defaults apply. The CA bundle is added to the system's roots rather
+// than replacing them.
+func loadTLSConfig(skipVerify bool, caCert string) (*tls.Config, error) {
+	if !skipVerify && caCert == "" {
+		return nil, nil
+	}
+	if skipVerify {
+		if caCert != "" {
+			return nil, errors.New("--tls-skip-verify and --ca-cert cannot be combined: skipping verification ignores the CA")
+		}
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+
+	pem, err := os.ReadFile(caCert)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --ca-cert: %w", err)
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("invalid --ca-cert %s: no PEM certificates found", caCert)
+	}
+	return &tls.Config{RootCAs: roots}, nil
+}
+
+// beaconHTTPClient is the HTTP client for beacon node connections with
+// tlsConfig. Its transport matches the one eth2http builds by default, which
+// cannot be given a TLS configuration of its own.
+func beaconHTTPClient(tlsConfig *tls.Config, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout:   timeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSClientConfig:     tlsConfig,
+			MaxIdleConns:        64,
+			MaxConnsPerHost:     64,
+			MaxIdleConnsPerHost: 64,
+			IdleConnTimeout:     600 * time.Second,
+		},
+	}
+}